@@ -24,6 +24,103 @@ var ClientCommands = []*cli.Command{
 		Action: client.UpdateCmd,
 	},
 
+	{
+		Name:  "mail",
+		Usage: "Manage outgoing mail",
+		Commands: []*cli.Command{
+			{
+				Name:      "test",
+				Usage:     "Send a test message through the app's mail settings",
+				ArgsUsage: "<address>",
+				Action:    client.MailTestCmd,
+			},
+		},
+	},
+
+	{
+		Name:  "maintenance",
+		Usage: "Show a maintenance page instead of the app",
+		Commands: []*cli.Command{
+			{
+				Name:  "on",
+				Usage: "Turn maintenance mode on, the allowed IPs can still reach the app",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "message",
+						Usage: "Message to show on the maintenance page",
+					},
+				},
+				Action: client.MaintenanceOnCmd,
+			},
+			{
+				Name:   "off",
+				Usage:  "Turn maintenance mode off",
+				Action: client.MaintenanceOffCmd,
+			},
+		},
+	},
+
+	{
+		Name:  "certs",
+		Usage: "Manage TLS certificates",
+		Commands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the certificates being served, and when they expire",
+				Action: client.CertsListCmd,
+			},
+			{
+				Name:      "renew",
+				Usage:     "Renew a domain's certificate now, even if it isn't due",
+				ArgsUsage: "<domain>",
+				Action:    client.CertsRenewCmd,
+			},
+			{
+				Name:  "events",
+				Usage: "Show what has happened with the certificates recently, such as renewals and failures",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "follow",
+						Aliases: []string{"f"},
+						Usage:   "Keep showing new events as they happen",
+					},
+				},
+				Action: client.CertsEventsCmd,
+			},
+		},
+	},
+
+	{
+		Name:  "alerts",
+		Usage: "Manage alert notifications",
+		Commands: []*cli.Command{
+			{
+				Name:   "test",
+				Usage:  "Send a test alert to the configured webhooks and email addresses",
+				Action: client.AlertsTestCmd,
+			},
+		},
+	},
+
+	{
+		Name:  "top",
+		Usage: "Show the CPU, memory, network and disk usage of each container, refreshed live",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "interval",
+				Usage: "Seconds between refreshes",
+				Value: 2,
+			},
+		},
+		Action: client.TopCmd,
+	},
+
+	{
+		Name:   "ratelimits",
+		Usage:  "Show the proxy rate limit counters and banned clients",
+		Action: client.RateLimitsCmd,
+	},
+
 	{
 		Name:  "restart",
 		Usage: "Restart the NodeISP server",
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manifoldco/promptui"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+var ConfigCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Manage the NodeISP configuration file",
+	Commands: []*cli.Command{
+		{
+			Name:  "migrate",
+			Usage: "Upgrade the configuration file to the current schema, backing up the original first",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				res, err := config.Migrate(config.File)
+				if err != nil {
+					return err
+				}
+
+				if res.Backup == "" {
+					fmt.Printf("%s is already at version %d\n", config.File, res.To)
+				} else {
+					fmt.Printf("Migrated %s from version %d to %d\n", config.File, res.From, res.To)
+					fmt.Printf("The original configuration was saved to %s\n", res.Backup)
+				}
+
+				for _, u := range res.Unknown {
+					fmt.Printf("Warning: unknown key, it will be ignored: %s\n", u)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "hash-password",
+			Usage: "Hash a password with bcrypt, for the users of an access policy",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				prompt := promptui.Prompt{
+					Label: "Password",
+					Mask:  '*',
+					Validate: func(s string) error {
+						if s == "" {
+							return fmt.Errorf("password is required")
+						}
+						return nil
+					},
+				}
+
+				password, err := prompt.Run()
+				if err != nil {
+					return err
+				}
+
+				hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+				if err != nil {
+					return err
+				}
+
+				fmt.Println(string(hash))
+
+				return nil
+			},
+		},
+	},
+}
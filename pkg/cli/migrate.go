@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"github.com/urfave/cli/v3"
+
+	"github.com/node-isp/node-isp/pkg/migrate"
+	"github.com/node-isp/node-isp/pkg/setup"
+)
+
+var MigrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "Migrate an existing NodeISP deployment to the NodeISP server",
+	Commands: []*cli.Command{
+		{
+			Name:  "from-compose",
+			Usage: "Migrate a docker-compose deployment, its .env file and data, to the NodeISP server",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "The directory containing the docker-compose.yaml and .env files",
+					Value: "/opt/nodeisp",
+				},
+				&cli.StringFlag{
+					Name:  "project",
+					Usage: "The compose project name, defaults to the name of the directory",
+				},
+				&cli.StringFlag{
+					Name:     "email",
+					Usage:    "The email address to register the TLS certificates with",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "data",
+					Usage: "The storage directory to copy the data into",
+					Value: "/var/lib/node-isp/",
+				},
+				&cli.StringFlag{
+					Name:  "logs",
+					Usage: "The directory to write logs to",
+					Value: "/var/log/node-isp/",
+				},
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "Overwrite an existing configuration and data",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				err := migrate.FromCompose(ctx, migrate.ComposeOptions{
+					Dir:     cmd.String("dir"),
+					Project: cmd.String("project"),
+					Email:   cmd.String("email"),
+					Data:    cmd.String("data"),
+					Logs:    cmd.String("logs"),
+					Force:   cmd.Bool("force"),
+				})
+				if err != nil {
+					return err
+				}
+
+				if _, err := os.Stat("/etc/systemd/system"); err != nil {
+					fmt.Println("Start NodeISP with `nodeisp server`, then check it with `nodeisp migrate verify`")
+					return nil
+				}
+
+				setup.InstallService()
+
+				return migrate.Verify(ctx, 10*time.Minute)
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "Wait for all the services of a migrated deployment to be running",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "How long to wait for the services to start",
+					Value: 10 * time.Minute,
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				return migrate.Verify(ctx, cmd.Duration("timeout"))
+			},
+		},
+		{
+			Name:  "rollback",
+			Usage: "Stop the NodeISP server, and start the docker-compose deployment it was migrated from",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "yes",
+					Usage: "Don't ask for confirmation before discarding the changes made since the migration",
+				},
+			},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				state, err := migrate.LoadState()
+				if err != nil {
+					return err
+				}
+
+				if !cmd.Bool("yes") {
+					fmt.Printf("The docker-compose deployment in %s still has its data from %s, when it was migrated.\n",
+						state.ComposeDir, state.MigratedAt.Local().Format(time.RFC1123))
+					fmt.Println("Everything written to NodeISP since then, such as new customers, services and invoices, will not be in it.")
+					fmt.Println("The NodeISP data is left in its storage directory, but it won't be copied back.")
+
+					prompt := promptui.Prompt{
+						Label:     "Roll back and discard the changes made since the migration",
+						IsConfirm: true,
+					}
+
+					if _, err := prompt.Run(); err != nil {
+						return fmt.Errorf("rollback cancelled")
+					}
+				}
+
+				return migrate.Rollback(ctx)
+			},
+		},
+	},
+}
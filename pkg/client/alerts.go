@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v3"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+func AlertsTestCmd(ctx context.Context, _ *cli.Command) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	r, err := c.TestAlerts(ctx, &pb.TestAlertsRequest{})
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+
+	t.SetTitle("Test Alert")
+	t.AppendHeader(table.Row{"Notifier", "Result"})
+
+	failed := 0
+	for _, n := range r.Notifiers {
+		result := "sent"
+		if !n.Success {
+			result = n.Error
+			failed++
+		}
+
+		t.AppendRow(table.Row{n.Name, result})
+	}
+
+	fmt.Println(t.Render())
+
+	if failed > 0 {
+		return fmt.Errorf("the test alert could not be sent to %d of %d notifiers", failed, len(r.Notifiers))
+	}
+
+	return nil
+}
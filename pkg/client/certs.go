@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v3"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+func CertsListCmd(ctx context.Context, _ *cli.Command) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	r, err := c.ListCertificates(ctx, &pb.ListCertificatesRequest{})
+	if err != nil {
+		return err
+	}
+
+	if len(r.Certificates) == 0 {
+		fmt.Println("No certificates are being served")
+		return nil
+	}
+
+	t := table.NewWriter()
+
+	t.SetTitle("Certificates")
+	t.AppendHeader(table.Row{"Domains", "Issuer", "Serial", "Not Before", "Not After", "OCSP", "Renewal"})
+
+	for _, cert := range r.Certificates {
+		var notBefore, notAfter string
+		if cert.NotAfter != nil {
+			notBefore = cert.NotBefore.AsTime().Local().Format(time.DateTime)
+			notAfter = fmt.Sprintf("%s (%d days)", cert.NotAfter.AsTime().Local().Format(time.DateTime), int(time.Until(cert.NotAfter.AsTime()).Hours()/24))
+		}
+
+		renewal := "from disk"
+		if cert.Managed {
+			renewal = "ACME"
+		}
+		if cert.RenewalError != "" {
+			renewal = "failed: " + cert.RenewalError
+		}
+
+		t.AppendRow(table.Row{strings.Join(cert.Names, "\n"), cert.Issuer, cert.Serial, notBefore, notAfter, cert.OcspStatus, renewal})
+	}
+
+	fmt.Println(t.Render())
+
+	return nil
+}
+
+func CertsRenewCmd(ctx context.Context, cmd *cli.Command) error {
+	domain := cmd.Args().First()
+	if domain == "" {
+		return fmt.Errorf("a domain to renew the certificate for is required")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	fmt.Printf("Renewing the certificate for %s, this can take a few minutes\r\n", domain)
+
+	r, err := c.RenewCertificate(ctx, &pb.RenewCertificateRequest{Domain: domain})
+	if err != nil {
+		return err
+	}
+
+	if r.Certificate == nil || r.Certificate.NotAfter == nil {
+		fmt.Println("Certificate renewed")
+		return nil
+	}
+
+	fmt.Printf("Certificate renewed, serial %s valid until %s\r\n", r.Certificate.Serial, r.Certificate.NotAfter.AsTime().Local().Format(time.RFC1123))
+
+	return nil
+}
+
+func CertsEventsCmd(ctx context.Context, cmd *cli.Command) error {
+	if !cmd.Bool("follow") {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+	}
+
+	stream, err := c.CertificateEvents(ctx, &pb.CertificateEventsRequest{Follow: cmd.Bool("follow")})
+	if err != nil {
+		return err
+	}
+
+	events := 0
+
+	for {
+		e, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if events == 0 {
+				fmt.Println("No certificate events since NodeISP started")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		events++
+		fmt.Println(certEventLine(e))
+	}
+}
+
+func certEventLine(e *pb.CertificateEvent) string {
+	line := fmt.Sprintf("%s  %-18s %s", e.Time.AsTime().Local().Format(time.DateTime), e.Event, strings.Join(e.Domains, ", "))
+
+	if e.Renewal {
+		line += " (renewal)"
+	}
+	if e.Issuer != "" {
+		line += " from " + e.Issuer
+	}
+	if e.Error != "" {
+		line += ": " + e.Error
+	}
+
+	return line
+}
@@ -10,6 +10,6 @@ import (
 var c pb.NodeISPServiceClient
 
 func init() {
-	conn, _ := grpc.NewClient("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, _ := grpc.NewClient(pb.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	c = pb.NewNodeISPServiceClient(conn)
 }
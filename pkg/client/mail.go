@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+func MailTestCmd(ctx context.Context, cmd *cli.Command) error {
+	address := cmd.Args().First()
+	if address == "" {
+		return fmt.Errorf("an address to send the test message to is required")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	r, err := c.SendTestMail(ctx, &pb.SendTestMailRequest{Address: address})
+	if err != nil {
+		return err
+	}
+
+	if !r.Success {
+		return fmt.Errorf("failed to send test message: %s", r.Message)
+	}
+
+	fmt.Printf("Test message sent: %s\r\n", r.Message)
+
+	return nil
+}
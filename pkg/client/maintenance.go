@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+func MaintenanceOnCmd(ctx context.Context, cmd *cli.Command) error {
+	return setMaintenance(ctx, true, cmd.String("message"))
+}
+
+func MaintenanceOffCmd(ctx context.Context, _ *cli.Command) error {
+	return setMaintenance(ctx, false, "")
+}
+
+func setMaintenance(ctx context.Context, enabled bool, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	r, err := c.SetMaintenance(ctx, &pb.SetMaintenanceRequest{Enabled: enabled, Message: message})
+	if err != nil {
+		return err
+	}
+
+	if !r.Enabled {
+		fmt.Println("Maintenance mode is off")
+		return nil
+	}
+
+	fmt.Printf("Maintenance mode is on since %s: %s\r\n", r.Since.AsTime().Local().Format(time.RFC1123), r.Message)
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v3"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+func RateLimitsCmd(ctx context.Context, _ *cli.Command) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	r, err := c.GetRateLimits(ctx, &pb.GetRateLimitsRequest{})
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+
+	t.SetTitle("Rate Limits")
+	t.AppendHeader(table.Row{"Path", "Methods", "Allowed", "Limited", "Bans", "Clients"})
+
+	for _, l := range r.Limits {
+		methods := strings.Join(l.Methods, ",")
+		if methods == "" {
+			methods = "ALL"
+		}
+
+		t.AppendRow(table.Row{l.Path, methods, l.Allowed, l.Limited, l.Bans, l.Clients})
+	}
+
+	fmt.Println(t.Render())
+
+	if len(r.Bans) == 0 {
+		fmt.Println("No clients are banned")
+		return nil
+	}
+
+	b := table.NewWriter()
+
+	b.SetTitle("Banned Clients")
+	b.AppendHeader(table.Row{"Client", "Path", "Until"})
+
+	for _, ban := range r.Bans {
+		b.AppendRow(table.Row{ban.Ip, ban.Path, ban.Until.AsTime().Local()})
+	}
+
+	fmt.Println(b.Render())
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/urfave/cli/v3"
 
@@ -34,10 +35,71 @@ func StatusCmd(ctx context.Context, _ *cli.Command) error {
 
 	fmt.Println(t.Render())
 
+	if r.Resources != nil {
+		fmt.Println(resourcesTable(r.Resources).Render())
+
+		for _, e := range r.Resources.Errors {
+			fmt.Printf("Could not read %s\n", e)
+		}
+	}
+
 	return nil
 
 }
 
+// resourcesTable shows the host's disk, database, log, memory and load usage, with the level each has reached
+func resourcesTable(res *pb.Resources) table.Writer {
+	t := table.NewWriter()
+
+	t.SetTitle("Host Resources")
+	t.AppendHeader(table.Row{"Resource", "Usage", "Level"})
+
+	for _, d := range res.Disks {
+		used := units.BytesSize(float64(d.Used))
+		size := units.BytesSize(float64(d.Used + d.Free))
+		percent := 0.0
+		if d.Used+d.Free > 0 {
+			percent = float64(d.Used) / float64(d.Used+d.Free) * 100
+		}
+
+		t.AppendRow(table.Row{
+			fmt.Sprintf("Disk (%s)\n%s", d.Name, d.Path),
+			fmt.Sprintf("%s of %s (%.0f%%)\n%s free", used, size, percent, units.BytesSize(float64(d.Free))),
+			strings.ToUpper(d.Level),
+		})
+	}
+
+	database := "unknown"
+	if res.DatabaseSize > 0 {
+		database = units.BytesSize(float64(res.DatabaseSize))
+	}
+	t.AppendRow(table.Row{"Database", database, ""})
+	t.AppendRow(table.Row{"Logs", units.BytesSize(float64(res.LogSize)), ""})
+
+	if res.MemoryTotal > 0 {
+		used := res.MemoryTotal - res.MemoryAvailable
+		t.AppendRow(table.Row{
+			"Memory",
+			fmt.Sprintf("%s of %s (%.0f%%)", units.BytesSize(float64(used)), units.BytesSize(float64(res.MemoryTotal)), float64(used)/float64(res.MemoryTotal)*100),
+			strings.ToUpper(res.MemoryLevel),
+		})
+	}
+
+	if len(res.Load) == 3 {
+		t.AppendRow(table.Row{
+			"Load",
+			fmt.Sprintf("%.2f %.2f %.2f (%d CPUs)", res.Load[0], res.Load[1], res.Load[2], res.Cpus),
+			strings.ToUpper(res.LoadLevel),
+		})
+	}
+
+	if res.CheckedAt != nil {
+		t.SetCaption("Checked at %s", res.CheckedAt.AsTime().Local().Format(time.DateTime))
+	}
+
+	return t
+}
+
 func RestartAllCmd(ctx context.Context, command *cli.Command) error {
 	return fmt.Errorf("not implemented")
 }
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/urfave/cli/v3"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+// clearScreen moves the cursor to the top left and clears the terminal, so each sample replaces the last
+const clearScreen = "\033[H\033[2J"
+
+func TopCmd(ctx context.Context, cmd *cli.Command) error {
+	stream, err := c.ContainerStats(ctx, &pb.ContainerStatsRequest{Interval: int32(cmd.Int("interval"))})
+	if err != nil {
+		return err
+	}
+
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(clearScreen)
+		fmt.Println(statsTable(r).Render())
+	}
+}
+
+func statsTable(r *pb.ContainerStatsResponse) table.Writer {
+	t := table.NewWriter()
+
+	t.SetTitle("NodeISP Containers")
+	t.AppendHeader(table.Row{"Service", "Container", "CPU %", "Memory", "Memory %", "Net I/O", "Block I/O", "PIDs"})
+	t.SetColumnConfigs([]table.ColumnConfig{
+		{Number: 3, Align: text.AlignRight},
+		{Number: 5, Align: text.AlignRight},
+		{Number: 8, Align: text.AlignRight},
+	})
+
+	for _, s := range r.Containers {
+		if s.State != "running" {
+			t.AppendRow(table.Row{s.Service, s.Container, s.State})
+			continue
+		}
+
+		memPercent := 0.0
+		if s.MemoryLimit > 0 {
+			memPercent = float64(s.MemoryUsage) / float64(s.MemoryLimit) * 100
+		}
+
+		t.AppendRow(table.Row{
+			s.Service,
+			s.Container,
+			fmt.Sprintf("%.2f%%", s.CpuPercent),
+			fmt.Sprintf("%s / %s", units.BytesSize(float64(s.MemoryUsage)), units.BytesSize(float64(s.MemoryLimit))),
+			fmt.Sprintf("%.2f%%", memPercent),
+			fmt.Sprintf("%s / %s", units.HumanSize(float64(s.NetworkRx)), units.HumanSize(float64(s.NetworkTx))),
+			fmt.Sprintf("%s / %s", units.HumanSize(float64(s.BlockRead)), units.HumanSize(float64(s.BlockWrite))),
+			s.Pids,
+		})
+	}
+
+	t.SetCaption("Updated %s, press Ctrl+C to exit", r.Read.AsTime().Local().Format(time.TimeOnly))
+
+	return t
+}
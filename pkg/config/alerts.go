@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultAlertRules are used when no rules are configured
+var DefaultAlertRules = []AlertRule{
+	{Name: "app_down", Check: "service_down", Services: []string{"app", "horizon"}, For: 2 * time.Minute, Severity: "critical"},
+	{Name: "certificate_expiry", Check: "certificate_expiry", Threshold: 14, Severity: "warning"},
+	{Name: "certificate_renewal", Check: "certificate_renewal", For: 6 * time.Hour, Severity: "warning"},
+	{Name: "licence", Check: "licence", Severity: "warning"},
+	{Name: "cron_failing", Check: "cron_failures", Threshold: 5, Severity: "warning"},
+	{Name: "host_resources", Check: "resources", For: 5 * time.Minute, Severity: "warning"},
+}
+
+// Configured reports whether alerts have anywhere to go
+func (a *Alerts) Configured() bool {
+	return a != nil && (len(a.Webhooks) > 0 || a.Email != nil && len(a.Email.To) > 0)
+}
+
+// RulesOrDefault returns the configured rules, or the default ones if there are none
+func (a *Alerts) RulesOrDefault() []AlertRule {
+	if len(a.Rules) > 0 {
+		return a.Rules
+	}
+
+	return DefaultAlertRules
+}
+
+// Validate checks the rules are usable
+func (a *Alerts) Validate() error {
+	names := map[string]bool{}
+
+	for _, r := range a.RulesOrDefault() {
+		if r.Name == "" {
+			return fmt.Errorf("alert rules need a name")
+		}
+
+		if names[r.Name] {
+			return fmt.Errorf("alert rule %q is defined twice", r.Name)
+		}
+		names[r.Name] = true
+
+		switch r.Check {
+		case "service_down", "licence", "certificate_renewal", "resources":
+		case "certificate_expiry", "cron_failures":
+			if r.Threshold <= 0 {
+				return fmt.Errorf("alert rule %q needs a threshold", r.Name)
+			}
+		default:
+			return fmt.Errorf("alert rule %q has an unknown check %q", r.Name, r.Check)
+		}
+
+		for _, name := range r.Services {
+			if !slices.Contains(ServiceNames, name) {
+				return fmt.Errorf("alert rule %q watches an unknown service %q, it can be one of %s", r.Name, name, strings.Join(ServiceNames, ", "))
+			}
+		}
+	}
+
+	for _, w := range a.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("alert webhooks need a url")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestAlertsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []AlertRule
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "services", rules: []AlertRule{{Name: "down", Check: "service_down", Services: []string{"app", "horizon", "redis", "postgres", "gotenberg"}}}},
+		{name: "no services", rules: []AlertRule{{Name: "down", Check: "service_down"}}},
+		{name: "unknown service", rules: []AlertRule{{Name: "down", Check: "service_down", Services: []string{"app", "worker"}}}, wantErr: true},
+		{name: "no name", rules: []AlertRule{{Check: "licence"}}, wantErr: true},
+		{name: "defined twice", rules: []AlertRule{{Name: "a", Check: "licence"}, {Name: "a", Check: "resources"}}, wantErr: true},
+		{name: "unknown check", rules: []AlertRule{{Name: "a", Check: "disk"}}, wantErr: true},
+		{name: "no threshold", rules: []AlertRule{{Name: "a", Check: "cron_failures"}}, wantErr: true},
+		{name: "threshold", rules: []AlertRule{{Name: "a", Check: "certificate_expiry", Threshold: 7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Alerts{Rules: tt.rules}).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultAlertServices(t *testing.T) {
+	// Every service the default rules watch has to exist, or its outage is never noticed
+	for _, r := range DefaultAlertRules {
+		for _, name := range r.Services {
+			if (&Services{App: &ServiceOverride{}, Horizon: &ServiceOverride{}, Redis: &ServiceOverride{}, Postgres: &ServiceOverride{}, Gotenberg: &ServiceOverride{}}).Override(name) == nil {
+				t.Errorf("rule %q watches %q, which isn't a service", r.Name, name)
+			}
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"strings"
 
+	"github.com/apex/log"
 	"github.com/creasty/defaults"
 	"gopkg.in/yaml.v3"
 )
@@ -10,20 +14,69 @@ import (
 var File string
 
 func New() (*Config, error) {
-	cfg := &Config{}
-
 	c, err := os.ReadFile(File)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := yaml.Unmarshal(c, cfg); err != nil {
+	cfg, unknown, err := decode(c)
+	if err != nil {
 		return nil, err
 	}
 
+	l := log.WithField("component", "config").WithField("path", File)
+
+	for _, field := range unknown {
+		l.WithField("field", field).Warn("unknown key in config, it will be ignored")
+	}
+
+	switch {
+	case cfg.Version < CurrentVersion:
+		l.WithField("version", cfg.Version).Warnf("config is out of date, run `nodeisp config migrate` to upgrade it to version %d", CurrentVersion)
+	case cfg.Version > CurrentVersion:
+		l.WithField("version", cfg.Version).Warnf("config is newer than this build supports (version %d), some settings may be ignored", CurrentVersion)
+	}
+
 	return cfg, nil
 }
 
+// decode strictly decodes the config, returning the unknown keys separately so they can be reported without
+// stopping the server from starting
+func decode(b []byte) (*Config, []string, error) {
+	cfg := &Config{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+
+	err := dec.Decode(cfg)
+	if err == nil {
+		return cfg, nil, nil
+	}
+
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return nil, nil, err
+	}
+
+	var unknown []string
+	for _, e := range typeErr.Errors {
+		if !strings.Contains(e, " not found in type ") {
+			return nil, nil, err
+		}
+
+		// The top level is decoded through an alias type, report it as the config itself
+		unknown = append(unknown, strings.Replace(e, "config.plain", "config.Config", 1))
+	}
+
+	// Only unknown keys, decode again without them being an error
+	cfg = &Config{}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, unknown, nil
+}
+
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	defaults.Set(c)
 
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantUnknown []string
+		wantErr     bool
+		check       func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			yaml: "version: 1\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Storage.Data != "/var/lib/node-isp/" {
+					t.Errorf("storage data = %q", cfg.Storage.Data)
+				}
+				if cfg.Shutdown.Timeout != 30*time.Second {
+					t.Errorf("shutdown timeout = %s", cfg.Shutdown.Timeout)
+				}
+				if cfg.Resources.DiskCritical != 95 {
+					t.Errorf("disk critical = %v", cfg.Resources.DiskCritical)
+				}
+			},
+		},
+		{
+			name: "values override defaults",
+			yaml: "version: 1\nstorage:\n  data: /srv/nodeisp\nshutdown:\n  timeout: 5s\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Storage.Data != "/srv/nodeisp" {
+					t.Errorf("storage data = %q", cfg.Storage.Data)
+				}
+				if cfg.Shutdown.Timeout != 5*time.Second {
+					t.Errorf("shutdown timeout = %s", cfg.Shutdown.Timeout)
+				}
+			},
+		},
+		{
+			name: "service overrides",
+			yaml: "version: 1\nservices:\n  gotenberg:\n    image: example/gotenberg:8\n    env:\n      A: \"1\"\n    mounts:\n      - source: /srv/fonts\n        target: /fonts\n        read_only: true\n",
+			check: func(t *testing.T, cfg *Config) {
+				o := cfg.Services.Override("gotenberg")
+				if o == nil || o.Image != "example/gotenberg:8" || o.Env["A"] != "1" || len(o.Mounts) != 1 || !o.Mounts[0].ReadOnly {
+					t.Errorf("gotenberg override = %+v", o)
+				}
+				if cfg.Services.Override("unknown") != nil {
+					t.Error("unknown service has an override")
+				}
+			},
+		},
+		{
+			name:        "unknown top level key",
+			yaml:        "version: 1\nnot_a_key: true\n",
+			wantUnknown: []string{"config.Config"},
+		},
+		{
+			name:        "unknown nested key",
+			yaml:        "version: 1\nstorage:\n  data: /srv\n  typo: true\n",
+			wantUnknown: []string{"config.Storage"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Storage.Data != "/srv" {
+					t.Errorf("known keys alongside an unknown one weren't decoded, storage data = %q", cfg.Storage.Data)
+				}
+			},
+		},
+		{
+			name:    "wrong type",
+			yaml:    "version: one\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			yaml:    "version: [1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, unknown, err := decode([]byte(tt.yaml))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(unknown) != len(tt.wantUnknown) {
+				t.Fatalf("unknown = %v, want %d matching %v", unknown, len(tt.wantUnknown), tt.wantUnknown)
+			}
+			for i, want := range tt.wantUnknown {
+				if !strings.Contains(unknown[i], want) {
+					t.Errorf("unknown[%d] = %q, want it to mention %q", i, unknown[i], want)
+				}
+			}
+
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestPrimaryDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		rules   []DomainRule
+		want    string
+	}{
+		{
+			name:    "first domain",
+			domains: []string{"example.com", "www.example.com"},
+			want:    "example.com",
+		},
+		{
+			name:    "wildcards are skipped",
+			domains: []string{"*.example.com", "example.com"},
+			want:    "example.com",
+		},
+		{
+			name:    "redirected domains are skipped",
+			domains: []string{"www.example.com", "example.com"},
+			rules:   []DomainRule{{Domain: "WWW.example.com", Redirect: "example.com"}},
+			want:    "example.com",
+		},
+		{
+			name:    "prefixed domains are kept",
+			domains: []string{"portal.example.com", "example.com"},
+			rules:   []DomainRule{{Domain: "portal.example.com", Prefix: "/portal"}},
+			want:    "portal.example.com",
+		},
+		{
+			name:    "every domain redirected",
+			domains: []string{"*.example.com", "www.example.com"},
+			rules:   []DomainRule{{Domain: "www.example.com", Redirect: "example.net"}},
+			want:    "www.example.com",
+		},
+		{
+			name:    "only wildcards",
+			domains: []string{"*.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTPServer{Domains: tt.domains, Proxy: &Proxy{Domains: tt.rules}}
+			if got := h.PrimaryDomain(); got != tt.want {
+				t.Errorf("PrimaryDomain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// External reports whether an external PostgreSQL server is configured
+func (d *Database) External() bool {
+	return d.Host != ""
+}
+
+// DSN returns a lib/pq connection string for the database on the given host and port
+func (d *Database) DSN(host string, port int) string {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s", host, port, d.User, d.Name, d.SSL())
+
+	if d.CACert != "" {
+		dsn = fmt.Sprintf("%s sslrootcert=%s", dsn, quoteDSN(d.CACert))
+	}
+
+	if d.Password != "" {
+		dsn = fmt.Sprintf("%s password=%s", dsn, quoteDSN(d.Password))
+	}
+
+	return dsn
+}
+
+// SSL returns the sslmode for the database connection. The managed container has no TLS, so it is only
+// enabled by default for external servers.
+func (d *Database) SSL() string {
+	if d.SSLMode != "" {
+		return d.SSLMode
+	}
+
+	if d.External() {
+		return "require"
+	}
+
+	return "disable"
+}
+
+// External reports whether an external Redis server is configured
+func (r *Redis) External() bool {
+	return r.Host != ""
+}
+
+// TLSConfig returns the TLS config used to connect to an external Redis server, or nil if TLS is disabled
+func (r *Redis) TLSConfig() (*tls.Config, error) {
+	if !r.TLS {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{ServerName: r.Host}
+
+	if r.CACert != "" {
+		pem, err := os.ReadFile(r.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", r.CACert)
+		}
+	}
+
+	return cfg, nil
+}
+
+// quoteDSN quotes a value for use in a key/value connection string
+func quoteDSN(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+
+	return "'" + v + "'"
+}
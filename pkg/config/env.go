@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environment returns the extra environment variables for the service as a sorted list of KEY=VALUE pairs.
+// Variables are read from EnvFile first, and then from Env, so the values in the config file win.
+func (o *ServiceOverride) Environment() ([]string, error) {
+	if o == nil {
+		return nil, nil
+	}
+
+	vars := map[string]string{}
+
+	if o.EnvFile != "" {
+		fileVars, err := ReadEnvFile(o.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+
+	for k, v := range o.Env {
+		vars[k] = v
+	}
+
+	// Sort the keys, the environment is part of the service hash so the order must be stable between restarts
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+vars[k])
+	}
+
+	return env, nil
+}
+
+// ReadEnvFile parses a .env style file of KEY=VALUE lines. Blank lines and comments are skipped, an optional
+// `export` prefix is allowed, and values may be wrapped in single or double quotes.
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := map[string]string{}
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		vars[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
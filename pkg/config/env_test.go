@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			file: "FOO=bar\nBAZ=qux\n",
+			want: map[string]string{"FOO": "bar", "BAZ": "qux"},
+		},
+		{
+			name: "comments and blank lines",
+			file: "# a comment\n\nFOO=bar\n   # indented comment\n",
+			want: map[string]string{"FOO": "bar"},
+		},
+		{
+			name: "export prefix",
+			file: "export FOO=bar\n",
+			want: map[string]string{"FOO": "bar"},
+		},
+		{
+			name: "quoted values",
+			file: "A=\"double quoted\"\nB='single quoted'\nC=\"mismatched'\n",
+			want: map[string]string{"A": "double quoted", "B": "single quoted", "C": "\"mismatched'"},
+		},
+		{
+			name: "whitespace around key and value",
+			file: "  FOO  =  bar  \n",
+			want: map[string]string{"FOO": "bar"},
+		},
+		{
+			name: "equals in value",
+			file: "URL=postgres://u:p@host/db?sslmode=require\n",
+			want: map[string]string{"URL": "postgres://u:p@host/db?sslmode=require"},
+		},
+		{
+			name: "empty value",
+			file: "EMPTY=\n",
+			want: map[string]string{"EMPTY": ""},
+		},
+		{
+			name: "later value wins",
+			file: "FOO=one\nFOO=two\n",
+			want: map[string]string{"FOO": "two"},
+		},
+		{
+			name:    "missing equals",
+			file:    "FOO=bar\nNOT_A_PAIR\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadEnvFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadEnvFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadEnvFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceOverrideEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("FROM_FILE=1\nSHARED=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &ServiceOverride{
+		EnvFile: path,
+		Env:     map[string]string{"SHARED": "config", "B": "2", "A": "1"},
+	}
+
+	got, err := o.Environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sorted by key, with the config's values winning over the file
+	want := []string{"A=1", "B=2", "FROM_FILE=1", "SHARED=config"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Environment() = %v, want %v", got, want)
+	}
+
+	var none *ServiceOverride
+	if env, err := none.Environment(); env != nil || err != nil {
+		t.Errorf("nil override Environment() = %v, %v", env, err)
+	}
+}
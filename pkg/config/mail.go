@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Configured reports whether an SMTP server has been set up
+func (m *Mail) Configured() bool {
+	return m != nil && m.Host != ""
+}
+
+// Validate checks the mail settings are usable
+func (m *Mail) Validate() error {
+	switch m.TLS {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("mail tls must be one of starttls, tls or none, got %q", m.TLS)
+	}
+
+	if m.FromAddress == "" {
+		return fmt.Errorf("mail from_address is required")
+	}
+
+	return nil
+}
+
+// Env translates the mail settings into the app's environment variables. The name of the app is used as
+// the sender name unless one is set.
+func (m *Mail) Env(appName string) []string {
+	if !m.Configured() {
+		return nil
+	}
+
+	// Older versions of laravel take an encryption, newer ones a scheme, so set both
+	encryption, scheme := "tls", "smtp"
+	switch m.TLS {
+	case "tls":
+		encryption, scheme = "ssl", "smtps"
+	case "none":
+		encryption = "null"
+	}
+
+	fromName := m.FromName
+	if fromName == "" {
+		fromName = appName
+	}
+
+	return []string{
+		"MAIL_MAILER=smtp",
+		"MAIL_HOST=" + m.Host,
+		"MAIL_PORT=" + strconv.Itoa(m.Port),
+		"MAIL_ENCRYPTION=" + encryption,
+		"MAIL_SCHEME=" + scheme,
+		"MAIL_USERNAME=" + m.Username,
+		"MAIL_PASSWORD=" + m.Password,
+		"MAIL_FROM_ADDRESS=" + m.FromAddress,
+		"MAIL_FROM_NAME=" + fromName,
+	}
+}
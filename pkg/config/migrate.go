@@ -0,0 +1,157 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// migration rewrites the root mapping of a config document to the next schema version
+type migration func(root *yaml.Node) error
+
+// migrations[i] upgrades a config from version i to version i+1
+var migrations = []migration{
+	// 0 -> 1: configs written before the schema was versioned, nothing has moved so only the version is added
+	func(root *yaml.Node) error { return nil },
+}
+
+// MigrateResult describes a config migration
+type MigrateResult struct {
+	// From and To are the schema versions before and after the migration
+	From int
+	To   int
+
+	// Backup is the path the original config was copied to, empty if nothing was changed
+	Backup string
+
+	// Unknown lists keys in the config that are not part of the current schema
+	Unknown []string
+}
+
+// Migrate upgrades the config at path to the current schema version. The file is edited as a YAML document so
+// comments and ordering are kept where possible, and the original is backed up next to it before being replaced.
+func Migrate(path string) (*MigrateResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s is not a yaml mapping", path)
+	}
+
+	root := doc.Content[0]
+
+	res := &MigrateResult{To: CurrentVersion}
+
+	if v := mappingValue(root, "version"); v != nil {
+		if res.From, err = strconv.Atoi(v.Value); err != nil {
+			return nil, fmt.Errorf("invalid config version %q", v.Value)
+		}
+	}
+
+	if res.From > CurrentVersion {
+		return nil, fmt.Errorf("config version %d is newer than this build supports (version %d)", res.From, CurrentVersion)
+	}
+
+	if res.From == CurrentVersion {
+		_, res.Unknown, err = decode(original)
+		return res, err
+	}
+
+	for v := res.From; v < CurrentVersion; v++ {
+		if err := migrations[v](root); err != nil {
+			return nil, fmt.Errorf("migrating config to version %d: %w", v+1, err)
+		}
+	}
+
+	setMappingValue(root, "version", strconv.Itoa(CurrentVersion))
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(detectIndent(original))
+
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	// Make sure the migrated config still decodes before touching the file on disk
+	if _, res.Unknown, err = decode(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("migrated config is invalid: %w", err)
+	}
+
+	res.Backup = fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102150405"))
+
+	if err := os.WriteFile(res.Backup, original, info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to back up config: %w", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil if the key does not exist
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// setMappingValue sets a scalar key in a mapping node, adding it to the top of the mapping if it does not exist
+func setMappingValue(m *yaml.Node, key, value string) {
+	if v := mappingValue(m, key); v != nil {
+		v.Kind = yaml.ScalarNode
+		v.Value = value
+		v.Tag = ""
+		return
+	}
+
+	k := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+
+	// Keep a comment at the top of the file at the top, rather than attached to what is now the second key
+	if len(m.Content) > 0 {
+		k.HeadComment, m.Content[0].HeadComment = m.Content[0].HeadComment, ""
+	}
+
+	m.Content = append([]*yaml.Node{k, {Kind: yaml.ScalarNode, Value: value}}, m.Content...)
+}
+
+// detectIndent returns the indentation used by a yaml file, falling back to the yaml.Marshal default used by setup
+func detectIndent(b []byte) int {
+	for _, line := range strings.Split(string(b), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == line || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "- ") {
+			continue
+		}
+
+		return len(line) - len(trimmed)
+	}
+
+	return 4
+}
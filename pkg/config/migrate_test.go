@@ -0,0 +1,147 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantFrom    int
+		wantBackup  bool
+		wantErr     bool
+		wantContent []string
+		wantUnknown int
+	}{
+		{
+			name:        "unversioned",
+			yaml:        "# NodeISP config\nstorage:\n    data: /srv/nodeisp\n",
+			wantFrom:    0,
+			wantBackup:  true,
+			wantContent: []string{"# NodeISP config\nversion: 1\n", "    data: /srv/nodeisp"},
+		},
+		{
+			name:        "two space indent is kept",
+			yaml:        "storage:\n  data: /srv/nodeisp\n",
+			wantBackup:  true,
+			wantContent: []string{"version: 1\n", "\n  data: /srv/nodeisp"},
+		},
+		{
+			name:     "current version is left alone",
+			yaml:     "version: 1\nstorage:\n  data: /srv\n",
+			wantFrom: 1,
+		},
+		{
+			name:        "unknown keys are reported",
+			yaml:        "storage:\n  data: /srv\nmystery: 1\n",
+			wantBackup:  true,
+			wantUnknown: 1,
+		},
+		{
+			name:    "newer than supported",
+			yaml:    "version: 99\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			yaml:    "version: latest\n",
+			wantErr: true,
+		},
+		{
+			name:    "not a mapping",
+			yaml:    "- a\n- b\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := Migrate(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Migrate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			b, _ := os.ReadFile(path)
+
+			if tt.wantErr {
+				if string(b) != tt.yaml {
+					t.Errorf("config was changed by a failed migration:\n%s", b)
+				}
+				return
+			}
+
+			if res.From != tt.wantFrom || res.To != CurrentVersion {
+				t.Errorf("migrated %d -> %d, want %d -> %d", res.From, res.To, tt.wantFrom, CurrentVersion)
+			}
+
+			if len(res.Unknown) != tt.wantUnknown {
+				t.Errorf("unknown = %v, want %d", res.Unknown, tt.wantUnknown)
+			}
+
+			if !tt.wantBackup {
+				if res.Backup != "" || string(b) != tt.yaml {
+					t.Errorf("config was rewritten when it was already current:\n%s", b)
+				}
+				return
+			}
+
+			backup, err := os.ReadFile(res.Backup)
+			if err != nil {
+				t.Fatalf("no backup: %v", err)
+			}
+			if string(backup) != tt.yaml {
+				t.Errorf("backup = %q, want the original", backup)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("mode = %s, want it kept at 0640", info.Mode().Perm())
+			}
+
+			for _, want := range tt.wantContent {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("migrated config doesn't contain %q:\n%s", want, b)
+				}
+			}
+
+			cfg, _, err := decode(b)
+			if err != nil {
+				t.Fatalf("migrated config doesn't decode: %v", err)
+			}
+			if cfg.Version != CurrentVersion {
+				t.Errorf("version = %d, want %d", cfg.Version, CurrentVersion)
+			}
+		})
+	}
+}
+
+func TestDetectIndent(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want int
+	}{
+		{"a:\n  b: 1\n", 2},
+		{"a:\n    b: 1\n", 4},
+		{"# comment\n   # indented comment\na:\n  b: 1\n", 2},
+		{"a:\n- x\n- y\nb:\n  c: 1\n", 2},
+		{"a: 1\n", 4},
+	}
+
+	for _, tt := range tests {
+		if got := detectIndent([]byte(tt.yaml)); got != tt.want {
+			t.Errorf("detectIndent(%q) = %d, want %d", tt.yaml, got, tt.want)
+		}
+	}
+}
@@ -1,24 +1,444 @@
 package config
 
+import (
+	"strings"
+	"time"
+)
+
+// CurrentVersion is the version of the config schema written by this build. When the schema changes in a way
+// that needs existing files rewriting, bump this and add a migration to migrations.
+const CurrentVersion = 1
+
 type Config struct {
-	HTTPServer *HTTPServer `yaml:"http"`
+	Version int `yaml:"version"`
+
+	HTTPServer *HTTPServer `yaml:"http" default:"{}"`
 	Licence    *Licence    `yaml:"licence"`
-	Storage    *Storage    `yaml:"storage"`
+	Storage    *Storage    `yaml:"storage" default:"{}"`
 
 	App      *App      `yaml:"app"`
-	Database *Database `yaml:"database"`
-	Redis    *Redis    `yaml:"redis"`
+	Database *Database `yaml:"database" default:"{}"`
+	Redis    *Redis    `yaml:"redis" default:"{}"`
+
+	Services *Services `yaml:"services" default:"{}"`
+	Mail     *Mail     `yaml:"mail,omitempty" default:"{}"`
+
+	Shutdown *Shutdown `yaml:"shutdown,omitempty" default:"{}"`
+	Metrics  *Metrics  `yaml:"metrics,omitempty" default:"{}"`
+	Tracing  *Tracing  `yaml:"tracing,omitempty" default:"{}"`
+	Alerts   *Alerts   `yaml:"alerts,omitempty" default:"{}"`
+
+	Resources *Resources `yaml:"resources,omitempty" default:"{}"`
+}
+
+// Resources sets how often the host's disk, memory and load are checked, and the percentages they warn at
+type Resources struct {
+	Interval time.Duration `yaml:"interval,omitempty" default:"1m"`
+
+	// DiskWarning and DiskCritical are the percentage of the storage filesystems used. Updates and other
+	// operations that need disk space are refused at the critical level.
+	DiskWarning  float64 `yaml:"disk_warning,omitempty" default:"80"`
+	DiskCritical float64 `yaml:"disk_critical,omitempty" default:"95"`
+
+	// MemoryWarning and MemoryCritical are the percentage of the host's memory used
+	MemoryWarning  float64 `yaml:"memory_warning,omitempty" default:"90"`
+	MemoryCritical float64 `yaml:"memory_critical,omitempty" default:"97"`
+
+	// LoadWarning and LoadCritical are the five minute load average, per CPU
+	LoadWarning  float64 `yaml:"load_warning,omitempty" default:"2"`
+	LoadCritical float64 `yaml:"load_critical,omitempty" default:"4"`
+}
+
+// Alerts notifies webhooks and email addresses when something needs attention
+type Alerts struct {
+	// Interval is how often the rules are checked
+	Interval time.Duration `yaml:"interval,omitempty" default:"1m"`
+
+	// RepeatInterval is how often an alert that is still firing is sent again
+	RepeatInterval time.Duration `yaml:"repeat_interval,omitempty" default:"12h"`
+
+	// Rules replace the default rules, see DefaultAlertRules
+	Rules []AlertRule `yaml:"rules,omitempty"`
+
+	// Webhooks are sent a JSON message with a text field, which Slack and Teams incoming webhooks accept
+	Webhooks []AlertWebhook `yaml:"webhooks,omitempty"`
+
+	// Email sends alerts through the SMTP server in the mail section
+	Email *AlertEmail `yaml:"email,omitempty"`
+}
+
+type AlertRule struct {
+	// Name identifies the rule in notifications, it must be unique
+	Name string `yaml:"name"`
+
+	// Check is one of service_down, certificate_expiry, certificate_renewal, licence or cron_failures
+	Check string `yaml:"check"`
+
+	// Services are the services service_down watches, from ServiceNames, defaults to the app
+	Services []string `yaml:"services,omitempty"`
+
+	// Threshold is the days left for certificate_expiry, and the failed runs in a row for cron_failures
+	Threshold float64 `yaml:"threshold,omitempty"`
+
+	// For is how long the problem has to last before the alert fires
+	For time.Duration `yaml:"for,omitempty"`
+
+	// Severity is included in notifications, warning or critical, defaults to critical
+	Severity string `yaml:"severity,omitempty"`
+}
+
+type AlertWebhook struct {
+	URL string `yaml:"url"`
+
+	// Headers are sent with each request, such as an authorization token
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type AlertEmail struct {
+	To []string `yaml:"to"`
+}
+
+// Tracing sends OpenTelemetry traces of the proxy and the management API to an OTLP collector
+type Tracing struct {
+	// Endpoint is the OTLP/HTTP URL of the collector, such as http://localhost:4318. Tracing is off without one.
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Headers are sent with each export, such as an API key for a hosted collector
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// SampleRatio is the fraction of new traces to record, traces already sampled upstream are always recorded
+	SampleRatio float64 `yaml:"sample_ratio,omitempty" default:"1"`
+
+	ServiceName string `yaml:"service_name,omitempty" default:"nodeisp"`
+}
+
+// Metrics exports Prometheus metrics on /metrics
+type Metrics struct {
+	// Listen is the address to serve the metrics on, or off. It has no authentication, so keep it on a local or
+	// private address.
+	Listen string `yaml:"listen,omitempty" default:"127.0.0.1:9464"`
+
+	// RouteGroups are the path prefixes proxy requests are counted under, other requests are counted as app
+	RouteGroups []string `yaml:"route_groups,omitempty" default:"[\"/api\",\"/admin\",\"/livewire\",\"/storage\"]"`
+}
 
-	Services *Services `yaml:"services"`
+// Shutdown controls how the daemon stops on SIGTERM or SIGINT
+type Shutdown struct {
+	// Timeout is how long to wait for requests and running commands to finish, before they are cut off
+	Timeout time.Duration `yaml:"timeout,omitempty" default:"30s"`
+
+	// StopContainers stops the managed containers too. By default they keep running, so a restart of the
+	// daemon, such as for an upgrade, doesn't take the app down.
+	StopContainers bool `yaml:"stop_containers,omitempty"`
 }
 
 type HTTPServer struct {
 	Domains []string `yaml:"domains"`
-	TLS     *TLS     `yaml:"tls"`
+	TLS     *TLS     `yaml:"tls" default:"{}"`
+
+	Listen *Listen `yaml:"listen,omitempty" default:"{}"`
+
+	// PlainHTTP serves the app on the HTTP listeners without TLS or ACME, for running behind a load balancer
+	// that terminates TLS
+	PlainHTTP bool `yaml:"plain_http,omitempty"`
+
+	// TrustedProxies are the IPs or CIDRs of proxies in front of NodeISP. The client address and scheme are
+	// taken from X-Forwarded-For and X-Forwarded-Proto, or the PROXY protocol, only for these addresses.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+
+	// ProxyProtocol accepts PROXY protocol v1 and v2 headers from the trusted proxies on all listeners
+	ProxyProtocol bool `yaml:"proxy_protocol,omitempty"`
+
+	// HTTP3 also serves HTTPS over QUIC, on UDP on the HTTPS listen addresses, and advertises it with Alt-Svc
+	HTTP3 bool `yaml:"http3,omitempty"`
+
+	// HTTP3Port is the UDP port advertised in Alt-Svc, for when a firewall or load balancer forwards a different
+	// public port to the listener. Defaults to the port of each HTTPS listen address.
+	HTTP3Port int `yaml:"http3_port,omitempty"`
+
+	// Proxy configures how requests are forwarded to the app
+	Proxy *Proxy `yaml:"proxy,omitempty" default:"{}"`
+
+	AccessLog *AccessLog `yaml:"access_log,omitempty" default:"{}"`
+
+	Compression *Compression `yaml:"compression,omitempty" default:"{}"`
+
+	Health *Health `yaml:"health,omitempty" default:"{}"`
+}
+
+// Health serves /healthz and /readyz on the HTTPS listeners, for uptime checkers and load balancers
+type Health struct {
+	// Listen also serves them on a separate address, such as a local port for a load balancer's checks
+	Listen string `yaml:"listen,omitempty"`
+}
+
+// Compression compresses responses for clients that support it
+type Compression struct {
+	// Algorithms are used in order of preference, zstd and gzip are supported. An empty list turns compression off.
+	Algorithms []string `yaml:"algorithms" default:"[\"zstd\",\"gzip\"]"`
+
+	// MinSize is the smallest response to compress, in bytes, when its size is known
+	MinSize int `yaml:"min_size,omitempty" default:"1024"`
+}
+
+// AccessLog writes an entry for every request to the app, to a file in the logs directory
+type AccessLog struct {
+	// Format is combined, json or off
+	Format string `yaml:"format,omitempty" default:"combined"`
+
+	// File is the name of the log file, relative to the logs directory
+	File string `yaml:"file,omitempty" default:"access.log"`
+
+	// Fields are the fields written in the json format, all of them if empty. They are time, client, host,
+	// method, path, proto, status, bytes, duration, upstream, request_id, user, referer and user_agent.
+	Fields []string `yaml:"fields,omitempty"`
+
+	// SampleRate is the fraction of successful requests to log, between 0 and 1. Errors are always logged.
+	SampleRate float64 `yaml:"sample_rate,omitempty" default:"1"`
+}
+
+// Listen are the addresses to listen on, such as ":443", "[::1]:443" or "10.0.0.1:443"
+type Listen struct {
+	HTTP  []string `yaml:"http,omitempty" default:"[\":80\"]"`
+	HTTPS []string `yaml:"https,omitempty" default:"[\":443\"]"`
+}
+
+type Proxy struct {
+	// MaxBodySize is the largest request body accepted, such as "64MB"
+	MaxBodySize string `yaml:"max_body_size,omitempty" default:"64MB"`
+
+	// Timeout is how long the app has to respond to a request
+	Timeout time.Duration `yaml:"timeout,omitempty" default:"60s"`
+
+	// Routes override the limits for paths starting with a prefix, the longest matching prefix is used
+	Routes []ProxyRoute `yaml:"routes,omitempty"`
+
+	Headers *SecurityHeaders `yaml:"headers,omitempty" default:"{}"`
+
+	// Access restricts paths starting with a prefix, such as the admin area. The longest matching prefix is used,
+	// and every check configured on it has to pass.
+	Access []AccessPolicy `yaml:"access,omitempty"`
+
+	// RateLimits limit how often each client can request the paths matching a pattern
+	RateLimits []RateLimit `yaml:"rate_limits,omitempty"`
+
+	Maintenance *Maintenance `yaml:"maintenance,omitempty" default:"{}"`
+
+	// Static are directories served straight from disk instead of by the app. The app's /storage directory is
+	// always served this way.
+	Static []StaticPath `yaml:"static,omitempty"`
+
+	// Domains decide what happens to requests by their Host header. Domains without a rule are served by the app
+	// as they are.
+	Domains []DomainRule `yaml:"domains,omitempty"`
+}
+
+type StaticPath struct {
+	// Path is the URL prefix, such as /build
+	Path string `yaml:"path"`
+
+	// Dir is the directory on the host to serve files from
+	Dir string `yaml:"dir"`
+
+	// MaxAge is sent in Cache-Control, an hour by default. Clients revalidate with the ETag once it expires.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+}
+
+type DomainRule struct {
+	// Domain is the host name to match, such as portal.example.com or *.example.com
+	Domain string `yaml:"domain"`
+
+	// Redirect sends clients to another domain, keeping the path and query. It is a host name, or a URL to also
+	// set the scheme.
+	Redirect string `yaml:"redirect,omitempty"`
+
+	// RedirectStatus is the status code of the redirect, 301 by default
+	RedirectStatus int `yaml:"redirect_status,omitempty"`
+
+	// Prefix is added to the path of requests for the domain, so a separate host name can serve one section of
+	// the app, such as /portal. Paths already starting with the prefix are left alone.
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+// Maintenance configures the page shown while the app is in maintenance mode, or can't be reached
+type Maintenance struct {
+	// Allow are the IPs and CIDRs that can still reach the app in maintenance mode, such as the office
+	Allow []string `yaml:"allow,omitempty"`
+
+	// Message is shown when maintenance mode is turned on without one
+	Message string `yaml:"message,omitempty" default:"We're carrying out maintenance, and will be back shortly."`
+
+	// Page is the path to an HTML template to use instead of the built in page, it is given .Title and .Message
+	Page string `yaml:"page,omitempty"`
+
+	// RetryAfter is sent to clients in maintenance mode
+	RetryAfter time.Duration `yaml:"retry_after,omitempty" default:"5m"`
+}
+
+type RateLimit struct {
+	// Path is a prefix, or a pattern with * wildcards for single segments such as /portal/*/login
+	Path string `yaml:"path"`
+
+	// Methods are the HTTP methods limited, all methods if empty
+	Methods []string `yaml:"methods,omitempty"`
+
+	// Rate is the sustained requests per second allowed for each client, and Burst how many can be made at once
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+
+	// BanAfter bans a client from the whole site for BanFor, once it has been rate limited this many times
+	// within BanFor. Zero never bans.
+	BanAfter int           `yaml:"ban_after,omitempty"`
+	BanFor   time.Duration `yaml:"ban_for,omitempty" default:"15m"`
+}
+
+type AccessPolicy struct {
+	Path string `yaml:"path"`
+
+	// Allow are the IPs and CIDRs allowed to access the path
+	Allow []string `yaml:"allow,omitempty"`
+
+	// Users require HTTP basic auth, mapping usernames to bcrypt password hashes
+	Users map[string]string `yaml:"users,omitempty"`
+	Realm string            `yaml:"realm,omitempty"`
+
+	// ClientCA is the path to a PEM bundle of CAs, and requires a client certificate signed by one of them. This
+	// only works when NodeISP terminates TLS itself.
+	ClientCA string `yaml:"client_ca,omitempty"`
+}
+
+type ProxyRoute struct {
+	Path        string        `yaml:"path"`
+	MaxBodySize string        `yaml:"max_body_size,omitempty"`
+	Timeout     time.Duration `yaml:"timeout,omitempty"`
+}
+
+// SecurityHeaders are added to responses that don't already have them. Set a header to "off" to leave it out.
+type SecurityHeaders struct {
+	// HSTS is the Strict-Transport-Security header, only sent on HTTPS responses
+	HSTS string `yaml:"hsts,omitempty" default:"max-age=31536000"`
+
+	FrameOptions          string `yaml:"frame_options,omitempty" default:"SAMEORIGIN"`
+	ContentTypeOptions    string `yaml:"content_type_options,omitempty" default:"nosniff"`
+	ReferrerPolicy        string `yaml:"referrer_policy,omitempty" default:"strict-origin-when-cross-origin"`
+	ContentSecurityPolicy string `yaml:"content_security_policy,omitempty"`
+
+	// Custom headers to add, such as Permissions-Policy
+	Custom map[string]string `yaml:"custom,omitempty"`
+}
+
+// ClientCerts reports whether any access policy requires a client certificate, so TLS connections should ask for one
+func (p *Proxy) ClientCerts() bool {
+	for _, a := range p.Access {
+		if a.ClientCA != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrimaryDomain returns the domain the app is served from, the first domain that isn't a wildcard or redirected
+// elsewhere
+func (h *HTTPServer) PrimaryDomain() string {
+	redirected := map[string]bool{}
+	if h.Proxy != nil {
+		for _, r := range h.Proxy.Domains {
+			if r.Redirect != "" {
+				redirected[strings.ToLower(r.Domain)] = true
+			}
+		}
+	}
+
+	for _, d := range h.Domains {
+		if !strings.HasPrefix(d, "*.") && !redirected[strings.ToLower(d)] {
+			return d
+		}
+	}
+
+	// Every domain is redirected, which is a mistake, but the app still needs a URL
+	for _, d := range h.Domains {
+		if !strings.HasPrefix(d, "*.") {
+			return d
+		}
+	}
+
+	return ""
 }
 
 type TLS struct {
 	Email string `yaml:"email"`
+
+	// CA is the ACME directory URL to request certificates from, defaults to Let's Encrypt
+	CA string `yaml:"ca,omitempty"`
+
+	// Staging uses the Let's Encrypt staging directory, so test installs don't use up the production rate limits.
+	// The certificates it issues are not trusted by browsers. It is ignored if CA is set.
+	Staging bool `yaml:"staging,omitempty"`
+
+	// EAB is the External Account Binding some CAs, such as ZeroSSL, require
+	EAB *EAB `yaml:"eab,omitempty"`
+
+	// RootCA is the path to a PEM bundle of CA certificates to trust when talking to the ACME server,
+	// for an internal CA or a local Pebble instance
+	RootCA string `yaml:"root_ca,omitempty"`
+
+	// DNS solves the ACME challenge with DNS-01 instead of HTTP-01 and TLS-ALPN. It is required for wildcard
+	// domains, and for hosts that can't be reached on ports 80 and 443 from the internet.
+	DNS *DNSChallenge `yaml:"dns,omitempty"`
+
+	// Certificates are served instead of obtaining certificates with ACME, for certificates issued by a
+	// corporate CA. They are picked by SNI, and reloaded when the files change.
+	Certificates []Certificate `yaml:"certificates,omitempty"`
+
+	// ExpiryWarning is how long before a certificate from Certificates expires to start warning about it
+	ExpiryWarning time.Duration `yaml:"expiry_warning,omitempty" default:"720h"`
+}
+
+type Certificate struct {
+	// Cert and Key are the paths to the PEM encoded certificate chain and private key
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+
+	// Domains are the server names to use the certificate for, defaults to the names in the certificate
+	Domains []string `yaml:"domains,omitempty"`
+}
+
+type DNSChallenge struct {
+	// Provider is the DNS provider used to create the challenge records, only rfc2136 is supported so far
+	Provider string `yaml:"provider"`
+
+	RFC2136 *RFC2136 `yaml:"rfc2136,omitempty"`
+
+	// TTL of the challenge records
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	// PropagationDelay is how long to wait before checking the records have propagated,
+	// and PropagationTimeout how long to keep checking for
+	PropagationDelay   time.Duration `yaml:"propagation_delay,omitempty"`
+	PropagationTimeout time.Duration `yaml:"propagation_timeout,omitempty"`
+
+	// Resolvers are the DNS servers used to find the zone and check propagation, as host:port
+	Resolvers []string `yaml:"resolvers,omitempty"`
+
+	// OverrideDomain delegates the challenge to another domain, with a CNAME from _acme-challenge
+	OverrideDomain string `yaml:"override_domain,omitempty"`
+}
+
+// RFC2136 updates records with dynamic DNS updates, signed with a TSIG key, such as BIND or Knot
+type RFC2136 struct {
+	// Server is the primary name server for the zone, as host:port
+	Server string `yaml:"server"`
+
+	// KeyName, KeyAlg and Key are the TSIG key, the key is base64 encoded. KeyAlg defaults to hmac-sha256
+	KeyName string `yaml:"key_name"`
+	KeyAlg  string `yaml:"key_alg,omitempty"`
+	Key     string `yaml:"key"`
+}
+
+type EAB struct {
+	KeyID  string `yaml:"key_id"`
+	MACKey string `yaml:"mac_key"`
 }
 
 type Licence struct {
@@ -39,12 +459,101 @@ type App struct {
 type Database struct {
 	Name     string `yaml:"name"`
 	Password string `yaml:"password"`
+	User     string `yaml:"user,omitempty" default:"postgres"`
+
+	// Host of an external PostgreSQL server, when set the managed postgres container is not started
+	Host string `yaml:"host,omitempty"`
+	Port int    `yaml:"port,omitempty" default:"5432"`
+
+	// SSLMode is the libpq sslmode, defaults to require for external servers
+	SSLMode string `yaml:"sslmode,omitempty"`
+
+	// CACert is the path to a CA bundle used to verify the external server
+	CACert string `yaml:"ca_cert,omitempty"`
 }
 
 type Redis struct {
 	Password string `yaml:"password"`
+	User     string `yaml:"user,omitempty"`
+
+	// Host of an external Redis server, when set the managed redis container is not started
+	Host string `yaml:"host,omitempty"`
+	Port int    `yaml:"port,omitempty" default:"6379"`
+
+	// TLS enables TLS to the external server, verified against CACert if set
+	TLS    bool   `yaml:"tls,omitempty"`
+	CACert string `yaml:"ca_cert,omitempty"`
+}
+
+// Mail is the SMTP server the app sends invoices, notifications and password resets through
+type Mail struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port" default:"587"`
+
+	// TLS is one of starttls, tls (implicit TLS, usually port 465) or none
+	TLS string `yaml:"tls" default:"starttls"`
+
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	FromAddress string `yaml:"from_address"`
+	FromName    string `yaml:"from_name,omitempty"`
 }
 
 type Services struct {
 	GoogleMapsApiKey string `yaml:"google_maps_api_key"`
+
+	// Per-service overrides, merged into the managed containers on start
+	App       *ServiceOverride `yaml:"app,omitempty"`
+	Horizon   *ServiceOverride `yaml:"horizon,omitempty"`
+	Redis     *ServiceOverride `yaml:"redis,omitempty"`
+	Postgres  *ServiceOverride `yaml:"postgres,omitempty"`
+	Gotenberg *ServiceOverride `yaml:"gotenberg,omitempty"`
+}
+
+// ServiceNames are the services NodeISP manages, as named in overrides and alert rules
+var ServiceNames = []string{"app", "horizon", "redis", "postgres", "gotenberg"}
+
+// Override returns the overrides for the named service, or nil if there are none
+func (s *Services) Override(name string) *ServiceOverride {
+	if s == nil {
+		return nil
+	}
+
+	switch name {
+	case "app":
+		return s.App
+	case "horizon":
+		return s.Horizon
+	case "redis":
+		return s.Redis
+	case "postgres":
+		return s.Postgres
+	case "gotenberg":
+		return s.Gotenberg
+	}
+
+	return nil
+}
+
+// ServiceOverride customises the container spec of a managed service. Horizon inherits
+// the app overrides, and applies its own on top.
+type ServiceOverride struct {
+	// Image replaces the default (or stored) image of the service
+	Image string `yaml:"image,omitempty"`
+
+	// Env is a list of extra environment variables, these take precedence over EnvFile
+	Env map[string]string `yaml:"env,omitempty"`
+
+	// EnvFile is the path to a .env style file of extra environment variables
+	EnvFile string `yaml:"env_file,omitempty"`
+
+	// Mounts is a list of extra host paths to bind into the container
+	Mounts []Mount `yaml:"mounts,omitempty"`
+}
+
+type Mount struct {
+	Source   string `yaml:"source"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
 }
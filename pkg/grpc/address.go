@@ -0,0 +1,5 @@
+package grpc
+
+// Address is where the daemon serves the management API. The API has no authentication, so it only listens on
+// loopback, for the nodeisp command on the same host.
+const Address = "127.0.0.1:50051"
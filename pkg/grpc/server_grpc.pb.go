@@ -24,6 +24,14 @@ const _ = grpc.SupportPackageIsVersion7
 type NodeISPServiceClient interface {
 	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
 	GetVersion(ctx context.Context, in *GetVersionRequest, opts ...grpc.CallOption) (*GetVersionResponse, error)
+	SendTestMail(ctx context.Context, in *SendTestMailRequest, opts ...grpc.CallOption) (*SendTestMailResponse, error)
+	GetRateLimits(ctx context.Context, in *GetRateLimitsRequest, opts ...grpc.CallOption) (*GetRateLimitsResponse, error)
+	SetMaintenance(ctx context.Context, in *SetMaintenanceRequest, opts ...grpc.CallOption) (*SetMaintenanceResponse, error)
+	TestAlerts(ctx context.Context, in *TestAlertsRequest, opts ...grpc.CallOption) (*TestAlertsResponse, error)
+	ListCertificates(ctx context.Context, in *ListCertificatesRequest, opts ...grpc.CallOption) (*ListCertificatesResponse, error)
+	RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*RenewCertificateResponse, error)
+	CertificateEvents(ctx context.Context, in *CertificateEventsRequest, opts ...grpc.CallOption) (NodeISPService_CertificateEventsClient, error)
+	ContainerStats(ctx context.Context, in *ContainerStatsRequest, opts ...grpc.CallOption) (NodeISPService_ContainerStatsClient, error)
 }
 
 type nodeISPServiceClient struct {
@@ -52,12 +60,138 @@ func (c *nodeISPServiceClient) GetVersion(ctx context.Context, in *GetVersionReq
 	return out, nil
 }
 
+func (c *nodeISPServiceClient) SendTestMail(ctx context.Context, in *SendTestMailRequest, opts ...grpc.CallOption) (*SendTestMailResponse, error) {
+	out := new(SendTestMailResponse)
+	err := c.cc.Invoke(ctx, "/grpc.NodeISPService/SendTestMail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeISPServiceClient) GetRateLimits(ctx context.Context, in *GetRateLimitsRequest, opts ...grpc.CallOption) (*GetRateLimitsResponse, error) {
+	out := new(GetRateLimitsResponse)
+	err := c.cc.Invoke(ctx, "/grpc.NodeISPService/GetRateLimits", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeISPServiceClient) SetMaintenance(ctx context.Context, in *SetMaintenanceRequest, opts ...grpc.CallOption) (*SetMaintenanceResponse, error) {
+	out := new(SetMaintenanceResponse)
+	err := c.cc.Invoke(ctx, "/grpc.NodeISPService/SetMaintenance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeISPServiceClient) TestAlerts(ctx context.Context, in *TestAlertsRequest, opts ...grpc.CallOption) (*TestAlertsResponse, error) {
+	out := new(TestAlertsResponse)
+	err := c.cc.Invoke(ctx, "/grpc.NodeISPService/TestAlerts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeISPServiceClient) ListCertificates(ctx context.Context, in *ListCertificatesRequest, opts ...grpc.CallOption) (*ListCertificatesResponse, error) {
+	out := new(ListCertificatesResponse)
+	err := c.cc.Invoke(ctx, "/grpc.NodeISPService/ListCertificates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeISPServiceClient) RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*RenewCertificateResponse, error) {
+	out := new(RenewCertificateResponse)
+	err := c.cc.Invoke(ctx, "/grpc.NodeISPService/RenewCertificate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeISPServiceClient) CertificateEvents(ctx context.Context, in *CertificateEventsRequest, opts ...grpc.CallOption) (NodeISPService_CertificateEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &NodeISPService_ServiceDesc.Streams[0], "/grpc.NodeISPService/CertificateEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &nodeISPServiceCertificateEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type NodeISPService_CertificateEventsClient interface {
+	Recv() (*CertificateEvent, error)
+	grpc.ClientStream
+}
+
+type nodeISPServiceCertificateEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *nodeISPServiceCertificateEventsClient) Recv() (*CertificateEvent, error) {
+	m := new(CertificateEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *nodeISPServiceClient) ContainerStats(ctx context.Context, in *ContainerStatsRequest, opts ...grpc.CallOption) (NodeISPService_ContainerStatsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &NodeISPService_ServiceDesc.Streams[1], "/grpc.NodeISPService/ContainerStats", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &nodeISPServiceContainerStatsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type NodeISPService_ContainerStatsClient interface {
+	Recv() (*ContainerStatsResponse, error)
+	grpc.ClientStream
+}
+
+type nodeISPServiceContainerStatsClient struct {
+	grpc.ClientStream
+}
+
+func (x *nodeISPServiceContainerStatsClient) Recv() (*ContainerStatsResponse, error) {
+	m := new(ContainerStatsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // NodeISPServiceServer is the server API for NodeISPService service.
 // All implementations must embed UnimplementedNodeISPServiceServer
 // for forward compatibility
 type NodeISPServiceServer interface {
 	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
 	GetVersion(context.Context, *GetVersionRequest) (*GetVersionResponse, error)
+	SendTestMail(context.Context, *SendTestMailRequest) (*SendTestMailResponse, error)
+	GetRateLimits(context.Context, *GetRateLimitsRequest) (*GetRateLimitsResponse, error)
+	SetMaintenance(context.Context, *SetMaintenanceRequest) (*SetMaintenanceResponse, error)
+	TestAlerts(context.Context, *TestAlertsRequest) (*TestAlertsResponse, error)
+	ListCertificates(context.Context, *ListCertificatesRequest) (*ListCertificatesResponse, error)
+	RenewCertificate(context.Context, *RenewCertificateRequest) (*RenewCertificateResponse, error)
+	CertificateEvents(*CertificateEventsRequest, NodeISPService_CertificateEventsServer) error
+	ContainerStats(*ContainerStatsRequest, NodeISPService_ContainerStatsServer) error
 	mustEmbedUnimplementedNodeISPServiceServer()
 }
 
@@ -71,6 +205,30 @@ func (UnimplementedNodeISPServiceServer) GetStatus(context.Context, *GetStatusRe
 func (UnimplementedNodeISPServiceServer) GetVersion(context.Context, *GetVersionRequest) (*GetVersionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetVersion not implemented")
 }
+func (UnimplementedNodeISPServiceServer) SendTestMail(context.Context, *SendTestMailRequest) (*SendTestMailResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendTestMail not implemented")
+}
+func (UnimplementedNodeISPServiceServer) GetRateLimits(context.Context, *GetRateLimitsRequest) (*GetRateLimitsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRateLimits not implemented")
+}
+func (UnimplementedNodeISPServiceServer) SetMaintenance(context.Context, *SetMaintenanceRequest) (*SetMaintenanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMaintenance not implemented")
+}
+func (UnimplementedNodeISPServiceServer) TestAlerts(context.Context, *TestAlertsRequest) (*TestAlertsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TestAlerts not implemented")
+}
+func (UnimplementedNodeISPServiceServer) ListCertificates(context.Context, *ListCertificatesRequest) (*ListCertificatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCertificates not implemented")
+}
+func (UnimplementedNodeISPServiceServer) RenewCertificate(context.Context, *RenewCertificateRequest) (*RenewCertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewCertificate not implemented")
+}
+func (UnimplementedNodeISPServiceServer) CertificateEvents(*CertificateEventsRequest, NodeISPService_CertificateEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method CertificateEvents not implemented")
+}
+func (UnimplementedNodeISPServiceServer) ContainerStats(*ContainerStatsRequest, NodeISPService_ContainerStatsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ContainerStats not implemented")
+}
 func (UnimplementedNodeISPServiceServer) mustEmbedUnimplementedNodeISPServiceServer() {}
 
 // UnsafeNodeISPServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +278,156 @@ func _NodeISPService_GetVersion_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _NodeISPService_SendTestMail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendTestMailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeISPServiceServer).SendTestMail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.NodeISPService/SendTestMail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeISPServiceServer).SendTestMail(ctx, req.(*SendTestMailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeISPService_GetRateLimits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRateLimitsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeISPServiceServer).GetRateLimits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.NodeISPService/GetRateLimits",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeISPServiceServer).GetRateLimits(ctx, req.(*GetRateLimitsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeISPService_SetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeISPServiceServer).SetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.NodeISPService/SetMaintenance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeISPServiceServer).SetMaintenance(ctx, req.(*SetMaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeISPService_TestAlerts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TestAlertsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeISPServiceServer).TestAlerts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.NodeISPService/TestAlerts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeISPServiceServer).TestAlerts(ctx, req.(*TestAlertsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeISPService_ListCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCertificatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeISPServiceServer).ListCertificates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.NodeISPService/ListCertificates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeISPServiceServer).ListCertificates(ctx, req.(*ListCertificatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeISPService_RenewCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeISPServiceServer).RenewCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.NodeISPService/RenewCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeISPServiceServer).RenewCertificate(ctx, req.(*RenewCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NodeISPService_CertificateEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CertificateEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NodeISPServiceServer).CertificateEvents(m, &nodeISPServiceCertificateEventsServer{stream})
+}
+
+type NodeISPService_CertificateEventsServer interface {
+	Send(*CertificateEvent) error
+	grpc.ServerStream
+}
+
+type nodeISPServiceCertificateEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *nodeISPServiceCertificateEventsServer) Send(m *CertificateEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _NodeISPService_ContainerStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ContainerStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NodeISPServiceServer).ContainerStats(m, &nodeISPServiceContainerStatsServer{stream})
+}
+
+type NodeISPService_ContainerStatsServer interface {
+	Send(*ContainerStatsResponse) error
+	grpc.ServerStream
+}
+
+type nodeISPServiceContainerStatsServer struct {
+	grpc.ServerStream
+}
+
+func (x *nodeISPServiceContainerStatsServer) Send(m *ContainerStatsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // NodeISPService_ServiceDesc is the grpc.ServiceDesc for NodeISPService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,7 +443,42 @@ var NodeISPService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetVersion",
 			Handler:    _NodeISPService_GetVersion_Handler,
 		},
+		{
+			MethodName: "SendTestMail",
+			Handler:    _NodeISPService_SendTestMail_Handler,
+		},
+		{
+			MethodName: "GetRateLimits",
+			Handler:    _NodeISPService_GetRateLimits_Handler,
+		},
+		{
+			MethodName: "SetMaintenance",
+			Handler:    _NodeISPService_SetMaintenance_Handler,
+		},
+		{
+			MethodName: "TestAlerts",
+			Handler:    _NodeISPService_TestAlerts_Handler,
+		},
+		{
+			MethodName: "ListCertificates",
+			Handler:    _NodeISPService_ListCertificates_Handler,
+		},
+		{
+			MethodName: "RenewCertificate",
+			Handler:    _NodeISPService_RenewCertificate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CertificateEvents",
+			Handler:       _NodeISPService_CertificateEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ContainerStats",
+			Handler:       _NodeISPService_ContainerStats_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/grpc/server.proto",
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/apex/log"
@@ -18,6 +19,11 @@ type Licence struct {
 	log      *log.Entry
 	fileName string
 
+	// mu guards the fields below, which are replaced by each refresh
+	mu      sync.RWMutex
+	checked time.Time
+	err     error
+
 	ID       string `json:"id"`
 	Code     string `json:"-"`
 	Domain   string `json:"domain"`
@@ -43,7 +49,7 @@ func New(id, code string) (*Licence, error) {
 		Code: code,
 	}
 
-	if err := l.validate(); err != nil {
+	if err := l.refresh(); err != nil {
 		return nil, err
 	}
 
@@ -56,18 +62,74 @@ func New(id, code string) (*Licence, error) {
 			log.WithField("component", "licence").Info("refreshing licence")
 
 			// refresh the licence
-			if err := l.validate(); err != nil {
+			if err := l.refresh(); err != nil {
 				l.log.WithError(err).Error("failed to refresh licence")
+				continue
 			}
 
 			l.log.Info("licence refreshed")
-
 		}
 	}()
 
 	return l, nil
 }
 
+// Status is the result of the last licence check
+type Status struct {
+	Valid bool
+
+	// ExpiresAt is zero if the licence doesn't expire
+	ExpiresAt time.Time
+
+	// CheckedAt is when the licence server last confirmed the licence, and Err why the last refresh failed
+	CheckedAt time.Time
+	Err       error
+}
+
+// Status returns the licence as of the last refresh
+func (l *Licence) Status() Status {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return Status{
+		Valid:     l.Valid,
+		ExpiresAt: parseExpiry(l.ExpiresAt),
+		CheckedAt: l.checked,
+		Err:       l.err,
+	}
+}
+
+// refresh validates the licence, and records the result for Status
+func (l *Licence) refresh() error {
+	err := l.validate()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.err = err
+	if err == nil {
+		l.checked = time.Now()
+	}
+
+	return err
+}
+
+// parseExpiry reads the expiry date from the licence server, which is null for a licence that doesn't expire
+func parseExpiry(v interface{}) time.Time {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
 func (l *Licence) validate() error {
 	if l.ID == "" {
 		return fmt.Errorf("licence ID is required")
@@ -93,7 +155,10 @@ func (l *Licence) validate() error {
 		return fmt.Errorf("failed to validate licence server %d", resp.StatusCode)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := json.NewDecoder(resp.Body).Decode(l); err != nil {
 		return err
 	}
 
@@ -102,7 +167,9 @@ func (l *Licence) validate() error {
 
 func (l *Licence) Store(fileName string) error {
 	// Store the licence data in a file
+	l.mu.RLock()
 	decoded, err := base64.StdEncoding.DecodeString(l.LicenceData)
+	l.mu.RUnlock()
 	if err != nil {
 		return err
 	}
@@ -21,19 +21,8 @@ type stats struct {
 	ServicesByStatus map[string]int `json:"services_by_status"`
 }
 
-func (l *Licence) StartStatsReporter(dbHost,
-	dbPort,
-	dbUser,
-	dbPassword,
-	dbDatabase string) error {
-
-	// Connect to the database
-	authString := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbDatabase)
-
-	if dbPassword != "" {
-		authString = fmt.Sprintf("%s password=%s", authString, dbPassword)
-	}
-
+// StartStatsReporter periodically sends usage statistics, read from the database at dsn, to the licence server
+func (l *Licence) StartStatsReporter(dsn string) error {
 	// Start a goroutine to process stats every 12 hours
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour)
@@ -45,7 +34,7 @@ func (l *Licence) StartStatsReporter(dbHost,
 
 			// Connect to the database
 			var err error
-			l.db, err = sqlx.Connect("postgres", authString)
+			l.db, err = sqlx.Connect("postgres", dsn)
 			if err != nil {
 				l.log.WithError(err).Error("failed to connect to the database")
 				continue
@@ -0,0 +1,413 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v3"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// stateFile records a migration in the storage directory, so it can be rolled back
+const stateFile = "compose-migration.json"
+
+// managedEnv are the .env keys that are translated into the config, or that the daemon sets itself. Everything
+// else in the .env file is passed through to the app as an override.
+var managedEnv = map[string]bool{
+	"NODEISP_DOMAIN":               true,
+	"NODEISP_LICENCE_KEY_ID":       true,
+	"NODEISP_LICENCE_KEY_CODE":     true,
+	"APP_NAME":                     true,
+	"APP_KEY":                      true,
+	"APP_URL":                      true,
+	"APP_ENV":                      true,
+	"APP_VERSION":                  true,
+	"DB_CONNECTION":                true,
+	"DB_HOST":                      true,
+	"DB_PORT":                      true,
+	"DB_USERNAME":                  true,
+	"DB_DATABASE":                  true,
+	"DB_PASSWORD":                  true,
+	"REDIS_HOST":                   true,
+	"REDIS_PORT":                   true,
+	"REDIS_PASSWORD":               true,
+	"CACHE_DRIVER":                 true,
+	"QUEUE_CONNECTION":             true,
+	"TELESCOPE_PATH":               true,
+	"HORIZON_PATH":                 true,
+	"FILESYSTEM_DISK":              true,
+	"SERVICES_GOTENBERG_URL":       true,
+	"SERVICES_GOOGLE_MAPS_API_KEY": true,
+	"MAIL_MAILER":                  true,
+	"MAIL_HOST":                    true,
+	"MAIL_PORT":                    true,
+	"MAIL_ENCRYPTION":              true,
+	"MAIL_SCHEME":                  true,
+	"MAIL_USERNAME":                true,
+	"MAIL_PASSWORD":                true,
+	"MAIL_FROM_ADDRESS":            true,
+	"MAIL_FROM_NAME":               true,
+}
+
+// ComposeOptions configures a migration from the docker-compose deployment
+type ComposeOptions struct {
+	// Dir is the directory containing docker-compose.yaml and .env
+	Dir string
+
+	// Project is the compose project name, the prefix of the volume names. Defaults to the name of Dir.
+	Project string
+
+	// Email is used for the ACME account, the compose deployment had no equivalent
+	Email string
+
+	// Data and Logs are the storage directories for the daemon
+	Data string
+	Logs string
+
+	// Force allows overwriting an existing config and data directories
+	Force bool
+}
+
+// State is written to the storage directory after a migration, and read back to roll it back
+type State struct {
+	ComposeDir string    `json:"compose_dir"`
+	Project    string    `json:"project"`
+	ConfigFile string    `json:"config_file"`
+	MigratedAt time.Time `json:"migrated_at"`
+
+	// path is where the state was loaded from
+	path string
+}
+
+// volume is a compose volume, and where its contents are copied to under the storage directory
+type volume struct {
+	name   string
+	target []string
+}
+
+var volumes = []volume{
+	{name: "postgres_data", target: []string{"postgres"}},
+	{name: "app_data", target: []string{"nodeisp", "storage"}},
+	{name: "app_config", target: []string{"nodeisp", "licence"}},
+}
+
+// FromCompose migrates a docker-compose deployment to the daemon. The compose stack is stopped, the config is
+// generated from its .env file and the data is copied out of the compose volumes. The volumes themselves are
+// left untouched, so the migration can be rolled back.
+func FromCompose(ctx context.Context, opts ComposeOptions) error {
+	l := log.WithField("component", "migrate")
+
+	if opts.Project == "" {
+		opts.Project = composeProject(opts.Dir)
+	}
+
+	if _, err := os.Stat(config.File); err == nil && !opts.Force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", config.File)
+	}
+
+	env, err := config.ReadEnvFile(filepath.Join(opts.Dir, ".env"))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := composeConfig(env, opts)
+	if err != nil {
+		return err
+	}
+
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+
+	// Find the volumes before stopping anything, so a missing volume does not cause downtime
+	sources := map[string]string{}
+	for _, v := range volumes {
+		name := opts.Project + "_" + v.name
+
+		vol, err := docker.VolumeInspect(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to find compose volume %s: %w", name, err)
+		}
+
+		target := filepath.Join(append([]string{opts.Data}, v.target...)...)
+		if !opts.Force && !emptyDir(target) {
+			return fmt.Errorf("%s is not empty, use --force to overwrite it", target)
+		}
+
+		sources[v.name] = vol.Mountpoint
+	}
+
+	l.WithField("dir", opts.Dir).Info("stopping the compose stack")
+	if err := compose(ctx, opts, "stop"); err != nil {
+		return err
+	}
+
+	// From here on, bring the compose stack back up if anything fails
+	if err := migrateData(cfg, sources, opts); err != nil {
+		l.WithError(err).Error("migration failed, restarting the compose stack")
+
+		if upErr := compose(ctx, opts, "up", "-d"); upErr != nil {
+			l.WithError(upErr).Error("failed to restart the compose stack")
+		}
+
+		return err
+	}
+
+	l.WithField("config", config.File).Info("migration complete")
+
+	return nil
+}
+
+// migrateData copies the volumes into the storage directory, and writes the config and migration state
+func migrateData(cfg *config.Config, sources map[string]string, opts ComposeOptions) error {
+	for _, v := range volumes {
+		target := filepath.Join(append([]string{opts.Data}, v.target...)...)
+
+		log.WithField("component", "migrate").
+			WithField("volume", v.name).
+			WithField("target", target).
+			Info("copying volume")
+
+		if err := copyTree(sources[v.name], target); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", v.name, err)
+		}
+	}
+
+	if err := os.MkdirAll(opts.Logs, 0755); err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(config.File), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(config.File, b, 0600); err != nil {
+		return err
+	}
+
+	state, err := json.MarshalIndent(&State{
+		ComposeDir: opts.Dir,
+		Project:    opts.Project,
+		ConfigFile: config.File,
+		MigratedAt: time.Now(),
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(opts.Data, stateFile), state, 0644)
+}
+
+// composeConfig translates the compose .env file into a config
+func composeConfig(env map[string]string, opts ComposeOptions) (*config.Config, error) {
+	for _, key := range []string{"NODEISP_DOMAIN", "NODEISP_LICENCE_KEY_ID", "NODEISP_LICENCE_KEY_CODE", "APP_KEY", "DB_PASSWORD"} {
+		if env[key] == "" {
+			return nil, fmt.Errorf("%s is not set in the .env file", key)
+		}
+	}
+
+	dbName := env["DB_DATABASE"]
+	if dbName == "" {
+		dbName = "nodeisp"
+	}
+
+	token := make([]byte, 32)
+	_, _ = rand.Read(token)
+
+	cfg := &config.Config{
+		Version: config.CurrentVersion,
+		Licence: &config.Licence{
+			ID:  env["NODEISP_LICENCE_KEY_ID"],
+			Key: env["NODEISP_LICENCE_KEY_CODE"],
+		},
+		HTTPServer: &config.HTTPServer{
+			Domains: []string{env["NODEISP_DOMAIN"]},
+			TLS: &config.TLS{
+				Email: opts.Email,
+			},
+		},
+		Storage: &config.Storage{
+			Data: opts.Data,
+			Logs: opts.Logs,
+		},
+		App: &config.App{
+			Name: env["APP_NAME"],
+			Key:  env["APP_KEY"],
+		},
+		Database: &config.Database{
+			Name:     dbName,
+			Password: env["DB_PASSWORD"],
+		},
+		Redis: &config.Redis{
+			Password: base64.StdEncoding.EncodeToString(token),
+		},
+		Services: &config.Services{
+			GoogleMapsApiKey: env["SERVICES_GOOGLE_MAPS_API_KEY"],
+		},
+	}
+
+	if env["MAIL_HOST"] != "" {
+		cfg.Mail = composeMail(env)
+	}
+
+	// Anything else in the .env file (payment gateways etc) is passed through to the app
+	extra := map[string]string{}
+	for k, v := range env {
+		if !managedEnv[k] {
+			extra[k] = v
+		}
+	}
+
+	if len(extra) > 0 {
+		cfg.Services.App = &config.ServiceOverride{Env: extra}
+	}
+
+	return cfg, nil
+}
+
+// composeMail translates the laravel mail settings into the mail config
+func composeMail(env map[string]string) *config.Mail {
+	port, err := strconv.Atoi(env["MAIL_PORT"])
+	if err != nil {
+		port = 587
+	}
+
+	tls := "starttls"
+	switch strings.ToLower(env["MAIL_ENCRYPTION"]) {
+	case "ssl", "smtps":
+		tls = "tls"
+	case "null", "none":
+		tls = "none"
+	}
+
+	from := env["MAIL_FROM_ADDRESS"]
+	if from == "" {
+		from = "noreply@" + env["NODEISP_DOMAIN"]
+	}
+
+	return &config.Mail{
+		Host:        env["MAIL_HOST"],
+		Port:        port,
+		TLS:         tls,
+		Username:    env["MAIL_USERNAME"],
+		Password:    env["MAIL_PASSWORD"],
+		FromAddress: from,
+		FromName:    env["MAIL_FROM_NAME"],
+	}
+}
+
+// LoadState returns the record of the compose migration, the config it points at must still be in place
+func LoadState() (*State, error) {
+	cfg, err := config.New()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(filepath.Join(cfg.Storage.Data, stateFile))
+	if err != nil {
+		return nil, fmt.Errorf("no compose migration found: %w", err)
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+
+	state.path = filepath.Join(cfg.Storage.Data, stateFile)
+
+	return state, nil
+}
+
+// Rollback stops the daemon and its containers, moves the generated config out of the way and starts the compose
+// stack again. The compose volumes were never modified, so everything written since the migration is lost; it is
+// only kept in the daemon's storage directory, which is left in place. Callers must confirm that with the user.
+func Rollback(ctx context.Context) error {
+	l := log.WithField("component", "migrate")
+
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat("/etc/systemd/system/nodeisp.service"); err == nil {
+		l.Info("stopping the nodeisp service")
+		if out, err := exec.CommandContext(ctx, "systemctl", "disable", "--now", "nodeisp").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to stop the nodeisp service: %s: %w", out, err)
+		}
+	}
+
+	if err := stopManagedContainers(ctx); err != nil {
+		return err
+	}
+
+	migrated := state.ConfigFile + ".migrated"
+	if err := os.Rename(state.ConfigFile, migrated); err != nil {
+		return err
+	}
+
+	l.WithField("config", migrated).Info("moved the generated config aside")
+
+	if err := compose(ctx, ComposeOptions{Dir: state.ComposeDir, Project: state.Project}, "up", "-d"); err != nil {
+		return err
+	}
+
+	l.WithField("dir", state.ComposeDir).Info("compose stack started")
+
+	return os.Remove(state.path)
+}
+
+// compose runs a docker compose command against the compose deployment
+func compose(ctx context.Context, opts ComposeOptions, args ...string) error {
+	args = append([]string{"compose", "--project-directory", opts.Dir, "--project-name", opts.Project}, args...)
+
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker %s: %s: %w", strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+
+	return nil
+}
+
+// composeProject returns the default compose project name for a directory, which prefixes the volume names
+func composeProject(dir string) string {
+	if p := os.Getenv("COMPOSE_PROJECT_NAME"); p != "" {
+		return p
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+
+	name := strings.ToLower(filepath.Base(abs))
+
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+func emptyDir(path string) bool {
+	entries, err := os.ReadDir(path)
+	return err != nil || len(entries) == 0
+}
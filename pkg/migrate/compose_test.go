@@ -0,0 +1,213 @@
+package migrate
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+const composeYAML = `services:
+  app:
+    image: ghcr.io/node-isp/node-isp:v0.11.8
+    env_file: .env
+    volumes:
+      - app_data:/app/storage
+      - app_config:/app/config/licence
+  postgres:
+    image: postgres:16
+    volumes:
+      - postgres_data:/var/lib/postgresql/data
+volumes:
+  app_data:
+  app_config:
+  postgres_data:
+`
+
+const composeEnv = `# Written by the installer
+NODEISP_DOMAIN=isp.example.com
+NODEISP_LICENCE_KEY_ID=licence-id
+NODEISP_LICENCE_KEY_CODE="licence-code"
+APP_NAME='Example ISP'
+APP_KEY=base64:c2VjcmV0
+APP_URL=https://isp.example.com
+DB_HOST=postgres
+DB_DATABASE=isp
+DB_PASSWORD=db-password
+REDIS_HOST=redis
+SERVICES_GOOGLE_MAPS_API_KEY=maps-key
+
+MAIL_HOST=smtp.example.com
+MAIL_PORT=465
+MAIL_ENCRYPTION=ssl
+MAIL_USERNAME=mailer
+MAIL_PASSWORD=mail-password
+MAIL_FROM_NAME="Example ISP"
+
+# Payment gateways are passed through to the app
+export STRIPE_SECRET=sk_test_123
+PAYPAL_CLIENT_ID = paypal-id
+`
+
+// writeCompose writes a compose deployment to a directory, and returns it
+func writeCompose(t *testing.T, env string) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "Node ISP")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{"docker-compose.yaml": composeYAML, ".env": env} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestComposeConfig(t *testing.T) {
+	dir := writeCompose(t, composeEnv)
+
+	env, err := config.ReadEnvFile(filepath.Join(dir, ".env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ComposeOptions{Dir: dir, Email: "admin@example.com", Data: "/var/lib/nodeisp", Logs: "/var/log/nodeisp"}
+
+	cfg, err := composeConfig(env, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The generated config is written as YAML, so check what is read back from it
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &config.Config{}
+	if err := yaml.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"version", got.Version, config.CurrentVersion},
+		{"licence id", got.Licence.ID, "licence-id"},
+		{"licence key", got.Licence.Key, "licence-code"},
+		{"domains", strings.Join(got.HTTPServer.Domains, ","), "isp.example.com"},
+		{"acme email", got.HTTPServer.TLS.Email, "admin@example.com"},
+		{"data", got.Storage.Data, "/var/lib/nodeisp"},
+		{"logs", got.Storage.Logs, "/var/log/nodeisp"},
+		{"app name", got.App.Name, "Example ISP"},
+		{"app key", got.App.Key, "base64:c2VjcmV0"},
+		{"database", got.Database.Name, "isp"},
+		{"database password", got.Database.Password, "db-password"},
+		{"google maps key", got.Services.GoogleMapsApiKey, "maps-key"},
+		{"mail host", got.Mail.Host, "smtp.example.com"},
+		{"mail port", got.Mail.Port, 465},
+		{"mail tls", got.Mail.TLS, "tls"},
+		{"mail username", got.Mail.Username, "mailer"},
+		{"mail password", got.Mail.Password, "mail-password"},
+		{"mail from", got.Mail.FromAddress, "noreply@isp.example.com"},
+		{"mail from name", got.Mail.FromName, "Example ISP"},
+	}
+
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	if got.Redis.Password == "" {
+		t.Error("managed redis has no password")
+	}
+
+	// Only the settings the config doesn't cover reach services.app.env
+	want := map[string]string{"STRIPE_SECRET": "sk_test_123", "PAYPAL_CLIENT_ID": "paypal-id"}
+	if got.Services.App == nil || !maps.Equal(got.Services.App.Env, want) {
+		t.Errorf("services.app.env = %v, want %v", got.Services.App, want)
+	}
+
+	if project := composeProject(dir); project != "nodeisp" {
+		t.Errorf("composeProject() = %q, want nodeisp", project)
+	}
+}
+
+func TestComposeConfigDefaults(t *testing.T) {
+	env := map[string]string{
+		"NODEISP_DOMAIN":           "isp.example.com",
+		"NODEISP_LICENCE_KEY_ID":   "licence-id",
+		"NODEISP_LICENCE_KEY_CODE": "licence-code",
+		"APP_KEY":                  "base64:c2VjcmV0",
+		"DB_PASSWORD":              "db-password",
+		"APP_ENV":                  "production",
+	}
+
+	cfg, err := composeConfig(env, ComposeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database.Name != "nodeisp" {
+		t.Errorf("database = %q, want nodeisp", cfg.Database.Name)
+	}
+	if cfg.Mail != nil {
+		t.Errorf("mail = %+v without a MAIL_HOST", cfg.Mail)
+	}
+	if cfg.Services.App != nil {
+		t.Errorf("services.app = %+v with only managed settings", cfg.Services.App)
+	}
+}
+
+func TestComposeConfigMissing(t *testing.T) {
+	for _, key := range []string{"NODEISP_DOMAIN", "NODEISP_LICENCE_KEY_ID", "NODEISP_LICENCE_KEY_CODE", "APP_KEY", "DB_PASSWORD"} {
+		t.Run(key, func(t *testing.T) {
+			env, err := config.ReadEnvFile(filepath.Join(writeCompose(t, composeEnv), ".env"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(env, key)
+
+			if _, err := composeConfig(env, ComposeOptions{}); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("err = %v, want one naming %s", err, key)
+			}
+		})
+	}
+}
+
+func TestComposeMail(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantPort int
+		wantTLS  string
+	}{
+		{name: "defaults", env: map[string]string{}, wantPort: 587, wantTLS: "starttls"},
+		{name: "tls", env: map[string]string{"MAIL_ENCRYPTION": "tls", "MAIL_PORT": "587"}, wantPort: 587, wantTLS: "starttls"},
+		{name: "ssl", env: map[string]string{"MAIL_ENCRYPTION": "SSL", "MAIL_PORT": "465"}, wantPort: 465, wantTLS: "tls"},
+		{name: "smtps", env: map[string]string{"MAIL_ENCRYPTION": "smtps"}, wantPort: 587, wantTLS: "tls"},
+		{name: "none", env: map[string]string{"MAIL_ENCRYPTION": "null", "MAIL_PORT": "25"}, wantPort: 25, wantTLS: "none"},
+		{name: "invalid port", env: map[string]string{"MAIL_PORT": "smtp"}, wantPort: 587, wantTLS: "starttls"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := composeMail(tt.env)
+
+			if mail.Port != tt.wantPort || mail.TLS != tt.wantTLS {
+				t.Errorf("port, tls = %d, %s, want %d, %s", mail.Port, mail.TLS, tt.wantPort, tt.wantTLS)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyTree(t *testing.T) {
+	src, dst := filepath.Join(t.TempDir(), "_data"), filepath.Join(t.TempDir(), "postgres")
+
+	// A postgres data directory is owned by the postgres user, and only readable by it
+	const uid, gid = 999, 999
+
+	type entry struct {
+		path string
+		mode fs.FileMode
+		data string
+		link string
+	}
+
+	// The copy is a new directory owned like the volume, which postgres refuses to start without
+	entries := []entry{
+		{path: "", mode: fs.ModeDir | 0700},
+		{path: "base", mode: fs.ModeDir | 0700},
+		{path: "base/1", mode: fs.ModeDir | 0750},
+		{path: "base/1/112", mode: 0600, data: "table"},
+		{path: "PG_VERSION", mode: 0644, data: "16\n"},
+		{path: "postmaster.opts", mode: 0600, data: "/usr/lib/postgresql/16/bin/postgres"},
+		{path: "run.sh", mode: 0755, data: "#!/bin/sh\n"},
+		{path: "pg_wal", link: "/var/lib/postgresql/wal"},
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(src, e.path)
+
+		var err error
+		switch {
+		case e.link != "":
+			err = os.Symlink(e.link, path)
+		case e.mode.IsDir():
+			err = os.Mkdir(path, e.mode.Perm())
+		default:
+			err = os.WriteFile(path, []byte(e.data), e.mode.Perm())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Lchown(path, uid, gid); err != nil {
+			t.Skipf("can't change ownership to check it is kept: %v", err)
+		}
+		if e.link == "" {
+			// The umask is not applied to the source, as it wasn't to the volume
+			if err := os.Chmod(path, e.mode.Perm()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := copyTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(dst, e.path)
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Errorf("%s: %v", e.path, err)
+			continue
+		}
+
+		st := info.Sys().(*syscall.Stat_t)
+		if st.Uid != uid || st.Gid != gid {
+			t.Errorf("%s is owned by %d:%d, want %d:%d", e.path, st.Uid, st.Gid, uid, gid)
+		}
+
+		switch {
+		case e.link != "":
+			if link, err := os.Readlink(path); err != nil || link != e.link {
+				t.Errorf("%s links to %q (%v), want %q", e.path, link, err, e.link)
+			}
+		case info.Mode() != e.mode:
+			t.Errorf("%s has mode %s, want %s", e.path, info.Mode(), e.mode)
+		case e.data != "":
+			if b, err := os.ReadFile(path); err != nil || string(b) != e.data {
+				t.Errorf("%s = %q (%v), want %q", e.path, b, err, e.data)
+			}
+		}
+	}
+}
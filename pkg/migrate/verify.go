@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "github.com/node-isp/node-isp/pkg/grpc"
+)
+
+// Verify waits for the daemon to report every service as running
+func Verify(ctx context.Context, timeout time.Duration) error {
+	l := log.WithField("component", "migrate")
+
+	conn, err := grpc.NewClient(pb.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c := pb.NewNodeISPServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var lastErr error
+
+	for {
+		if lastErr = checkServices(ctx, c); lastErr == nil {
+			l.Info("all services are running")
+			return nil
+		}
+
+		l.WithError(lastErr).Info("waiting for services to start")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("services did not start within %s, roll back with `nodeisp migrate rollback`: %w", timeout, lastErr)
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkServices(ctx context.Context, c pb.NodeISPServiceClient) error {
+	r, err := c.GetStatus(ctx, &pb.GetStatusRequest{})
+	if err != nil {
+		return err
+	}
+
+	found := map[string]bool{}
+
+	for _, s := range r.Services {
+		found[s.Name] = true
+
+		if s.Status != "running" && s.Status != "reachable" {
+			return fmt.Errorf("%s is %q", s.Name, s.Status)
+		}
+	}
+
+	for _, name := range []string{"app", "horizon", "postgres", "redis"} {
+		if !found[name] {
+			return fmt.Errorf("%s has not been started", name)
+		}
+	}
+
+	return nil
+}
+
+// stopManagedContainers stops the containers started by the daemon, so they release the ports and data
+func stopManagedContainers(ctx context.Context) error {
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+
+	ctrs, err := docker.ContainerList(ctx, container.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("label", "app=nodeisp"),
+	)})
+	if err != nil {
+		return err
+	}
+
+	for _, ctr := range ctrs {
+		if err := docker.ContainerStop(ctx, ctr.ID, container.StopOptions{}); err != nil {
+			return err
+		}
+
+		log.WithField("component", "migrate").WithField("container", ctr.Names[0]).Info("stopped container")
+	}
+
+	return nil
+}
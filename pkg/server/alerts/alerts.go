@@ -0,0 +1,247 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/licence"
+	"github.com/node-isp/node-isp/pkg/server/resources"
+	"github.com/node-isp/node-isp/pkg/server/service"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+// Alert is a notification that a problem has started or been resolved
+type Alert struct {
+	Rule     string
+	Subject  string
+	Severity string
+	Message  string
+
+	// Status is firing or resolved
+	Status string
+
+	// Instance is the primary domain of the NodeISP server, to tell servers apart
+	Instance string
+
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+// Title is a one line summary of the alert
+func (a Alert) Title() string {
+	status := "FIRING"
+	if a.Status == "resolved" {
+		status = "RESOLVED"
+	}
+
+	return fmt.Sprintf("[%s] %s on %s: %s", status, a.Rule, a.Instance, a.Subject)
+}
+
+// Sources are what the rules check. Any of them may be nil, and rules that need them are skipped.
+type Sources struct {
+	Manager   *service.Manager
+	Licence   *licence.Licence
+	WebServer *webserver.WebServer
+	Resources *resources.Monitor
+
+	// CronFailures returns how many runs of the app's scheduler in a row have failed
+	CronFailures func() int
+}
+
+// state tracks a problem found by a rule, from when it was first seen
+type state struct {
+	since    time.Time
+	message  string
+	firing   bool
+	lastSent time.Time
+}
+
+// Alerter checks the rules on an interval, and notifies when a problem has lasted as long as the rule allows.
+// Each problem is only sent again after the repeat interval, and once more when it is resolved.
+type Alerter struct {
+	cfg       *config.Alerts
+	rules     []config.AlertRule
+	sources   Sources
+	notifiers []Notifier
+	instance  string
+	log       *log.Entry
+
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+// New creates the alerter, the mail settings are used to send alerts by email
+func New(cfg *config.Alerts, mail *config.Mail, instance string, src Sources, log *log.Entry) (*Alerter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &Alerter{
+		cfg:      cfg,
+		rules:    slices.Clone(cfg.RulesOrDefault()),
+		sources:  src,
+		instance: instance,
+		log:      log,
+		states:   map[string]*state{},
+	}
+
+	for i := range a.rules {
+		if a.rules[i].Severity == "" {
+			a.rules[i].Severity = "critical"
+		}
+	}
+
+	for _, w := range cfg.Webhooks {
+		a.notifiers = append(a.notifiers, &webhook{url: w.URL, headers: w.Headers})
+	}
+
+	if cfg.Email != nil && len(cfg.Email.To) > 0 {
+		if !mail.Configured() {
+			return nil, fmt.Errorf("alert emails need an SMTP server in the mail section")
+		}
+
+		a.notifiers = append(a.notifiers, &email{mail: mail, to: cfg.Email.To})
+	}
+
+	return a, nil
+}
+
+// Run checks the rules until the context is done
+func (a *Alerter) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.evaluate(ctx, time.Now())
+		}
+	}
+}
+
+// evaluate runs each rule, and notifies about the problems that have started firing or been resolved
+func (a *Alerter) evaluate(ctx context.Context, now time.Time) {
+	var alerts []Alert
+
+	a.mu.Lock()
+
+	for _, rule := range a.rules {
+		problems, err := a.check(ctx, rule)
+		if err != nil {
+			a.log.WithError(err).WithField("rule", rule.Name).Warn("failed to check alert rule")
+			continue
+		}
+
+		seen := map[string]bool{}
+
+		for subject, message := range problems {
+			key := rule.Name + "/" + subject
+			seen[key] = true
+
+			st, ok := a.states[key]
+			if !ok {
+				st = &state{since: now}
+				a.states[key] = st
+			}
+			st.message = message
+
+			if now.Sub(st.since) < rule.For {
+				continue
+			}
+
+			if !st.firing || now.Sub(st.lastSent) >= a.cfg.RepeatInterval {
+				st.firing, st.lastSent = true, now
+				alerts = append(alerts, a.alert(rule, subject, st, "firing", time.Time{}))
+			}
+		}
+
+		// Problems that are no longer found are resolved
+		for key, st := range a.states {
+			subject, ok := strings.CutPrefix(key, rule.Name+"/")
+			if !ok || seen[key] {
+				continue
+			}
+
+			if st.firing {
+				alerts = append(alerts, a.alert(rule, subject, st, "resolved", now))
+			}
+
+			delete(a.states, key)
+		}
+	}
+
+	a.mu.Unlock()
+
+	for _, alert := range alerts {
+		a.send(ctx, alert)
+	}
+}
+
+func (a *Alerter) alert(rule config.AlertRule, subject string, st *state, status string, ends time.Time) Alert {
+	return Alert{
+		Rule:     rule.Name,
+		Subject:  subject,
+		Severity: rule.Severity,
+		Message:  st.message,
+		Status:   status,
+		Instance: a.instance,
+		StartsAt: st.since,
+		EndsAt:   ends,
+	}
+}
+
+// send logs the alert and sends it to every notifier, a failing notifier doesn't stop the others
+func (a *Alerter) send(ctx context.Context, alert Alert) {
+	l := a.log.WithField("rule", alert.Rule).WithField("subject", alert.Subject).WithField("severity", alert.Severity)
+
+	if alert.Status == "resolved" {
+		l.Info("alert resolved")
+	} else {
+		l.WithField("message", alert.Message).Warn("alert firing")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	for _, n := range a.notifiers {
+		if err := n.Notify(ctx, alert); err != nil {
+			l.WithError(err).WithField("notifier", n.String()).Error("failed to send alert")
+		}
+	}
+}
+
+// TestResult is the outcome of sending the test alert to a notifier
+type TestResult struct {
+	Notifier string
+	Err      error
+}
+
+// Test sends a test alert to every notifier
+func (a *Alerter) Test(ctx context.Context) []TestResult {
+	alert := Alert{
+		Rule:     "test",
+		Subject:  "alerting",
+		Severity: "info",
+		Message:  "This is a test alert from NodeISP. If you can read this, alerts are working.",
+		Status:   "firing",
+		Instance: a.instance,
+		StartsAt: time.Now(),
+	}
+
+	var results []TestResult
+
+	for _, n := range a.notifiers {
+		results = append(results, TestResult{Notifier: n.String(), Err: n.Notify(ctx, alert)})
+	}
+
+	return results
+}
@@ -0,0 +1,168 @@
+package alerts
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// recorder is a notifier that keeps the alerts it is sent
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recorder) Notify(_ context.Context, alert Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func (r *recorder) String() string { return "recorder" }
+
+// take returns the alerts sent since it was last called
+func (r *recorder) take() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alerts := r.alerts
+	r.alerts = nil
+	return alerts
+}
+
+func TestEvaluate(t *testing.T) {
+	// step is one evaluation, at a time after the start, with the scheduler failing that many times in a row
+	type step struct {
+		at       time.Duration
+		failures int
+		want     []string
+	}
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		steps []step
+	}{
+		{
+			name:  "fires once the problem has lasted for the rule's duration",
+			after: 5 * time.Minute,
+			steps: []step{
+				{at: 0, failures: 3},
+				{at: time.Minute, failures: 4},
+				{at: 4 * time.Minute, failures: 7},
+				{at: 5 * time.Minute, failures: 8, want: []string{"firing"}},
+				{at: 6 * time.Minute, failures: 9},
+				{at: 30 * time.Minute, failures: 30},
+			},
+		},
+		{
+			name:  "repeats after the repeat interval",
+			after: 5 * time.Minute,
+			steps: []step{
+				{at: 0, failures: 3},
+				{at: 5 * time.Minute, failures: 3, want: []string{"firing"}},
+				{at: 64 * time.Minute, failures: 3},
+				{at: 65 * time.Minute, failures: 3, want: []string{"firing"}},
+				{at: 66 * time.Minute, failures: 3},
+				{at: 125 * time.Minute, failures: 3, want: []string{"firing"}},
+			},
+		},
+		{
+			name:  "resolves when the problem clears",
+			after: 5 * time.Minute,
+			steps: []step{
+				{at: 0, failures: 3},
+				{at: 5 * time.Minute, failures: 3, want: []string{"firing"}},
+				{at: 10 * time.Minute, failures: 0, want: []string{"resolved"}},
+				{at: 11 * time.Minute, failures: 0},
+			},
+		},
+		{
+			name:  "a problem that clears before firing isn't resolved, and starts again when it's back",
+			after: 5 * time.Minute,
+			steps: []step{
+				{at: 0, failures: 3},
+				{at: 2 * time.Minute, failures: 0},
+				{at: 3 * time.Minute, failures: 3},
+				{at: 7 * time.Minute, failures: 3},
+				{at: 8 * time.Minute, failures: 3, want: []string{"firing"}},
+			},
+		},
+		{
+			name: "fires straight away without a duration",
+			steps: []step{
+				{at: 0, failures: 2},
+				{at: time.Minute, failures: 3, want: []string{"firing"}},
+				{at: 2 * time.Minute, failures: 0, want: []string{"resolved"}},
+			},
+		},
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failures int
+
+			cfg := &config.Alerts{
+				RepeatInterval: time.Hour,
+				Rules:          []config.AlertRule{{Name: "cron", Check: "cron_failures", Threshold: 3, For: tt.after}},
+			}
+			src := Sources{CronFailures: func() int { return failures }}
+
+			a, err := New(cfg, &config.Mail{}, "isp.example.com", src, (&log.Logger{Handler: discard.Default}).WithField("component", "alerts"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := &recorder{}
+			a.notifiers = []Notifier{rec}
+
+			// firstSeen is when the problem now being alerted on was found, it is reset when the problem clears
+			var firstSeen time.Time
+
+			for _, s := range tt.steps {
+				now := start.Add(s.at)
+				failures = s.failures
+
+				if failures >= 3 && firstSeen.IsZero() {
+					firstSeen = now
+				}
+
+				a.evaluate(context.Background(), now)
+
+				var got []string
+				for _, alert := range rec.take() {
+					got = append(got, alert.Status)
+
+					if alert.Rule != "cron" || alert.Subject != "schedule" || alert.Severity != "critical" || alert.Instance != "isp.example.com" {
+						t.Errorf("at %s: alert = %+v", s.at, alert)
+					}
+					if !alert.StartsAt.Equal(firstSeen) {
+						t.Errorf("at %s: StartsAt = %s, want %s", s.at, alert.StartsAt, firstSeen)
+					}
+					if alert.Status == "resolved" && !alert.EndsAt.Equal(now) {
+						t.Errorf("at %s: EndsAt = %s, want %s", s.at, alert.EndsAt, now)
+					}
+				}
+
+				if !slices.Equal(got, s.want) {
+					t.Errorf("at %s: sent %v, want %v", s.at, got, s.want)
+				}
+
+				if failures < 3 {
+					firstSeen = time.Time{}
+				}
+			}
+		})
+	}
+}
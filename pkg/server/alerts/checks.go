@@ -0,0 +1,173 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/docker/go-units"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/resources"
+)
+
+// check runs a rule, and returns the problems it found keyed by subject
+func (a *Alerter) check(ctx context.Context, rule config.AlertRule) (map[string]string, error) {
+	switch rule.Check {
+	case "service_down":
+		return a.checkServices(ctx, rule)
+	case "certificate_expiry":
+		return a.checkCertificates(rule), nil
+	case "certificate_renewal":
+		return a.checkRenewals(), nil
+	case "licence":
+		return a.checkLicence(), nil
+	case "cron_failures":
+		return a.checkCron(rule), nil
+	case "resources":
+		return a.checkResources(), nil
+	}
+
+	return nil, fmt.Errorf("unknown check %q", rule.Check)
+}
+
+func (a *Alerter) checkServices(ctx context.Context, rule config.AlertRule) (map[string]string, error) {
+	problems := map[string]string{}
+
+	if a.sources.Manager == nil {
+		return problems, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	states, err := a.sources.Manager.States(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	services := rule.Services
+	if len(services) == 0 {
+		services = []string{"app"}
+	}
+
+	for _, st := range states {
+		if !slices.Contains(services, st.Service) {
+			continue
+		}
+
+		switch {
+		case st.State != "running":
+			problems[st.Service] = fmt.Sprintf("The %s container is %s", st.Service, st.State)
+		case st.Health == "unhealthy":
+			problems[st.Service] = fmt.Sprintf("The %s container is failing its healthcheck", st.Service)
+		}
+	}
+
+	return problems, nil
+}
+
+func (a *Alerter) checkCertificates(rule config.AlertRule) map[string]string {
+	problems := map[string]string{}
+
+	if a.sources.WebServer == nil {
+		return problems
+	}
+
+	for _, cert := range a.sources.WebServer.Certificates() {
+		if len(cert.Names) == 0 || cert.NotAfter.IsZero() {
+			continue
+		}
+
+		days := time.Until(cert.NotAfter).Hours() / 24
+		if days >= rule.Threshold {
+			continue
+		}
+
+		msg := fmt.Sprintf("The certificate for %s expires in %.0f days, on %s", cert.Names[0], days, cert.NotAfter.Format(time.RFC1123))
+		if cert.Managed {
+			msg += ", it should have been renewed by now"
+		}
+		if days < 0 {
+			msg = fmt.Sprintf("The certificate for %s expired on %s", cert.Names[0], cert.NotAfter.Format(time.RFC1123))
+		}
+
+		problems[cert.Names[0]] = msg
+	}
+
+	return problems
+}
+
+func (a *Alerter) checkRenewals() map[string]string {
+	problems := map[string]string{}
+
+	if a.sources.WebServer == nil {
+		return problems
+	}
+
+	for _, cert := range a.sources.WebServer.Certificates() {
+		if cert.RenewalError != nil {
+			problems[cert.Names[0]] = fmt.Sprintf("The certificate for %s could not be renewed: %v", cert.Names[0], cert.RenewalError)
+		}
+	}
+
+	return problems
+}
+
+func (a *Alerter) checkLicence() map[string]string {
+	if a.sources.Licence == nil {
+		return map[string]string{"licence": "The licence could not be validated when NodeISP started"}
+	}
+
+	st := a.sources.Licence.Status()
+
+	switch {
+	case st.Err != nil:
+		return map[string]string{"licence": "The licence could not be refreshed: " + st.Err.Error()}
+	case !st.Valid:
+		return map[string]string{"licence": "The licence server reports the licence is not valid"}
+	}
+
+	return map[string]string{}
+}
+
+func (a *Alerter) checkCron(rule config.AlertRule) map[string]string {
+	if a.sources.CronFailures == nil {
+		return map[string]string{}
+	}
+
+	failures := a.sources.CronFailures()
+	if float64(failures) < rule.Threshold {
+		return map[string]string{}
+	}
+
+	return map[string]string{"schedule": fmt.Sprintf("The app's scheduler has failed %d times in a row", failures)}
+}
+
+func (a *Alerter) checkResources() map[string]string {
+	problems := map[string]string{}
+
+	if a.sources.Resources == nil {
+		return problems
+	}
+
+	usage := a.sources.Resources.Usage()
+
+	for _, d := range usage.Disks {
+		if d.Level != resources.OK {
+			problems["disk "+d.Name] = fmt.Sprintf("Disk usage of %s is %s, %.0f%% full with %s free",
+				d.Path, d.Level, d.Percent(), units.BytesSize(float64(d.Free)))
+		}
+	}
+
+	if usage.MemoryLevel != resources.OK {
+		problems["memory"] = fmt.Sprintf("Memory usage is %s, %.0f%% used", usage.MemoryLevel, usage.MemoryPercent())
+	}
+
+	if usage.LoadLevel != resources.OK {
+		problems["load"] = fmt.Sprintf("Load is %s, %.2f over the last five minutes on %d CPUs", usage.LoadLevel, usage.Load[1], usage.CPUs)
+	}
+
+	return problems
+}
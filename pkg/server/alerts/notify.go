@@ -0,0 +1,201 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// Notifier sends alerts somewhere
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+	String() string
+}
+
+// webhook posts alerts as JSON. The text field is what Slack and Teams show, the rest is for other receivers.
+type webhook struct {
+	url     string
+	headers map[string]string
+}
+
+type webhookPayload struct {
+	Text     string     `json:"text"`
+	Status   string     `json:"status"`
+	Rule     string     `json:"rule"`
+	Subject  string     `json:"subject"`
+	Severity string     `json:"severity"`
+	Message  string     `json:"message"`
+	Instance string     `json:"instance"`
+	StartsAt time.Time  `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+func (w *webhook) Notify(ctx context.Context, alert Alert) error {
+	payload := webhookPayload{
+		Text:     alert.Title() + "\n" + alert.Message,
+		Status:   alert.Status,
+		Rule:     alert.Rule,
+		Subject:  alert.Subject,
+		Severity: alert.Severity,
+		Message:  alert.Message,
+		Instance: alert.Instance,
+		StartsAt: alert.StartsAt,
+	}
+
+	if !alert.EndsAt.IsZero() {
+		payload.EndsAt = &alert.EndsAt
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// String is the webhook's host, the full URL often contains a secret token
+func (w *webhook) String() string {
+	if u, err := url.Parse(w.url); err == nil {
+		return "webhook " + u.Host
+	}
+
+	return "webhook"
+}
+
+// email sends alerts through the SMTP server in the mail settings
+type email struct {
+	mail *config.Mail
+	to   []string
+}
+
+func (e *email) Notify(ctx context.Context, alert Alert) error {
+	from := mail.Address{Name: e.mail.FromName, Address: e.mail.FromAddress}
+	if from.Name == "" {
+		from.Name = "NodeISP"
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", from.String())
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", alert.Title()))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&body, "%s\r\n\r\n", alert.Message)
+	fmt.Fprintf(&body, "Rule: %s\r\nSubject: %s\r\nSeverity: %s\r\nServer: %s\r\nStarted: %s\r\n",
+		alert.Rule, alert.Subject, alert.Severity, alert.Instance, alert.StartsAt.Format(time.RFC1123))
+
+	if !alert.EndsAt.IsZero() {
+		fmt.Fprintf(&body, "Resolved: %s\r\n", alert.EndsAt.Format(time.RFC1123))
+	}
+
+	return sendMail(ctx, e.mail, e.mail.FromAddress, e.to, []byte(body.String()))
+}
+
+func (e *email) String() string {
+	return "email " + strings.Join(e.to, ",")
+}
+
+// sendMail sends a message with the TLS mode of the mail settings: starttls, implicit tls or none
+func sendMail(ctx context.Context, cfg *config.Mail, from string, to []string, msg []byte) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+
+	if cfg.TLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.TLS == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
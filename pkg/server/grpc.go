@@ -2,17 +2,31 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/mail"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/apex/log"
 	"github.com/docker/docker/client"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/node-isp/node-isp/pkg/config"
 	pb "github.com/node-isp/node-isp/pkg/grpc"
+	"github.com/node-isp/node-isp/pkg/server/alerts"
+	"github.com/node-isp/node-isp/pkg/server/proxy"
+	"github.com/node-isp/node-isp/pkg/server/resources"
 	"github.com/node-isp/node-isp/pkg/server/service"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
 	"github.com/node-isp/node-isp/pkg/updater"
 )
 
@@ -22,13 +36,30 @@ type grpcServer struct {
 	u      *updater.Updater
 	mgr    *service.Manager
 	docker *client.Client
+	cfg    *config.Config
+
+	// dsn is the postgres connection string, used to check an external database
+	dsn string
+
+	proxy  *proxy.Proxy
+	alerts *alerts.Alerter
+	ws     *webserver.WebServer
+
+	resources *resources.Monitor
+
+	srv *grpc.Server
 }
 
-// Run starts the gRPC server in a goroutine.
+// Run starts the gRPC server in a goroutine, listening on loopback only as it has no authentication
 func (s *grpcServer) Run() error {
-	lis, err := net.Listen("tcp", ":50051")
+	lis, err := net.Listen("tcp", pb.Address)
 
-	srv := grpc.NewServer()
+	srv := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.UnaryInterceptor(localOnlyUnary),
+		grpc.StreamInterceptor(localOnlyStream),
+	)
+	s.srv = srv
 
 	pb.RegisterNodeISPServiceServer(srv, s)
 
@@ -46,6 +77,66 @@ func (s *grpcServer) Run() error {
 	return nil
 }
 
+// localOnlyUnary refuses calls from anywhere but the host itself. The listener is on loopback already, this
+// guards against it being exposed by a port forward or proxy, as calls such as SetMaintenance, SendTestMail and
+// RenewCertificate have no other authentication.
+func localOnlyUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := checkLocalPeer(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// localOnlyStream is localOnlyUnary for streaming calls
+func localOnlyStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := checkLocalPeer(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+func checkLocalPeer(ctx context.Context, method string) error {
+	p, ok := peer.FromContext(ctx)
+	if ok {
+		if addr, ok := p.Addr.(*net.TCPAddr); ok && addr.IP.IsLoopback() {
+			return nil
+		}
+		if _, ok := p.Addr.(*net.UnixAddr); ok {
+			return nil
+		}
+	}
+
+	remote := "unknown"
+	if ok {
+		remote = p.Addr.String()
+	}
+
+	log.WithField("component", "grpc").WithField("peer", remote).WithField("method", method).Warn("refused call from a remote peer")
+
+	return status.Error(codes.PermissionDenied, "the management API can only be used from the host itself")
+}
+
+// Stop waits for the calls in flight to finish, and cuts them off once the context is done
+func (s *grpcServer) Stop(ctx context.Context) {
+	if s.srv == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.srv.Stop()
+	}
+}
+
 func (s *grpcServer) GetVersion(_ context.Context, _ *pb.GetVersionRequest) (*pb.GetVersionResponse, error) {
 	currentVersion, err := semver.NewVersion(updater.CurrentAppVersion)
 	if err != nil {
@@ -73,7 +164,7 @@ func (s *grpcServer) GetStatus(ctx context.Context, _ *pb.GetStatusRequest) (*pb
 
 	var services []*pb.Service
 
-	for _, svc := range s.mgr.Services {
+	for _, svc := range s.mgr.ServiceList() {
 		// Check if the service is running
 		var state, container string
 		var started time.Time
@@ -97,5 +188,292 @@ func (s *grpcServer) GetStatus(ctx context.Context, _ *pb.GetStatusRequest) (*pb
 		})
 	}
 
-	return &pb.GetStatusResponse{Services: services}, nil
+	// External servers have no container, so report whether they are reachable instead
+	if s.cfg.Database.External() {
+		services = append(services, externalService(
+			"postgres",
+			net.JoinHostPort(s.cfg.Database.Host, strconv.Itoa(s.cfg.Database.Port)),
+			checkPostgres(ctx, s.dsn),
+		))
+	}
+
+	if s.cfg.Redis.External() {
+		services = append(services, externalService(
+			"redis",
+			net.JoinHostPort(s.cfg.Redis.Host, strconv.Itoa(s.cfg.Redis.Port)),
+			checkRedis(ctx, s.cfg.Redis, s.cfg.Redis.Host, s.cfg.Redis.Port),
+		))
+	}
+
+	res := &pb.GetStatusResponse{Services: services}
+	if s.resources != nil {
+		usage := s.resources.Usage()
+		if usage.CheckedAt.IsZero() {
+			usage = s.resources.Refresh(ctx)
+		}
+
+		res.Resources = resourcesToPb(usage)
+	}
+
+	return res, nil
+}
+
+func resourcesToPb(usage resources.Usage) *pb.Resources {
+	r := &pb.Resources{
+		DatabaseSize:    usage.DatabaseSize,
+		LogSize:         usage.LogSize,
+		MemoryTotal:     usage.MemoryTotal,
+		MemoryAvailable: usage.MemoryAvailable,
+		MemoryLevel:     usage.MemoryLevel.String(),
+		Load:            usage.Load[:],
+		Cpus:            int32(usage.CPUs),
+		LoadLevel:       usage.LoadLevel.String(),
+		CheckedAt:       timestamppb.New(usage.CheckedAt),
+	}
+
+	for _, d := range usage.Disks {
+		r.Disks = append(r.Disks, &pb.Disk{
+			Name:  d.Name,
+			Path:  d.Path,
+			Total: d.Total,
+			Used:  d.Used,
+			Free:  d.Free,
+			Level: d.Level.String(),
+		})
+	}
+
+	for _, err := range usage.Errs {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	return r
+}
+
+// testMailScript sends a plain text message through the app's configured mailer. The address is passed through
+// the environment, so it never has to be escaped into the PHP source.
+const testMailScript = `try {
+    Illuminate\Support\Facades\Mail::raw('This is a test message from NodeISP. If you can read this, mail is working.', function ($m) {
+        $m->to(getenv('NODEISP_MAIL_TEST_TO'))->subject('NodeISP test message');
+    });
+    echo 'NODEISP_MAIL_OK';
+} catch (\Throwable $e) {
+    echo $e->getMessage();
+}`
+
+// SendTestMail sends a test message through the app container, and reports the result from the SMTP server
+func (s *grpcServer) SendTestMail(ctx context.Context, req *pb.SendTestMailRequest) (*pb.SendTestMailResponse, error) {
+	addr, err := mail.ParseAddress(req.Address)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid address: %v", err)
+	}
+
+	if !s.cfg.Mail.Configured() {
+		return nil, status.Error(codes.FailedPrecondition, "mail is not configured, add a mail section to the config")
+	}
+
+	app := s.mgr.Service("app")
+	if app == nil {
+		return nil, status.Error(codes.Unavailable, "the app server is not running")
+	}
+
+	out, _, err := s.mgr.Exec(ctx, app,
+		[]string{"php", "artisan", "tinker", "--execute=" + testMailScript},
+		[]string{"NODEISP_MAIL_TEST_TO=" + addr.Address},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	out = strings.TrimSpace(out)
+	if strings.Contains(out, "NODEISP_MAIL_OK") {
+		return &pb.SendTestMailResponse{
+			Success: true,
+			Message: fmt.Sprintf("message sent to %s through %s:%d", addr.Address, s.cfg.Mail.Host, s.cfg.Mail.Port),
+		}, nil
+	}
+
+	return &pb.SendTestMailResponse{Success: false, Message: out}, nil
+}
+
+func externalService(name, addr string, err error) *pb.Service {
+	status := "reachable"
+	if err != nil {
+		status = "unreachable: " + err.Error()
+	}
+
+	return &pb.Service{
+		Name:      name,
+		Container: "external",
+		Image:     addr,
+		Status:    status,
+		Started:   timestamppb.New(time.Time{}),
+	}
+}
+
+// GetRateLimits returns the rate limit counters and the clients currently banned, for tuning the limits
+func (s *grpcServer) GetRateLimits(_ context.Context, _ *pb.GetRateLimitsRequest) (*pb.GetRateLimitsResponse, error) {
+	res := &pb.GetRateLimitsResponse{}
+
+	for _, l := range s.proxy.RateLimitStats() {
+		res.Limits = append(res.Limits, &pb.RateLimit{
+			Path:    l.Path,
+			Methods: l.Methods,
+			Allowed: l.Allowed,
+			Limited: l.Limited,
+			Bans:    l.Bans,
+			Clients: int32(l.Clients),
+		})
+	}
+
+	for _, b := range s.proxy.Bans() {
+		res.Bans = append(res.Bans, &pb.Ban{
+			Ip:    b.IP,
+			Path:  b.Path,
+			Until: timestamppb.New(b.Until),
+		})
+	}
+
+	return res, nil
+}
+
+// SetMaintenance turns maintenance mode on or off, it stays on across restarts until it is turned off
+func (s *grpcServer) SetMaintenance(_ context.Context, req *pb.SetMaintenanceRequest) (*pb.SetMaintenanceResponse, error) {
+	state := s.proxy.SetMaintenance(req.Enabled, req.Message)
+
+	if err := saveMaintenance(s.cfg.Storage.Data, state); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save maintenance state: %v", err)
+	}
+
+	log.WithField("component", "grpc").
+		WithField("enabled", state.Enabled).
+		Info("maintenance mode changed")
+
+	res := &pb.SetMaintenanceResponse{Enabled: state.Enabled, Message: state.Message}
+	if state.Enabled {
+		res.Since = timestamppb.New(state.Since)
+	}
+
+	return res, nil
+}
+
+// TestAlerts sends a test alert to each configured webhook and email address
+func (s *grpcServer) TestAlerts(ctx context.Context, _ *pb.TestAlertsRequest) (*pb.TestAlertsResponse, error) {
+	if s.alerts == nil || !s.cfg.Alerts.Configured() {
+		return nil, status.Error(codes.FailedPrecondition, "no alert webhooks or email addresses are configured")
+	}
+
+	res := &pb.TestAlertsResponse{}
+
+	for _, r := range s.alerts.Test(ctx) {
+		n := &pb.AlertNotifier{Name: r.Notifier, Success: r.Err == nil}
+		if r.Err != nil {
+			n.Error = r.Err.Error()
+		}
+
+		res.Notifiers = append(res.Notifiers, n)
+	}
+
+	return res, nil
+}
+
+// ListCertificates returns the certificates being served
+func (s *grpcServer) ListCertificates(_ context.Context, _ *pb.ListCertificatesRequest) (*pb.ListCertificatesResponse, error) {
+	res := &pb.ListCertificatesResponse{}
+
+	for _, cert := range s.ws.Certificates() {
+		res.Certificates = append(res.Certificates, certificateToPb(cert))
+	}
+
+	return res, nil
+}
+
+// RenewCertificate renews a domain's certificate straight away, and returns the new certificate
+func (s *grpcServer) RenewCertificate(ctx context.Context, req *pb.RenewCertificateRequest) (*pb.RenewCertificateResponse, error) {
+	if req.Domain == "" {
+		return nil, status.Error(codes.InvalidArgument, "a domain is required")
+	}
+
+	// Renewals count towards the CA's rate limits, so repeated requests are refused for a while
+	err := s.ws.RenewCertificate(ctx, req.Domain)
+	if errors.Is(err, webserver.ErrRenewCooldown) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to renew certificate: %v", err)
+	}
+
+	for _, cert := range s.ws.Certificates() {
+		for _, name := range cert.Names {
+			if strings.EqualFold(name, req.Domain) {
+				return &pb.RenewCertificateResponse{Certificate: certificateToPb(cert)}, nil
+			}
+		}
+	}
+
+	return &pb.RenewCertificateResponse{}, nil
+}
+
+// CertificateEvents streams the recent certificate events, and new ones as they happen if the client follows them
+func (s *grpcServer) CertificateEvents(req *pb.CertificateEventsRequest, stream pb.NodeISPService_CertificateEventsServer) error {
+	return s.ws.CertEvents(stream.Context(), req.Follow, func(e webserver.CertEvent) error {
+		return stream.Send(&pb.CertificateEvent{
+			Time:    timestamppb.New(e.Time),
+			Event:   e.Event,
+			Domains: e.Domains,
+			Renewal: e.Renewal,
+			Issuer:  e.Issuer,
+			Error:   e.Error,
+		})
+	})
+}
+
+func certificateToPb(cert webserver.Certificate) *pb.Certificate {
+	c := &pb.Certificate{
+		Names:      cert.Names,
+		Issuer:     cert.Issuer,
+		Serial:     cert.Serial,
+		OcspStatus: cert.OCSPStatus,
+		Managed:    cert.Managed,
+	}
+
+	if !cert.NotAfter.IsZero() {
+		c.NotBefore = timestamppb.New(cert.NotBefore)
+		c.NotAfter = timestamppb.New(cert.NotAfter)
+	}
+
+	if cert.RenewalError != nil {
+		c.RenewalError = cert.RenewalError.Error()
+	}
+
+	return c
+}
+
+// ContainerStats streams the resource usage of each service's container, until the client goes away
+func (s *grpcServer) ContainerStats(req *pb.ContainerStatsRequest, stream pb.NodeISPService_ContainerStatsServer) error {
+	interval := 2 * time.Second
+	if req.Interval > 0 {
+		interval = time.Duration(req.Interval) * time.Second
+	}
+
+	return s.mgr.StreamStats(stream.Context(), interval, func(stats []service.Stats) error {
+		res := &pb.ContainerStatsResponse{Read: timestamppb.Now()}
+
+		for _, st := range stats {
+			res.Containers = append(res.Containers, &pb.ContainerStats{
+				Service:     st.Service,
+				Container:   st.Container,
+				State:       st.State,
+				CpuPercent:  st.CPUPercent,
+				MemoryUsage: st.MemoryUsage,
+				MemoryLimit: st.MemoryLimit,
+				NetworkRx:   st.NetworkRx,
+				NetworkTx:   st.NetworkTx,
+				BlockRead:   st.BlockRead,
+				BlockWrite:  st.BlockWrite,
+				Pids:        st.PIDs,
+			})
+		}
+
+		return stream.Send(res)
+	})
 }
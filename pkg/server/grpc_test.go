@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLocalOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want codes.Code
+	}{
+		{name: "IPv4 loopback", addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000}, want: codes.OK},
+		{name: "IPv6 loopback", addr: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 40000}, want: codes.OK},
+		{name: "unix socket", addr: &net.UnixAddr{Name: "/run/nodeisp.sock", Net: "unix"}, want: codes.OK},
+		{name: "remote IPv4", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}, want: codes.PermissionDenied},
+		{name: "remote IPv6", addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}, want: codes.PermissionDenied},
+		{name: "no peer", want: codes.PermissionDenied},
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/nodeisp.NodeISPService/SetMaintenance"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.addr != nil {
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: tt.addr})
+			}
+
+			called := false
+			_, err := localOnlyUnary(ctx, nil, info, func(context.Context, any) (any, error) {
+				called = true
+				return nil, nil
+			})
+
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+
+			if called != (tt.want == codes.OK) {
+				t.Errorf("handler called = %v", called)
+			}
+		})
+	}
+}
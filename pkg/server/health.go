@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// checkPostgres connects to the database and pings it
+func checkPostgres(ctx context.Context, dsn string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	db, err := sqlx.ConnectContext(ctx, "postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.PingContext(ctx)
+}
+
+// checkRedis connects to redis, authenticates if a password is set, and sends a PING
+func checkRedis(ctx context.Context, cfg *config.Redis, host string, port int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	r := bufio.NewReader(conn)
+
+	if cfg.Password != "" {
+		args := []string{"AUTH", cfg.Password}
+		if cfg.User != "" {
+			args = []string{"AUTH", cfg.User, cfg.Password}
+		}
+
+		if err := redisCommand(conn, r, "+OK", args...); err != nil {
+			return err
+		}
+	}
+
+	return redisCommand(conn, r, "+PONG", "PING")
+}
+
+// redisCommand writes a command in the redis protocol, and checks the reply matches what we expect
+func redisCommand(conn net.Conn, r *bufio.Reader, expect string, args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return err
+	}
+
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	reply = strings.TrimSpace(reply)
+	if reply != expect {
+		return fmt.Errorf("unexpected reply from redis %s: %q", args[0], reply)
+	}
+
+	return nil
+}
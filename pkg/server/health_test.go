@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// fakeRedis answers AUTH and PING like redis, with the given password if it isn't empty
+func fakeRedis(t *testing.T, password string) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				authed := password == ""
+
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+
+					switch {
+					case args[0] == "AUTH" && password == "":
+						_, _ = io.WriteString(conn, "-ERR AUTH <password> called without any password configured for the default user\r\n")
+					case args[0] == "AUTH" && args[len(args)-1] == password:
+						authed = true
+						_, _ = io.WriteString(conn, "+OK\r\n")
+					case args[0] == "AUTH":
+						_, _ = io.WriteString(conn, "-WRONGPASS invalid username-password pair\r\n")
+					case args[0] == "PING" && authed:
+						_, _ = io.WriteString(conn, "+PONG\r\n")
+					default:
+						_, _ = io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+
+	return args, nil
+}
+
+func TestCheckRedis(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		password string
+		cfg      *config.Redis
+		port     int
+		wantErr  bool
+	}{
+		{name: "no password", cfg: &config.Redis{}},
+		{name: "password", password: "secret", cfg: &config.Redis{Password: "secret"}},
+		{name: "user and password", password: "secret", cfg: &config.Redis{User: "nodeisp", Password: "secret"}},
+		{name: "wrong password", password: "secret", cfg: &config.Redis{Password: "wrong"}, wantErr: true},
+		{name: "password required", password: "secret", cfg: &config.Redis{}, wantErr: true},
+		{name: "password not configured", cfg: &config.Redis{Password: "secret"}, wantErr: true},
+		{name: "nothing listening", cfg: &config.Redis{}, port: closedPort, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tt.port
+			if port == 0 {
+				port = fakeRedis(t, tt.password)
+			}
+
+			err := checkRedis(context.Background(), tt.cfg, "127.0.0.1", port)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRedis() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
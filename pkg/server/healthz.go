@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/licence"
+	"github.com/node-isp/node-isp/pkg/server/proxy"
+)
+
+// healthCacheFor is how long a health report is reused, so frequent checks don't each connect to the databases
+const healthCacheFor = 5 * time.Second
+
+// healthTimeout is how long the checks have to finish, as a whole
+const healthTimeout = 10 * time.Second
+
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Status string `json:"status"`
+}
+
+// healthReport is served as JSON. It only says what is wrong, not why, as the endpoints are public.
+type healthReport struct {
+	Live      bool          `json:"live"`
+	Ready     bool          `json:"ready"`
+	Replacing bool          `json:"replacing"`
+	Checks    []healthCheck `json:"checks"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// healthHandler serves /healthz, which fails when the app is down, and /readyz, which also fails when anything
+// the app needs is down or the app is being replaced, so a load balancer can drain the host during updates
+type healthHandler struct {
+	s       *Server
+	proxy   *proxy.Proxy
+	licence *licence.Licence
+	log     *log.Entry
+
+	// run replaces runChecks in tests
+	run func(context.Context) *healthReport
+
+	// mu guards the latest report, and the check in progress, which is closed when it finishes
+	mu       sync.Mutex
+	report   *healthReport
+	checking chan struct{}
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.check(r.Context())
+
+	ok := report.Live
+	if r.URL.Path == "/readyz" {
+		ok = report.Ready
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if r.Method != http.MethodHead {
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+// check returns the latest report, checking again if it is out of date. The checks run on their own context,
+// so a client that goes away doesn't fail them for everyone else, and requests that arrive meanwhile wait for
+// the same checks rather than starting their own.
+func (h *healthHandler) check(ctx context.Context) *healthReport {
+	h.mu.Lock()
+
+	if h.report != nil && time.Since(h.report.CheckedAt) < healthCacheFor {
+		defer h.mu.Unlock()
+		return h.report
+	}
+
+	done := h.checking
+	if done == nil {
+		done = make(chan struct{})
+		h.checking = done
+
+		run := h.run
+		if run == nil {
+			run = h.runChecks
+		}
+
+		go func(ctx context.Context) {
+			ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+			defer cancel()
+
+			report := run(ctx)
+
+			h.mu.Lock()
+			h.report, h.checking = report, nil
+			h.mu.Unlock()
+
+			close(done)
+		}(context.WithoutCancel(ctx))
+	}
+
+	h.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// The client gave up before the first checks finished, it won't see this
+	if h.report == nil {
+		return &healthReport{CheckedAt: time.Now()}
+	}
+
+	return h.report
+}
+
+// runChecks checks the containers, the databases and the licence
+func (h *healthHandler) runChecks(ctx context.Context) *healthReport {
+	report := &healthReport{Ready: true, Replacing: h.proxy.MaintenanceHeld(), CheckedAt: time.Now()}
+
+	add := func(name string, ok bool, status string) {
+		report.Checks = append(report.Checks, healthCheck{Name: name, OK: ok, Status: status})
+		report.Ready = report.Ready && ok
+	}
+
+	states, err := h.s.mgr.States(ctx)
+	if err != nil {
+		h.log.WithError(err).Warn("failed to inspect containers for health check")
+		add("containers", false, "unknown")
+	}
+
+	for _, st := range states {
+		status := st.State
+		if st.Health != "" && st.State == "running" {
+			status = st.Health
+		}
+
+		ok := st.State == "running" && (st.Health == "" || st.Health == "healthy")
+		add(st.Service, ok, status)
+
+		if st.Service == "app" {
+			report.Live = ok
+		}
+	}
+
+	reachable := func(name string, err error) {
+		if err != nil {
+			h.log.WithError(err).WithField("service", name).Warn("health check failed")
+			add(name, false, "unreachable")
+			return
+		}
+
+		add(name, true, "reachable")
+	}
+
+	// Postgres is reachable from the host whether it is managed or external, redis on its local port when managed
+	reachable("database", checkPostgres(ctx, h.s.dsn))
+	switch cfg := h.s.Config.Redis; {
+	case cfg.External():
+		reachable("redis", checkRedis(ctx, cfg, cfg.Host, cfg.Port))
+	case h.s.redisPort != 0:
+		// The container is listed already, so it is marked down if redis in it doesn't answer. The managed redis
+		// has no password, like the app connects to it.
+		if err := checkRedis(ctx, &config.Redis{}, "127.0.0.1", h.s.redisPort); err != nil {
+			h.log.WithError(err).WithField("service", "redis").Warn("health check failed")
+
+			for i, c := range report.Checks {
+				if c.Name == "redis" && c.OK {
+					report.Checks[i].OK, report.Checks[i].Status = false, "unreachable"
+					report.Ready = false
+				}
+			}
+		}
+	}
+
+	switch {
+	case h.licence == nil:
+		add("licence", false, "unvalidated")
+	case !h.licence.Status().Valid:
+		add("licence", false, "invalid")
+	default:
+		add("licence", true, "valid")
+	}
+
+	if report.Replacing {
+		report.Ready = false
+	}
+
+	return report
+}
+
+// serveHealth serves the health endpoints on a separate address until the context is done
+func serveHealth(ctx context.Context, addr string, h http.Handler, log *log.Entry) error {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", h)
+	mux.Handle("/readyz", h)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	log.WithField("address", addr).Info("serving health checks")
+
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
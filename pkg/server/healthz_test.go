@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+// blockingChecks returns checks that wait until release is closed, and counts how often they ran
+func blockingChecks(release <-chan struct{}, runs *atomic.Int32, cancelled *atomic.Bool) func(context.Context) *healthReport {
+	return func(ctx context.Context) *healthReport {
+		runs.Add(1)
+
+		<-release
+		if ctx.Err() != nil {
+			cancelled.Store(true)
+		}
+
+		return &healthReport{Live: true, Ready: true, CheckedAt: time.Now()}
+	}
+}
+
+func TestHealthCheckClientGoesAway(t *testing.T) {
+	release := make(chan struct{})
+	var runs atomic.Int32
+	var cancelled atomic.Bool
+
+	h := &healthHandler{run: blockingChecks(release, &runs, &cancelled)}
+
+	// The client disconnects while the checks are running
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	if report := h.check(ctx); report.Live || report.Ready {
+		t.Errorf("report = %+v before any checks finished", report)
+	}
+
+	// The lock isn't held while the checks run, so other clients aren't stuck behind them
+	expired, cancelExpired := context.WithCancel(context.Background())
+	cancelExpired()
+
+	returned := make(chan struct{})
+	go func() {
+		h.check(expired)
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("check blocked while another was running")
+	}
+
+	close(release)
+
+	report := h.check(context.Background())
+	if !report.Live || !report.Ready {
+		t.Errorf("report = %+v, want the one from the checks", report)
+	}
+
+	if cancelled.Load() {
+		t.Error("checks were cancelled when the client went away")
+	}
+	if got := runs.Load(); got != 1 {
+		t.Errorf("checks ran %d times, want once", got)
+	}
+}
+
+func TestHealthCheckShared(t *testing.T) {
+	release := make(chan struct{})
+	var runs atomic.Int32
+	var cancelled atomic.Bool
+
+	h := &healthHandler{run: blockingChecks(release, &runs, &cancelled)}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if report := h.check(context.Background()); !report.Ready {
+				t.Errorf("report = %+v", report)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Requests while the checks are running wait for them, and later ones use the cached report
+	h.check(context.Background())
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("checks ran %d times, want once", got)
+	}
+}
+
+func TestHealthCheckExpires(t *testing.T) {
+	var runs atomic.Int32
+
+	h := &healthHandler{run: func(context.Context) *healthReport {
+		runs.Add(1)
+		return &healthReport{CheckedAt: time.Now()}
+	}}
+
+	h.report = &healthReport{Live: true, CheckedAt: time.Now().Add(-healthCacheFor)}
+
+	if report := h.check(context.Background()); report.Live {
+		t.Error("out of date report was used")
+	}
+	if got := runs.Load(); got != 1 {
+		t.Errorf("checks ran %d times, want once", got)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		report healthReport
+		want   int
+	}{
+		{name: "live", path: "/healthz", report: healthReport{Live: true}, want: http.StatusOK},
+		{name: "live but not ready", path: "/healthz", report: healthReport{Live: true, Replacing: true}, want: http.StatusOK},
+		{name: "down", path: "/healthz", want: http.StatusServiceUnavailable},
+		{name: "ready", path: "/readyz", report: healthReport{Live: true, Ready: true}, want: http.StatusOK},
+		{name: "not ready", path: "/readyz", report: healthReport{Live: true}, want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &healthHandler{run: func(context.Context) *healthReport {
+				report := tt.report
+				report.CheckedAt = time.Now()
+				return &report
+			}}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+		})
+	}
+}
+
+func TestReadyWhileReplacing(t *testing.T) {
+	s, docker := newDockerServer(t)
+	s.dsn = "postgres://nodeisp@127.0.0.1:1/nodeisp?sslmode=disable"
+
+	logger := &log.Logger{Handler: discard.Default}
+	h := &healthHandler{s: s, proxy: s.proxy.Load(), log: logger.WithField("component", "health")}
+
+	ready := func() (int, healthReport) {
+		t.Helper()
+
+		// Each request checks again, rather than using the report from the one before
+		h.mu.Lock()
+		h.report = nil
+		h.mu.Unlock()
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var report healthReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Code, report
+	}
+
+	if _, report := ready(); report.Replacing {
+		t.Fatal("replacing before the update started")
+	}
+
+	// The update stops while pulling the new app image, after the maintenance page is up
+	pulling, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	docker.Pull = func(string) {
+		once.Do(func() {
+			close(pulling)
+			<-release
+		})
+	}
+
+	updated := make(chan error, 1)
+	go func() { updated <- s.updateApp(context.Background(), "v0.12.0") }()
+
+	select {
+	case <-pulling:
+	case err := <-updated:
+		t.Fatalf("update finished without pulling: %v", err)
+	}
+
+	code, report := ready()
+	if code != http.StatusServiceUnavailable || !report.Replacing || report.Ready {
+		t.Errorf("during the update: status = %d, report = %+v", code, report)
+	}
+
+	close(release)
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, report := ready(); report.Replacing {
+		t.Error("replacing after the update finished")
+	}
+}
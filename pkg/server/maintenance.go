@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/node-isp/node-isp/pkg/server/proxy"
+)
+
+// maintenanceFile keeps maintenance mode on across restarts of the daemon
+const maintenanceFile = "maintenance.json"
+
+// loadMaintenance turns maintenance mode back on if it was on when the daemon stopped, from when it was turned on
+func loadMaintenance(dataDir string, p *proxy.Proxy) error {
+	b, err := os.ReadFile(filepath.Join(dataDir, maintenanceFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := &proxy.MaintenanceState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return err
+	}
+
+	p.RestoreMaintenance(*state)
+
+	return nil
+}
+
+// saveMaintenance records the maintenance state, removing the file when it is off
+func saveMaintenance(dataDir string, state proxy.MaintenanceState) error {
+	path := filepath.Join(dataDir, maintenanceFile)
+
+	if !state.Enabled {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0644)
+}
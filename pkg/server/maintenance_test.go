@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/creasty/defaults"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/proxy"
+)
+
+// newTestProxy returns a proxy to nowhere, for the maintenance page
+func newTestProxy(t *testing.T) *proxy.Proxy {
+	t.Helper()
+
+	cfg := &config.Proxy{}
+	if err := defaults.Set(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := proxy.New(&url.URL{Scheme: "http", Host: "127.0.0.1:1"}, cfg, nil, nil, (&log.Logger{Handler: discard.Default}).WithField("component", "proxy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestMaintenanceRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	// Maintenance mode was turned on a day before the restart
+	since := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	state := proxy.MaintenanceState{Enabled: true, Message: "Moving to a new server", Since: since}
+
+	if err := saveMaintenance(dir, state); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProxy(t)
+	if err := loadMaintenance(dir, p); err != nil {
+		t.Fatal(err)
+	}
+
+	got := p.Maintenance()
+	if !got.Enabled || got.Message != state.Message || !got.Since.Equal(since) {
+		t.Errorf("maintenance after a restart = %+v, want %+v", got, state)
+	}
+
+	// Turning it off removes the file, so it stays off after the next restart
+	if err := saveMaintenance(dir, p.SetMaintenance(false, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	p = newTestProxy(t)
+	if err := loadMaintenance(dir, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Maintenance().Enabled {
+		t.Error("maintenance is on after it was turned off and the daemon restarted")
+	}
+}
+
+func TestMaintenanceRestartWithoutSince(t *testing.T) {
+	dir := t.TempDir()
+
+	// Files written before the start time was kept have only the message
+	if err := os.WriteFile(filepath.Join(dir, maintenanceFile), []byte(`{"Enabled": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProxy(t)
+	if err := loadMaintenance(dir, p); err != nil {
+		t.Fatal(err)
+	}
+
+	got := p.Maintenance()
+	if !got.Enabled || got.Message == "" || time.Since(got.Since) > time.Minute {
+		t.Errorf("maintenance = %+v, want it on from now with the default message", got)
+	}
+}
@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/node-isp/node-isp/pkg/licence"
+	"github.com/node-isp/node-isp/pkg/server/resources"
+	"github.com/node-isp/node-isp/pkg/server/service"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+var (
+	containerState = prometheus.NewDesc(
+		"nodeisp_container_state",
+		"The state of each service's container, 1 for the current state.",
+		[]string{"service", "state"}, nil,
+	)
+	containerRestarts = prometheus.NewDesc(
+		"nodeisp_container_restarts",
+		"Times docker has restarted each service's container.",
+		[]string{"service"}, nil,
+	)
+	containerHealthy = prometheus.NewDesc(
+		"nodeisp_container_healthy",
+		"Whether each service's container passes its healthcheck, for containers that have one.",
+		[]string{"service"}, nil,
+	)
+	licenceValid = prometheus.NewDesc(
+		"nodeisp_licence_valid",
+		"Whether the licence server reports the licence as valid.",
+		nil, nil,
+	)
+	licenceExpiry = prometheus.NewDesc(
+		"nodeisp_licence_expiry_timestamp_seconds",
+		"When the licence expires, missing for a licence that doesn't expire.",
+		nil, nil,
+	)
+	licenceChecked = prometheus.NewDesc(
+		"nodeisp_licence_last_check_timestamp_seconds",
+		"When the licence server last confirmed the licence.",
+		nil, nil,
+	)
+	certificateDaysLeft = prometheus.NewDesc(
+		"nodeisp_certificate_days_left",
+		"Days until each certificate being served expires.",
+		[]string{"domain", "issuer", "serial"}, nil,
+	)
+	diskUsed = prometheus.NewDesc(
+		"nodeisp_disk_used_bytes",
+		"Bytes used on the filesystem holding each storage path.",
+		[]string{"name", "path"}, nil,
+	)
+	diskFree = prometheus.NewDesc(
+		"nodeisp_disk_free_bytes",
+		"Bytes free on the filesystem holding each storage path.",
+		[]string{"name", "path"}, nil,
+	)
+	databaseSize = prometheus.NewDesc(
+		"nodeisp_database_size_bytes",
+		"Size of the app's database.",
+		nil, nil,
+	)
+	logSize = prometheus.NewDesc(
+		"nodeisp_log_size_bytes",
+		"Total size of the log files.",
+		nil, nil,
+	)
+)
+
+// containerStates are always reported, so a state going away sets it to 0 rather than the series disappearing
+var containerStates = []string{"created", "running", "restarting", "paused", "exited", "dead", "missing"}
+
+// Sources are read each time the metrics are scraped. Any of them may be nil.
+type Sources struct {
+	Manager   *service.Manager
+	Licence   *licence.Licence
+	WebServer *webserver.WebServer
+	Resources *resources.Monitor
+}
+
+type collector struct {
+	log *log.Entry
+
+	// The sources are read through these, they are nil for sources that aren't available
+	states       func(context.Context) ([]service.State, error)
+	licence      func() licence.Status
+	certificates func() []webserver.Certificate
+	usage        func() resources.Usage
+
+	now func() time.Time
+}
+
+func newCollector(src Sources, log *log.Entry) *collector {
+	c := &collector{log: log, now: time.Now}
+
+	if src.Manager != nil {
+		c.states = src.Manager.States
+	}
+	if src.Licence != nil {
+		c.licence = src.Licence.Status
+	}
+	if src.WebServer != nil {
+		c.certificates = src.WebServer.Certificates
+	}
+	if src.Resources != nil {
+		c.usage = src.Resources.Usage
+	}
+
+	return c
+}
+
+// Register adds the container, licence, certificate and resource metrics, read from the sources on each scrape
+func Register(src Sources, log *log.Entry) {
+	Registry.MustRegister(newCollector(src, log))
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	if c.states != nil {
+		c.collectContainers(ch)
+	}
+
+	if c.licence != nil {
+		st := c.licence()
+
+		ch <- prometheus.MustNewConstMetric(licenceValid, prometheus.GaugeValue, boolValue(st.Valid))
+		if !st.ExpiresAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(licenceExpiry, prometheus.GaugeValue, float64(st.ExpiresAt.Unix()))
+		}
+		if !st.CheckedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(licenceChecked, prometheus.GaugeValue, float64(st.CheckedAt.Unix()))
+		}
+	} else {
+		ch <- prometheus.MustNewConstMetric(licenceValid, prometheus.GaugeValue, 0)
+	}
+
+	if c.certificates != nil {
+		for _, cert := range c.certificates() {
+			if len(cert.Names) == 0 || cert.NotAfter.IsZero() {
+				continue
+			}
+
+			days := cert.NotAfter.Sub(c.now()).Hours() / 24
+			ch <- prometheus.MustNewConstMetric(certificateDaysLeft, prometheus.GaugeValue, days, cert.Names[0], cert.Issuer, cert.Serial)
+		}
+	}
+
+	if c.usage != nil {
+		c.collectResources(ch)
+	}
+}
+
+// collectResources reports the monitor's last snapshot, rather than reading the disks and database on each scrape
+func (c *collector) collectResources(ch chan<- prometheus.Metric) {
+	usage := c.usage()
+	if usage.CheckedAt.IsZero() {
+		return
+	}
+
+	for _, d := range usage.Disks {
+		ch <- prometheus.MustNewConstMetric(diskUsed, prometheus.GaugeValue, float64(d.Used), d.Name, d.Path)
+		ch <- prometheus.MustNewConstMetric(diskFree, prometheus.GaugeValue, float64(d.Free), d.Name, d.Path)
+	}
+
+	if usage.DatabaseSize > 0 {
+		ch <- prometheus.MustNewConstMetric(databaseSize, prometheus.GaugeValue, float64(usage.DatabaseSize))
+	}
+
+	ch <- prometheus.MustNewConstMetric(logSize, prometheus.GaugeValue, float64(usage.LogSize))
+}
+
+func (c *collector) collectContainers(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	states, err := c.states(ctx)
+	if err != nil {
+		c.log.WithError(err).Error("failed to inspect containers for metrics")
+		return
+	}
+
+	for _, st := range states {
+		for _, s := range containerStates {
+			ch <- prometheus.MustNewConstMetric(containerState, prometheus.GaugeValue, boolValue(s == st.State), st.Service, s)
+		}
+
+		ch <- prometheus.MustNewConstMetric(containerRestarts, prometheus.GaugeValue, float64(st.Restarts), st.Service)
+
+		if st.Health != "" {
+			ch <- prometheus.MustNewConstMetric(containerHealthy, prometheus.GaugeValue, boolValue(st.Health == "healthy"), st.Service)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
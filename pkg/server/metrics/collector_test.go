@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/node-isp/node-isp/pkg/licence"
+	"github.com/node-isp/node-isp/pkg/server/resources"
+	"github.com/node-isp/node-isp/pkg/server/service"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+func TestCollector(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	logger := (&log.Logger{Handler: discard.Default}).WithField("component", "metrics")
+
+	c := &collector{
+		log: logger,
+		now: func() time.Time { return now },
+		states: func(context.Context) ([]service.State, error) {
+			return []service.State{
+				{Service: "app", State: "running", Health: "healthy", Restarts: 2},
+				{Service: "horizon", State: "exited", Restarts: 5},
+				{Service: "postgres", State: "missing"},
+			}, nil
+		},
+		licence: func() licence.Status {
+			return licence.Status{Valid: true, ExpiresAt: now.Add(30 * 24 * time.Hour), CheckedAt: now.Add(-time.Hour)}
+		},
+		certificates: func() []webserver.Certificate {
+			return []webserver.Certificate{
+				{Names: []string{"isp.example.com", "www.isp.example.com"}, Issuer: "R11", Serial: "4A3F", NotAfter: now.Add(36 * time.Hour)},
+				{Names: []string{"old.example.com"}, Issuer: "R10", Serial: "1B", NotAfter: now.Add(-12 * time.Hour)},
+
+				// A domain whose first certificate couldn't be obtained has nothing to report
+				{Names: []string{"new.example.com"}, Managed: true},
+			}
+		},
+		usage: func() resources.Usage {
+			return resources.Usage{
+				Disks:     []resources.Disk{{Name: "data", Path: "/var/lib/nodeisp", Used: 750, Free: 250}},
+				LogSize:   4096,
+				CheckedAt: now,
+			}
+		},
+	}
+
+	expected := `
+# HELP nodeisp_certificate_days_left Days until each certificate being served expires.
+# TYPE nodeisp_certificate_days_left gauge
+nodeisp_certificate_days_left{domain="isp.example.com",issuer="R11",serial="4A3F"} 1.5
+nodeisp_certificate_days_left{domain="old.example.com",issuer="R10",serial="1B"} -0.5
+# HELP nodeisp_container_healthy Whether each service's container passes its healthcheck, for containers that have one.
+# TYPE nodeisp_container_healthy gauge
+nodeisp_container_healthy{service="app"} 1
+# HELP nodeisp_container_restarts Times docker has restarted each service's container.
+# TYPE nodeisp_container_restarts gauge
+nodeisp_container_restarts{service="app"} 2
+nodeisp_container_restarts{service="horizon"} 5
+nodeisp_container_restarts{service="postgres"} 0
+# HELP nodeisp_container_state The state of each service's container, 1 for the current state.
+# TYPE nodeisp_container_state gauge
+nodeisp_container_state{service="app",state="created"} 0
+nodeisp_container_state{service="app",state="dead"} 0
+nodeisp_container_state{service="app",state="exited"} 0
+nodeisp_container_state{service="app",state="missing"} 0
+nodeisp_container_state{service="app",state="paused"} 0
+nodeisp_container_state{service="app",state="restarting"} 0
+nodeisp_container_state{service="app",state="running"} 1
+nodeisp_container_state{service="horizon",state="created"} 0
+nodeisp_container_state{service="horizon",state="dead"} 0
+nodeisp_container_state{service="horizon",state="exited"} 1
+nodeisp_container_state{service="horizon",state="missing"} 0
+nodeisp_container_state{service="horizon",state="paused"} 0
+nodeisp_container_state{service="horizon",state="restarting"} 0
+nodeisp_container_state{service="horizon",state="running"} 0
+nodeisp_container_state{service="postgres",state="created"} 0
+nodeisp_container_state{service="postgres",state="dead"} 0
+nodeisp_container_state{service="postgres",state="exited"} 0
+nodeisp_container_state{service="postgres",state="missing"} 1
+nodeisp_container_state{service="postgres",state="paused"} 0
+nodeisp_container_state{service="postgres",state="restarting"} 0
+nodeisp_container_state{service="postgres",state="running"} 0
+# HELP nodeisp_disk_free_bytes Bytes free on the filesystem holding each storage path.
+# TYPE nodeisp_disk_free_bytes gauge
+nodeisp_disk_free_bytes{name="data",path="/var/lib/nodeisp"} 250
+# HELP nodeisp_disk_used_bytes Bytes used on the filesystem holding each storage path.
+# TYPE nodeisp_disk_used_bytes gauge
+nodeisp_disk_used_bytes{name="data",path="/var/lib/nodeisp"} 750
+# HELP nodeisp_licence_expiry_timestamp_seconds When the licence expires, missing for a licence that doesn't expire.
+# TYPE nodeisp_licence_expiry_timestamp_seconds gauge
+nodeisp_licence_expiry_timestamp_seconds 1.7949168e+09
+# HELP nodeisp_licence_last_check_timestamp_seconds When the licence server last confirmed the licence.
+# TYPE nodeisp_licence_last_check_timestamp_seconds gauge
+nodeisp_licence_last_check_timestamp_seconds 1.7923212e+09
+# HELP nodeisp_licence_valid Whether the licence server reports the licence as valid.
+# TYPE nodeisp_licence_valid gauge
+nodeisp_licence_valid 1
+# HELP nodeisp_log_size_bytes Total size of the log files.
+# TYPE nodeisp_log_size_bytes gauge
+nodeisp_log_size_bytes 4096
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorUnavailable(t *testing.T) {
+	logger := (&log.Logger{Handler: discard.Default}).WithField("component", "metrics")
+
+	// Without a licence it is reported invalid, and containers that can't be inspected are left out
+	c := &collector{
+		log: logger,
+		now: time.Now,
+		states: func(context.Context) ([]service.State, error) {
+			return nil, errors.New("docker is down")
+		},
+		usage: func() resources.Usage { return resources.Usage{} },
+	}
+
+	expected := `
+# HELP nodeisp_licence_valid Whether the licence server reports the licence as valid.
+# TYPE nodeisp_licence_valid gauge
+nodeisp_licence_valid 0
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(newCollector(Sources{}, logger)); n != 1 {
+		t.Errorf("collected %d metrics without any sources, want only the licence", n)
+	}
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the NodeISP metrics, along with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	ProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nodeisp",
+		Subsystem: "proxy",
+		Name:      "requests_total",
+		Help:      "Requests handled by the proxy, by route group, method and status code.",
+	}, []string{"group", "method", "code"})
+
+	ProxyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nodeisp",
+		Subsystem: "proxy",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to respond to requests, by route group.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"group"})
+
+	CronRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nodeisp",
+		Subsystem: "cron",
+		Name:      "runs_total",
+		Help:      "Runs of the app's scheduler, by result.",
+	}, []string{"result"})
+
+	CronDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nodeisp",
+		Subsystem: "cron",
+		Name:      "duration_seconds",
+		Help:      "Time taken by each run of the app's scheduler.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+	})
+
+	UpdateAvailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nodeisp",
+		Name:      "update_available",
+		Help:      "Whether a newer version of a component is available.",
+	}, []string{"component"})
+
+	StateWritten = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nodeisp",
+		Name:      "state_last_write_timestamp_seconds",
+		Help:      "When the state file was last written.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ProxyRequests,
+		ProxyDuration,
+		CronRuns,
+		CronDuration,
+		UpdateAvailable,
+		StateWritten,
+	)
+}
+
+// methods are counted by name, anything else is counted as other so clients can't add label values
+var methods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// ObserveRequest records a request handled by the proxy
+func ObserveRequest(group, method string, status int, d time.Duration) {
+	if !methods[method] {
+		method = "other"
+	}
+
+	ProxyRequests.WithLabelValues(group, method, strconv.Itoa(status)).Inc()
+	ProxyDuration.WithLabelValues(group).Observe(d.Seconds())
+}
+
+// ObserveCron records a run of the app's scheduler
+func ObserveCron(d time.Duration, failed bool) {
+	result := "success"
+	if failed {
+		result = "failure"
+	}
+
+	CronRuns.WithLabelValues(result).Inc()
+	CronDuration.Observe(d.Seconds())
+}
+
+// Serve serves /metrics on addr until the context is done
+func Serve(ctx context.Context, addr string, log *log.Entry) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	log.WithField("address", addr).Info("serving metrics")
+
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/service"
+)
+
+// applyOverrides merges the overrides from the services section of the config into the service spec.
+// As the image, env and mounts are all part of the service hash, changing an override recreates the container.
+func applyOverrides(svc *service.Service, o *config.ServiceOverride) error {
+	// A service from the state may have the image from an override that has since been removed
+	if svc.BaseImage != "" {
+		svc.Image = svc.BaseImage
+	}
+	svc.BaseImage = svc.Image
+
+	if o == nil {
+		return nil
+	}
+
+	if o.Image != "" {
+		svc.Image = o.Image
+	}
+
+	env, err := o.Environment()
+	if err != nil {
+		return err
+	}
+
+	svc.Env = mergeEnv(svc.Env, env)
+
+	// Copy the mounts, horizon shares the slice with the app server
+	mounts := make([]mount.Mount, 0, len(svc.Mounts)+len(o.Mounts))
+	mounts = append(mounts, svc.Mounts...)
+
+	for _, m := range o.Mounts {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   absolutePath(m.Source),
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	svc.Mounts = mounts
+
+	return nil
+}
+
+// mergeEnv returns a new environment with the extra variables applied over the base variables.
+// Variables that exist in both are replaced in place, new variables are appended.
+func mergeEnv(base []string, extra []string) []string {
+	env := make([]string, len(base), len(base)+len(extra))
+	copy(env, base)
+
+	index := map[string]int{}
+	for i, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		index[k] = i
+	}
+
+	for _, e := range extra {
+		k, _, _ := strings.Cut(e, "=")
+		if i, ok := index[k]; ok {
+			env[i] = e
+			continue
+		}
+
+		index[k] = len(env)
+		env = append(env, e)
+	}
+
+	return env
+}
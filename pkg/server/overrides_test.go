@@ -0,0 +1,145 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/service"
+)
+
+func TestMergeEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  []string
+		extra []string
+		want  []string
+	}{
+		{
+			name:  "append new",
+			base:  []string{"A=1"},
+			extra: []string{"B=2"},
+			want:  []string{"A=1", "B=2"},
+		},
+		{
+			name:  "replace in place",
+			base:  []string{"A=1", "B=2", "C=3"},
+			extra: []string{"B=two"},
+			want:  []string{"A=1", "B=two", "C=3"},
+		},
+		{
+			name:  "value containing equals",
+			base:  []string{"DSN=a=b"},
+			extra: []string{"DSN=c=d"},
+			want:  []string{"DSN=c=d"},
+		},
+		{
+			name:  "duplicate extra keys, last wins",
+			base:  nil,
+			extra: []string{"A=1", "A=2"},
+			want:  []string{"A=2"},
+		},
+		{
+			name:  "no extra",
+			base:  []string{"A=1"},
+			extra: nil,
+			want:  []string{"A=1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := append([]string(nil), tt.base...)
+
+			got := mergeEnv(tt.base, tt.extra)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeEnv() = %v, want %v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(tt.base, base) {
+				t.Errorf("mergeEnv() modified the base to %v", tt.base)
+			}
+		})
+	}
+}
+
+func TestApplyOverridesIsStable(t *testing.T) {
+	o := &config.ServiceOverride{
+		Image:  "example/gotenberg:8",
+		Env:    map[string]string{"EXTRA": "1"},
+		Mounts: []config.Mount{{Source: "/srv/fonts", Target: "/fonts", ReadOnly: true}},
+	}
+
+	build := func() *service.Service {
+		return &service.Service{Name: "gotenberg", Image: "getlago/lago-gotenberg:7"}
+	}
+
+	first := build()
+	if err := applyOverrides(first, o); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart builds the service from the defaults again, so the overrides aren't applied twice
+	second := build()
+	if err := applyOverrides(second, o); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.GetHash() != second.GetHash() {
+		t.Errorf("hash changed between starts: %v != %v", first.Mounts, second.Mounts)
+	}
+
+	if want := []mount.Mount{{Type: mount.TypeBind, Source: "/srv/fonts", Target: "/fonts", ReadOnly: true}}; !reflect.DeepEqual(second.Mounts, want) {
+		t.Errorf("mounts = %v, want %v", second.Mounts, want)
+	}
+
+	if second.Image != "example/gotenberg:8" {
+		t.Errorf("image = %s, want the override", second.Image)
+	}
+}
+
+func TestApplyOverridesRemovedImage(t *testing.T) {
+	// The service as loaded from the state, after a start with an image override
+	svc := &service.Service{Name: "redis", Image: "redis:7"}
+	if err := applyOverrides(svc, &config.ServiceOverride{Image: "valkey/valkey:8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.Image != "valkey/valkey:8" {
+		t.Fatalf("image = %s, want the override", svc.Image)
+	}
+
+	// The override is removed from the config
+	if err := applyOverrides(svc, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.Image != "redis:7" {
+		t.Errorf("image = %s, want it back to redis:7", svc.Image)
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"ghcr.io/node-isp/node-isp:v0.11.8", "v0.11.8"},
+		{"registry:5000/img", "latest"},
+		{"registry:5000/img:1.2.3", "1.2.3"},
+		{"redis", "latest"},
+		{"example/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{"example/app:v1.0.0@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "v1.0.0"},
+		{"Not A Reference", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := imageVersion(tt.image); got != tt.want {
+				t.Errorf("imageVersion(%q) = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
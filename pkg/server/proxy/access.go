@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+// dummyHash is compared against for unknown users, so they take as long to reject as a wrong password
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("nodeisp"), bcrypt.DefaultCost)
+	return h
+})
+
+// authCacheTTL is how long a verified password is remembered, as bcrypt is too slow to run on every request
+const authCacheTTL = 5 * time.Minute
+
+// accessPolicy guards the paths starting with a prefix
+type accessPolicy struct {
+	path  string
+	allow *webserver.IPSet
+	users map[string][]byte
+	realm string
+	roots *x509.CertPool
+
+	mu       sync.Mutex
+	verified map[[32]byte]time.Time
+}
+
+func newAccessPolicies(cfgs []config.AccessPolicy) ([]*accessPolicy, error) {
+	var policies []*accessPolicy
+
+	for _, cfg := range cfgs {
+		p := &accessPolicy{
+			path:     cfg.Path,
+			realm:    cfg.Realm,
+			verified: map[[32]byte]time.Time{},
+		}
+
+		if p.realm == "" {
+			p.realm = "NodeISP"
+		}
+
+		if len(cfg.Allow) > 0 {
+			allow, err := webserver.NewIPSet(cfg.Allow)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allow list for %s: %w", cfg.Path, err)
+			}
+			p.allow = allow
+		}
+
+		if len(cfg.Users) > 0 {
+			p.users = map[string][]byte{}
+			for user, hash := range cfg.Users {
+				if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+					return nil, fmt.Errorf("invalid bcrypt hash for user %s on %s: %w", user, cfg.Path, err)
+				}
+				p.users[user] = []byte(hash)
+			}
+		}
+
+		if cfg.ClientCA != "" {
+			pem, err := os.ReadFile(cfg.ClientCA)
+			if err != nil {
+				return nil, err
+			}
+
+			p.roots = x509.NewCertPool()
+			if !p.roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCA)
+			}
+		}
+
+		policies = append(policies, p)
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool { return len(policies[i].path) > len(policies[j].path) })
+
+	return policies, nil
+}
+
+// allowed checks the request against the policy, and writes the response if it is denied
+func (p *accessPolicy) allowed(w http.ResponseWriter, r *http.Request, l *log.Entry) bool {
+	clientIP := webserver.ClientIP(r)
+	l = l.WithField("path", r.URL.Path).WithField("client", clientIP)
+
+	if p.allow != nil && !p.allow.Contains(net.ParseIP(clientIP)) {
+		l.Warn("access denied, address not allowed")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+
+	if p.roots != nil && !p.verifyClientCert(r) {
+		l.Warn("access denied, no valid client certificate")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+
+	if p.users != nil {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !p.checkPassword(user, pass) {
+			if ok {
+				l.WithField("user", user).Warn("access denied, invalid credentials")
+			}
+
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", p.realm))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return false
+		}
+
+		// The app has its own authentication, don't pass it the proxy credentials
+		r.Header.Del("Authorization")
+	}
+
+	return true
+}
+
+func (p *accessPolicy) verifyClientCert(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return err == nil
+}
+
+func (p *accessPolicy) checkPassword(user, pass string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + pass))
+
+	p.mu.Lock()
+	at, ok := p.verified[key]
+	p.mu.Unlock()
+
+	if ok && time.Since(at) < authCacheTTL {
+		return true
+	}
+
+	hash, known := p.users[user]
+	if !known {
+		hash = dummyHash()
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil || !known {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Keep the cache bounded, it is cheap to refill
+	if len(p.verified) > 1000 {
+		clear(p.verified)
+	}
+	p.verified[key] = time.Now()
+
+	return true
+}
+
+// matchPrefix matches a path against a prefix on segment boundaries, so /admin matches /admin/horizon but not
+// /administrator. Paths are compared case-insensitively, and with dot segments resolved as the app would, so a
+// policy can't be bypassed with /Admin or /public/../admin.
+func matchPrefix(urlPath, prefix string) bool {
+	cleaned := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	urlPath, prefix = strings.ToLower(cleaned), strings.ToLower(prefix)
+
+	if prefix == "" || prefix == "/" || urlPath == prefix || strings.HasSuffix(prefix, "/") && strings.HasPrefix(urlPath, prefix) {
+		return true
+	}
+
+	return strings.HasPrefix(urlPath, prefix+"/")
+}
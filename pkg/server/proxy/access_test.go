@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/admin", "/admin", true},
+		{"/admin/horizon", "/admin", true},
+		{"/administrator", "/admin", false},
+		{"/public/admin", "/admin", false},
+		{"/", "/admin", false},
+
+		// Trailing slashes on the prefix or path
+		{"/admin/", "/admin", true},
+		{"/admin", "/admin/", false},
+		{"/admin/", "/admin/", true},
+		{"/admin/horizon", "/admin/", true},
+		{"/administrator", "/admin/", false},
+
+		// Case
+		{"/Admin/horizon", "/admin", true},
+		{"/admin", "/ADMIN", true},
+
+		// The root and empty prefixes match everything
+		{"/anything", "/", true},
+		{"/anything", "", true},
+
+		// Dot segments and doubled slashes are resolved before matching
+		{"/public/../admin", "/admin", true},
+		{"/public/../admin/horizon", "/admin", true},
+		{"/admin/../public", "/admin", false},
+		{"/./admin", "/admin", true},
+		{"//admin", "/admin", true},
+		{"/admin/..", "/admin", false},
+		{"/admin/../admin/", "/admin/", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("matchPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPrefixEncoded(t *testing.T) {
+	// The policies are checked against the decoded path, so encoded slashes and dots are matched as the app sees them
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/admin%2Fhorizon", true},
+		{"/public%2F..%2Fadmin", true},
+		{"/public/%2e%2e/admin", true},
+		{"/%61dmin", true},
+		{"/admin%2e", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+
+		if got := matchPrefix(r.URL.Path, "/admin"); got != tt.want {
+			t.Errorf("matchPrefix(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NYTimes/logrotate"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+// accessLogFields are the fields of a json entry, in the order they are documented
+var accessLogFields = []string{
+	"time", "client", "host", "method", "path", "proto", "status", "bytes", "duration", "upstream",
+	"request_id", "user", "referer", "user_agent",
+}
+
+// AccessLog writes an entry for each request in the combined or json format. The file is reopened on SIGHUP,
+// so it can be rotated with logrotate like the other logs.
+type AccessLog struct {
+	w      io.Writer
+	json   bool
+	fields []string
+	sample float64
+}
+
+// NewAccessLog opens the access log in the logs directory, it returns nil if access logging is off
+func NewAccessLog(cfg *config.AccessLog, logsDir string) (*AccessLog, error) {
+	a := &AccessLog{sample: cfg.SampleRate, fields: accessLogFields}
+
+	switch strings.ToLower(cfg.Format) {
+	case "off":
+		return nil, nil
+	case "json":
+		a.json = true
+	case "combined", "":
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", cfg.Format)
+	}
+
+	if len(cfg.Fields) > 0 {
+		known := map[string]bool{}
+		for _, f := range accessLogFields {
+			known[f] = true
+		}
+
+		a.fields = nil
+		for _, f := range cfg.Fields {
+			if !known[f] {
+				return nil, fmt.Errorf("unknown access log field %q", f)
+			}
+			a.fields = append(a.fields, f)
+		}
+	}
+
+	path := cfg.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(logsDir, path)
+	}
+
+	w, err := logrotate.NewFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a.w = w
+
+	return a, nil
+}
+
+type requestInfoKey struct{}
+
+// requestInfo is filled in while a request is handled, for the access log
+type requestInfo struct {
+	id       string
+	start    time.Time
+	upstream time.Duration
+	user     string
+
+	// group is the route group the request is counted under in the metrics
+	group string
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+
+	return &requestInfo{}
+}
+
+// newRequestInfo starts tracking a request. The request ID is kept if a trusted proxy already assigned one, so
+// entries can be matched up with the load balancer's logs.
+func newRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{start: time.Now()}
+
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 && webserver.TrustedProxy(r) {
+		info.id = id
+	} else {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		info.id = hex.EncodeToString(b)
+	}
+
+	if user, _, ok := r.BasicAuth(); ok {
+		info.user = user
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// timedTransport records how long the app took to respond, for the access log
+type timedTransport struct {
+	http.RoundTripper
+}
+
+func (t *timedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(r)
+	getRequestInfo(r).upstream = time.Since(start)
+
+	return resp, err
+}
+
+// responseRecorder captures the status and size of a response. It unwraps for http.ResponseController, so
+// flushing and websocket upgrades still work through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+
+	return n, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (a *AccessLog) write(r *http.Request, rec *responseRecorder, info *requestInfo) {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if status < 400 && a.sample < 1 && mrand.Float64() >= a.sample {
+		return
+	}
+
+	if a.json {
+		a.writeJSON(r, status, rec.bytes, info)
+		return
+	}
+
+	user := info.user
+	if user == "" {
+		user = "-"
+	}
+
+	// Combined log format, with the host, request ID and timings appended
+	_, _ = fmt.Fprintf(a.w, "%s - %s [%s] \"%s %s %s\" %d %d \"%s\" \"%s\" %s %s %.3f %.3f\n",
+		webserver.ClientIP(r),
+		escape(user),
+		info.start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method,
+		escape(r.URL.Path),
+		r.Proto,
+		status,
+		rec.bytes,
+		escape(r.Referer()),
+		escape(r.UserAgent()),
+		escape(r.Host),
+		info.id,
+		time.Since(info.start).Seconds(),
+		info.upstream.Seconds(),
+	)
+}
+
+func (a *AccessLog) writeJSON(r *http.Request, status int, bytes int64, info *requestInfo) {
+	values := map[string]any{
+		"time":       info.start.Format(time.RFC3339Nano),
+		"client":     webserver.ClientIP(r),
+		"host":       r.Host,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"proto":      r.Proto,
+		"status":     status,
+		"bytes":      bytes,
+		"duration":   time.Since(info.start).Seconds(),
+		"upstream":   info.upstream.Seconds(),
+		"request_id": info.id,
+		"user":       info.user,
+		"referer":    r.Referer(),
+		"user_agent": r.UserAgent(),
+	}
+
+	entry := make(map[string]any, len(a.fields))
+	for _, f := range a.fields {
+		entry[f] = values[f]
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	_, _ = a.w.Write(append(b, '\n'))
+}
+
+// escape quotes and control characters, so a client can't forge entries in the combined format
+func escape(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	q := fmt.Sprintf("%q", s)
+	return q[1 : len(q)-1]
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// newTestAccessLog opens an access log in a temporary logs directory, and returns a function reading its lines
+func newTestAccessLog(t *testing.T, cfg *config.AccessLog) (*AccessLog, func() []string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	a, err := NewAccessLog(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, func() []string {
+		t.Helper()
+
+		b, err := os.ReadFile(filepath.Join(dir, cfg.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) == 0 {
+			return nil
+		}
+
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+}
+
+// logRequest writes an entry for a request that got the status and body size
+func logRequest(a *AccessLog, r *http.Request, status int, bytes int64, user string) {
+	info := &requestInfo{
+		id:       "0123456789abcdef",
+		start:    time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC),
+		upstream: 25 * time.Millisecond,
+		user:     user,
+	}
+
+	a.write(r, &responseRecorder{status: status, bytes: bytes}, info)
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		user    string
+		want    string
+	}{
+		{
+			name: "request",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "http://isp.example.com/customers?page=2", nil)
+				r.Header.Set("Referer", "https://isp.example.com/")
+				r.Header.Set("User-Agent", "Mozilla/5.0")
+				return r
+			},
+			user: "admin",
+			want: `192.0.2.1 - admin [18/Oct/2026:12:30:00 +0000] "GET /customers HTTP/1.1" 200 512 "https://isp.example.com/" "Mozilla/5.0" isp.example.com 0123456789abcdef`,
+		},
+		{
+			name:    "empty fields",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "http://isp.example.com/", nil) },
+			want:    `192.0.2.1 - - [18/Oct/2026:12:30:00 +0000] "GET / HTTP/1.1" 200 512 "-" "-" isp.example.com 0123456789abcdef`,
+		},
+		{
+			name: "quote in the user agent",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "http://isp.example.com/", nil)
+				r.Header.Set("User-Agent", `curl" 500 0 "-" "forged`)
+				return r
+			},
+			want: `192.0.2.1 - - [18/Oct/2026:12:30:00 +0000] "GET / HTTP/1.1" 200 512 "-" "curl\" 500 0 \"-\" \"forged" isp.example.com 0123456789abcdef`,
+		},
+		{
+			name: "newline in the user agent",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "http://isp.example.com/", nil)
+				r.Header.Set("User-Agent", "curl\n203.0.113.9 - - [18/Oct/2026:12:30:00 +0000] \"GET /admin HTTP/1.1\" 200")
+				return r
+			},
+			want: `192.0.2.1 - - [18/Oct/2026:12:30:00 +0000] "GET / HTTP/1.1" 200 512 "-" "curl\n203.0.113.9 - - [18/Oct/2026:12:30:00 +0000] \"GET /admin HTTP/1.1\" 200" isp.example.com 0123456789abcdef`,
+		},
+		{
+			name:    "quote in the user",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "http://isp.example.com/", nil) },
+			user:    `admin" -`,
+			want:    `192.0.2.1 - admin\" - [18/Oct/2026:12:30:00 +0000] "GET / HTTP/1.1" 200 512 "-" "-" isp.example.com 0123456789abcdef`,
+		},
+		{
+			name: "escaped path",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "http://isp.example.com/a%22b%0Ac", nil)
+			},
+			want: `192.0.2.1 - - [18/Oct/2026:12:30:00 +0000] "GET /a\"b\nc HTTP/1.1" 200 512 "-" "-" isp.example.com 0123456789abcdef`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, lines := newTestAccessLog(t, &config.AccessLog{Format: "combined", File: "access.log", SampleRate: 1})
+
+			logRequest(a, tt.request(), http.StatusOK, 512, tt.user)
+
+			got := lines()
+			if len(got) != 1 {
+				t.Fatalf("wrote %d lines, want 1: %q", len(got), got)
+			}
+
+			// The duration and upstream time follow
+			fields := strings.Fields(got[0])
+			if len(fields) < 2 || fields[len(fields)-1] != "0.025" {
+				t.Errorf("line = %q, want the upstream time last", got[0])
+			}
+			if line := strings.Join(fields[:len(fields)-2], " "); line != tt.want {
+				t.Errorf("line = %s\nwant   %s", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string
+	}{
+		{name: "all", want: accessLogFields},
+		{name: "selected", fields: []string{"status", "path", "user_agent"}, want: []string{"path", "status", "user_agent"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, lines := newTestAccessLog(t, &config.AccessLog{Format: "json", File: "access.json", Fields: tt.fields, SampleRate: 1})
+
+			r := httptest.NewRequest(http.MethodPost, "http://isp.example.com/login", nil)
+			r.Header.Set("User-Agent", "curl\"\n")
+			logRequest(a, r, http.StatusFound, 0, "")
+
+			got := lines()
+			if len(got) != 1 {
+				t.Fatalf("wrote %d lines, want 1: %q", len(got), got)
+			}
+
+			entry := map[string]any{}
+			if err := json.Unmarshal([]byte(got[0]), &entry); err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			for k := range entry {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+
+			if !slices.Equal(keys, want) {
+				t.Errorf("fields = %v, want %v", keys, want)
+			}
+			if entry["status"] != float64(http.StatusFound) || entry["path"] != "/login" || entry["user_agent"] != "curl\"\n" {
+				t.Errorf("entry = %v", entry)
+			}
+		})
+	}
+}
+
+func TestNewAccessLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.AccessLog
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "combined", cfg: config.AccessLog{Format: "combined"}},
+		{name: "json", cfg: config.AccessLog{Format: "JSON"}},
+		{name: "off", cfg: config.AccessLog{Format: "off"}, wantNil: true},
+		{name: "unknown format", cfg: config.AccessLog{Format: "common"}, wantErr: true},
+		{name: "unknown field", cfg: config.AccessLog{Format: "json", Fields: []string{"status", "cookie"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.File = "access.log"
+
+			a, err := NewAccessLog(&tt.cfg, t.TempDir())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (a == nil) != tt.wantNil {
+				t.Errorf("access log = %v, want nil %v", a, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		status int
+		want   int
+	}{
+		{name: "success sampled out", rate: 0, status: http.StatusOK, want: 0},
+		{name: "redirect sampled out", rate: 0, status: http.StatusFound, want: 0},
+		{name: "no status written counts as success", rate: 0, status: 0, want: 0},
+		{name: "client error always logged", rate: 0, status: http.StatusNotFound, want: 100},
+		{name: "server error always logged", rate: 0, status: http.StatusBadGateway, want: 100},
+		{name: "everything logged", rate: 1, status: http.StatusOK, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, lines := newTestAccessLog(t, &config.AccessLog{Format: "combined", File: "access.log", SampleRate: tt.rate})
+
+			for range 100 {
+				logRequest(a, httptest.NewRequest(http.MethodGet, "http://isp.example.com/", nil), tt.status, 0, "")
+			}
+
+			if got := len(lines()); got != tt.want {
+				t.Errorf("logged %d of 100 requests, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// Half the successful requests are logged, give or take
+	a, lines := newTestAccessLog(t, &config.AccessLog{Format: "combined", File: "access.log", SampleRate: 0.5})
+	for range 1000 {
+		logRequest(a, httptest.NewRequest(http.MethodGet, "http://isp.example.com/", nil), http.StatusOK, 0, "")
+	}
+
+	if got := len(lines()); got < 400 || got > 600 {
+		t.Errorf("logged %d of 1000 requests at a rate of 0.5", got)
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+// domainRule redirects a domain, or maps it to a section of the app
+type domainRule struct {
+	domain string
+
+	// redirect is the scheme and host to redirect to, the scheme is empty to keep the request's
+	redirect *url.URL
+	status   int
+
+	prefix string
+}
+
+func newDomainRules(cfgs []config.DomainRule) (map[string]*domainRule, error) {
+	rules := map[string]*domainRule{}
+
+	for _, cfg := range cfgs {
+		domain := strings.ToLower(strings.TrimSuffix(cfg.Domain, "."))
+		if domain == "" {
+			return nil, fmt.Errorf("domain rule without a domain")
+		}
+
+		if cfg.Redirect != "" && cfg.Prefix != "" {
+			return nil, fmt.Errorf("domain rule for %s can't both redirect and set a prefix", cfg.Domain)
+		}
+
+		rule := &domainRule{domain: domain, status: cfg.RedirectStatus}
+
+		if cfg.Redirect != "" {
+			target := cfg.Redirect
+			if !strings.Contains(target, "://") {
+				target = "//" + target
+			}
+
+			u, err := url.Parse(target)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid redirect for %s: %q", cfg.Domain, cfg.Redirect)
+			}
+
+			if strings.EqualFold(u.Host, domain) {
+				return nil, fmt.Errorf("domain %s redirects to itself", cfg.Domain)
+			}
+
+			rule.redirect = u
+		}
+
+		switch rule.status {
+		case 0:
+			rule.status = http.StatusMovedPermanently
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("invalid redirect status for %s: %d", cfg.Domain, rule.status)
+		}
+
+		if cfg.Prefix != "" {
+			rule.prefix = "/" + strings.Trim(cfg.Prefix, "/")
+		}
+
+		rules[domain] = rule
+	}
+
+	return rules, nil
+}
+
+// domainRule finds the rule for the request's host, an exact match before a wildcard
+func (p *Proxy) domainRule(r *http.Request) *domainRule {
+	if len(p.domains) == 0 {
+		return nil
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if rule, ok := p.domains[host]; ok {
+		return rule
+	}
+
+	if i := strings.Index(host, "."); i != -1 {
+		return p.domains["*"+host[i:]]
+	}
+
+	return nil
+}
+
+// routeDomain applies the rule for the request's host. It returns false if the request was redirected, and
+// otherwise the request with its path mapped to the app.
+func (p *Proxy) routeDomain(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	rule := p.domainRule(r)
+	if rule == nil {
+		return r, true
+	}
+
+	if rule.redirect != nil {
+		scheme := rule.redirect.Scheme
+		if scheme == "" {
+			scheme = webserver.Scheme(r)
+		}
+
+		http.Redirect(w, r, scheme+"://"+rule.redirect.Host+r.URL.RequestURI(), rule.status)
+		return r, false
+	}
+
+	if rule.prefix != "" && !matchPrefix(r.URL.Path, rule.prefix) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = rule.prefix + r.URL.Path
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = rule.prefix + r.URL.RawPath
+		}
+		return r2, true
+	}
+
+	return r, true
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+func TestNewDomainRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.DomainRule
+		wantErr bool
+	}{
+		{name: "redirect to a host", cfg: config.DomainRule{Domain: "old.example.com", Redirect: "example.com"}},
+		{name: "redirect to a URL", cfg: config.DomainRule{Domain: "old.example.com", Redirect: "https://example.com"}},
+		{name: "prefix", cfg: config.DomainRule{Domain: "portal.example.com", Prefix: "portal"}},
+		{name: "found status", cfg: config.DomainRule{Domain: "old.example.com", Redirect: "example.com", RedirectStatus: 302}},
+		{name: "no domain", cfg: config.DomainRule{Redirect: "example.com"}, wantErr: true},
+		{name: "redirect and prefix", cfg: config.DomainRule{Domain: "a.example.com", Redirect: "example.com", Prefix: "/a"}, wantErr: true},
+		{name: "redirect without a host", cfg: config.DomainRule{Domain: "a.example.com", Redirect: "https://"}, wantErr: true},
+		{name: "redirect to itself", cfg: config.DomainRule{Domain: "Example.com.", Redirect: "https://example.com"}, wantErr: true},
+		{name: "invalid status", cfg: config.DomainRule{Domain: "a.example.com", Redirect: "example.com", RedirectStatus: 200}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newDomainRules([]config.DomainRule{tt.cfg})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newDomainRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouteDomain(t *testing.T) {
+	rules, err := newDomainRules([]config.DomainRule{
+		{Domain: "www.example.com", Redirect: "example.com"},
+		{Domain: "old.example.net", Redirect: "https://example.com", RedirectStatus: http.StatusFound},
+		{Domain: "*.example.org", Redirect: "example.com", RedirectStatus: http.StatusPermanentRedirect},
+		{Domain: "portal.example.org", Prefix: "/portal/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Proxy{domains: rules}
+
+	tests := []struct {
+		name         string
+		target       string
+		host         string
+		tls          bool
+		wantStatus   int
+		wantLocation string
+		wantPath     string
+	}{
+		{
+			name:     "no rule",
+			target:   "/login",
+			host:     "example.com",
+			wantPath: "/login",
+		},
+		{
+			name:         "redirect keeps the path, query and scheme",
+			target:       "/login?next=%2Fbilling",
+			host:         "www.example.com",
+			tls:          true,
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/login?next=%2Fbilling",
+		},
+		{
+			name:         "redirect over http",
+			target:       "/",
+			host:         "www.example.com",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "http://example.com/",
+		},
+		{
+			name:         "redirect sets the scheme",
+			target:       "/about",
+			host:         "old.example.net",
+			wantStatus:   http.StatusFound,
+			wantLocation: "https://example.com/about",
+		},
+		{
+			name:         "host with a port, trailing dot and capitals",
+			target:       "/",
+			host:         "WWW.Example.com.:8443",
+			tls:          true,
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/",
+		},
+		{
+			name:         "wildcard",
+			target:       "/",
+			host:         "shop.example.org",
+			tls:          true,
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/",
+		},
+		{
+			name:     "wildcard only covers one label",
+			target:   "/",
+			host:     "a.b.example.org",
+			wantPath: "/",
+		},
+		{
+			name:     "exact match wins over the wildcard",
+			target:   "/invoices",
+			host:     "portal.example.org",
+			wantPath: "/portal/invoices",
+		},
+		{
+			name:     "prefix on the root",
+			target:   "/",
+			host:     "portal.example.org",
+			wantPath: "/portal/",
+		},
+		{
+			name:     "path already under the prefix",
+			target:   "/portal/invoices",
+			host:     "portal.example.org",
+			wantPath: "/portal/invoices",
+		},
+		{
+			name:     "prefix on an encoded path",
+			target:   "/files/a%2Fb",
+			host:     "portal.example.org",
+			wantPath: "/portal/files/a/b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Host = tt.host
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			w := httptest.NewRecorder()
+			r2, ok := p.routeDomain(w, r)
+
+			if tt.wantStatus != 0 {
+				if ok {
+					t.Fatal("request was not redirected")
+				}
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+				if got := w.Header().Get("Location"); got != tt.wantLocation {
+					t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatalf("request was redirected to %s", w.Header().Get("Location"))
+			}
+			if r2.URL.Path != tt.wantPath {
+				t.Errorf("path = %q, want %q", r2.URL.Path, tt.wantPath)
+			}
+			if tt.target == tt.wantPath && r2 != r {
+				t.Error("request was copied without changing")
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+//go:embed maintenance.html
+var defaultMaintenancePage string
+
+// unavailableRetryAfter is sent when the app can't be reached, it is usually only restarting
+const unavailableRetryAfter = 30 * time.Second
+
+// MaintenanceState is whether maintenance mode is on, and the message shown while it is
+type MaintenanceState struct {
+	Enabled bool
+	Message string
+	Since   time.Time
+}
+
+// maintenance serves the maintenance page, while it is turned on or something holds it on, such as an update
+type maintenance struct {
+	allow      *webserver.IPSet
+	page       *template.Template
+	message    string
+	retryAfter time.Duration
+
+	mu    sync.Mutex
+	state MaintenanceState
+
+	// holds are the messages of the operations holding maintenance mode on
+	holds map[int]string
+	next  int
+}
+
+func newMaintenance(cfg *config.Maintenance) (*maintenance, error) {
+	m := &maintenance{
+		message:    cfg.Message,
+		retryAfter: cfg.RetryAfter,
+		holds:      map[int]string{},
+	}
+
+	var err error
+	if m.allow, err = webserver.NewIPSet(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("invalid maintenance allow list: %w", err)
+	}
+
+	if cfg.Page != "" {
+		m.page, err = template.ParseFiles(cfg.Page)
+	} else {
+		m.page, err = template.New("maintenance").Parse(defaultMaintenancePage)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance page: %w", err)
+	}
+
+	return m, nil
+}
+
+// active returns the message to show if maintenance mode is on, or something is holding it on
+func (m *maintenance) active() (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.Enabled {
+		return m.state.Message, true
+	}
+
+	for _, msg := range m.holds {
+		return msg, true
+	}
+
+	return "", false
+}
+
+// serve shows the maintenance page if it is on, unless the client is allowed through
+func (m *maintenance) serve(w http.ResponseWriter, r *http.Request) bool {
+	msg, on := m.active()
+	if !on || m.allow.Contains(net.ParseIP(webserver.ClientIP(r))) {
+		return false
+	}
+
+	m.render(w, r, "Down for maintenance", msg, m.retryAfter)
+
+	return true
+}
+
+// render writes the maintenance page with a 503, or a JSON error for API clients
+func (m *maintenance) render(w http.ResponseWriter, r *http.Request, title, message string, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := m.page.Execute(&buf, map[string]string{"Title": title, "Message": message}); err != nil {
+		http.Error(w, message, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// SetMaintenance turns maintenance mode on or off. The configured message is used if message is empty.
+func (p *Proxy) SetMaintenance(enabled bool, message string) MaintenanceState {
+	m := p.maintenance
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enabled {
+		m.state = MaintenanceState{}
+		return m.state
+	}
+
+	if message == "" {
+		message = m.message
+	}
+
+	since := m.state.Since
+	if !m.state.Enabled {
+		since = time.Now()
+	}
+
+	m.state = MaintenanceState{Enabled: true, Message: message, Since: since}
+
+	return m.state
+}
+
+// RestoreMaintenance puts back maintenance mode as it was saved, keeping when it was turned on
+func (p *Proxy) RestoreMaintenance(state MaintenanceState) {
+	m := p.maintenance
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !state.Enabled {
+		m.state = MaintenanceState{}
+		return
+	}
+
+	if state.Message == "" {
+		state.Message = m.message
+	}
+
+	// Saved before the start time was kept
+	if state.Since.IsZero() {
+		state.Since = time.Now()
+	}
+
+	m.state = state
+}
+
+// Maintenance returns whether maintenance mode has been turned on
+func (p *Proxy) Maintenance() MaintenanceState {
+	p.maintenance.mu.Lock()
+	defer p.maintenance.mu.Unlock()
+
+	return p.maintenance.state
+}
+
+// HoldMaintenance shows the maintenance page until release is called, for operations that take the app down such
+// as updates. It doesn't change the state set with SetMaintenance.
+func (p *Proxy) HoldMaintenance(message string) (release func()) {
+	m := p.maintenance
+
+	m.mu.Lock()
+	id := m.next
+	m.next++
+	m.holds[id] = message
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		delete(m.holds, id)
+		m.mu.Unlock()
+	}
+}
+
+// MaintenanceHeld reports whether an operation, such as an update, is holding maintenance mode on
+func (p *Proxy) MaintenanceHeld() bool {
+	p.maintenance.mu.Lock()
+	defer p.maintenance.mu.Unlock()
+
+	return len(p.maintenance.holds) > 0
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/node-isp/node-isp/pkg/server/metrics"
+)
+
+// SetRouteGroups sets the path prefixes requests are counted under in the metrics, it must be called before the
+// proxy serves any requests
+func (p *Proxy) SetRouteGroups(groups []string) {
+	p.groups = append([]string(nil), groups...)
+	sort.SliceStable(p.groups, func(i, j int) bool { return len(p.groups[i]) > len(p.groups[j]) })
+}
+
+// routeGroup is the longest route group matching the path, requests for anything else are counted as app
+func (p *Proxy) routeGroup(path string) string {
+	for _, g := range p.groups {
+		if matchPrefix(path, g) {
+			return g
+		}
+	}
+
+	return "app"
+}
+
+func observeRequest(r *http.Request, rec *responseRecorder, info *requestInfo) {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	group := info.group
+	if group == "" {
+		group = "app"
+	}
+
+	metrics.ObserveRequest(group, r.Method, status, time.Since(info.start))
+}
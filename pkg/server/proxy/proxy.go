@@ -0,0 +1,351 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/go-units"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/webserver"
+)
+
+// Proxy forwards requests to the app, setting the forwarding headers from the client address the webserver
+// resolved, and adding the security headers to the responses
+type Proxy struct {
+	target  *url.URL
+	headers *config.SecurityHeaders
+	log     *log.Entry
+
+	// limits are the default limits, and routes the overrides sorted longest path first
+	limits limits
+	routes []route
+
+	// access are the access policies, sorted longest path first
+	access []*accessPolicy
+
+	limiters []*rateLimiter
+	banned   *banList
+
+	// accessLog is nil when access logging is off
+	accessLog *AccessLog
+
+	maintenance *maintenance
+
+	// domains are the domain rules by host name, wildcards are keyed as *.example.com
+	domains map[string]*domainRule
+
+	// static are the directories served from disk, sorted longest path first
+	static []*staticDir
+
+	// groups are the path prefixes requests are counted under in the metrics, sorted longest first
+	groups []string
+
+	rp *httputil.ReverseProxy
+
+	// handler traces each request, around serveHTTP
+	handler http.Handler
+}
+
+type limits struct {
+	maxBody int64
+	timeout time.Duration
+}
+
+type route struct {
+	path string
+	limits
+}
+
+// New creates the proxy to the app. The static directories are served from disk as well as those configured,
+// such as the app's storage directory.
+func New(target *url.URL, cfg *config.Proxy, static []config.StaticPath, accessLog *AccessLog, log *log.Entry) (*Proxy, error) {
+	maxBody, err := units.FromHumanSize(cfg.MaxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max_body_size: %w", err)
+	}
+
+	p := &Proxy{
+		target:    target,
+		headers:   cfg.Headers,
+		log:       log,
+		limits:    limits{maxBody: maxBody, timeout: cfg.Timeout},
+		accessLog: accessLog,
+	}
+
+	for _, r := range cfg.Routes {
+		l := p.limits
+
+		if r.MaxBodySize != "" {
+			if l.maxBody, err = units.FromHumanSize(r.MaxBodySize); err != nil {
+				return nil, fmt.Errorf("invalid max_body_size for %s: %w", r.Path, err)
+			}
+		}
+
+		if r.Timeout > 0 {
+			l.timeout = r.Timeout
+		}
+
+		p.routes = append(p.routes, route{path: r.Path, limits: l})
+	}
+
+	sort.SliceStable(p.routes, func(i, j int) bool { return len(p.routes[i].path) > len(p.routes[j].path) })
+
+	if p.access, err = newAccessPolicies(cfg.Access); err != nil {
+		return nil, err
+	}
+
+	if p.limiters, err = newRateLimiters(cfg.RateLimits); err != nil {
+		return nil, err
+	}
+	p.banned = &banList{bans: map[string]Ban{}}
+
+	if p.maintenance, err = newMaintenance(cfg.Maintenance); err != nil {
+		return nil, err
+	}
+
+	if p.domains, err = newDomainRules(cfg.Domains); err != nil {
+		return nil, err
+	}
+
+	if p.static, err = newStaticDirs(append(static, cfg.Static...)); err != nil {
+		return nil, err
+	}
+
+	// The transport passes the trace context on to the app, so its spans join the proxy's
+	p.rp = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+		Transport:      otelhttp.NewTransport(&timedTransport{http.DefaultTransport}),
+	}
+
+	p.handler = otelhttp.NewHandler(http.HandlerFunc(p.serveHTTP), "proxy",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + p.routeGroup(r.URL.Path)
+		}),
+	)
+
+	return p, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+func (p *Proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r, info := newRequestInfo(r)
+	w.Header().Set("X-Request-ID", info.id)
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request_id", info.id))
+
+	rec := &responseRecorder{ResponseWriter: w}
+	p.serve(&headerWriter{ResponseWriter: rec, r: r, headers: p.headers}, r)
+
+	observeRequest(r, rec, info)
+
+	if p.accessLog != nil {
+		p.accessLog.write(r, rec, info)
+	}
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	// Map the path before checking access, so the limits and policies apply however a section of the app is reached
+	r, ok := p.routeDomain(w, r)
+	if !ok {
+		return
+	}
+
+	getRequestInfo(r).group = p.routeGroup(r.URL.Path)
+
+	if !p.checkRateLimits(w, r, webserver.ClientIP(r)) {
+		return
+	}
+
+	if p.maintenance.serve(w, r) {
+		return
+	}
+
+	for _, a := range p.access {
+		if matchPrefix(r.URL.Path, a.path) {
+			if !a.allowed(w, r, p.log) {
+				return
+			}
+			break
+		}
+	}
+
+	if p.serveStatic(w, r) {
+		return
+	}
+
+	l := p.limitsFor(r.URL.Path)
+
+	if l.maxBody > 0 {
+		if r.ContentLength > l.maxBody {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, l.maxBody)
+	}
+
+	if l.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		// The server timeouts are only a backstop, let slow routes such as uploads and exports run for their timeout
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Now().Add(l.timeout))
+		_ = rc.SetWriteDeadline(time.Now().Add(l.timeout + 5*time.Second))
+	}
+
+	p.rp.ServeHTTP(w, r)
+}
+
+func (p *Proxy) limitsFor(path string) limits {
+	for _, r := range p.routes {
+		if matchPrefix(path, r.path) {
+			return r.limits
+		}
+	}
+
+	return p.limits
+}
+
+// rewrite points the request at the app. The forwarding headers the client sent have already been removed by
+// ReverseProxy, so only the values resolved from trusted proxies reach the app.
+func (p *Proxy) rewrite(pr *httputil.ProxyRequest) {
+	pr.SetURL(p.target)
+
+	// Keep the original host, so the app can tell which domain was requested
+	pr.Out.Host = pr.In.Host
+
+	clientIP := webserver.ClientIP(pr.In)
+
+	pr.Out.Header.Del("X-Real-IP")
+	pr.Out.Header.Del("X-Forwarded-Port")
+	pr.Out.Header.Set("X-Forwarded-For", clientIP)
+	pr.Out.Header.Set("X-Real-IP", clientIP)
+	pr.Out.Header.Set("X-Forwarded-Host", pr.In.Host)
+	pr.Out.Header.Set("X-Forwarded-Proto", webserver.Scheme(pr.In))
+
+	if port := forwardedPort(pr.In); port != "" {
+		pr.Out.Header.Set("X-Forwarded-Port", port)
+	}
+
+	pr.Out.Header.Set("X-Request-ID", getRequestInfo(pr.In).id)
+}
+
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	// The request ID is already set on the response, don't send it twice
+	resp.Header.Del("X-Request-ID")
+
+	return nil
+}
+
+// headerWriter adds the security headers as the response is written, so every response gets them, including the
+// maintenance page, redirects and errors written by the proxy itself
+type headerWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	headers *config.SecurityHeaders
+	written bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints are followed by the real one
+	if status >= 200 {
+		w.addHeaders()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.addHeaders()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *headerWriter) addHeaders() {
+	if !w.written {
+		w.written = true
+		securityHeaders(w.Header(), w.r, w.headers)
+	}
+}
+
+// securityHeaders adds the security headers the response doesn't already have
+func securityHeaders(header http.Header, r *http.Request, h *config.SecurityHeaders) {
+	if h == nil {
+		return
+	}
+
+	if webserver.Scheme(r) == "https" {
+		setDefault(header, "Strict-Transport-Security", h.HSTS)
+	}
+
+	setDefault(header, "X-Frame-Options", h.FrameOptions)
+	setDefault(header, "X-Content-Type-Options", h.ContentTypeOptions)
+	setDefault(header, "Referrer-Policy", h.ReferrerPolicy)
+	setDefault(header, "Content-Security-Policy", h.ContentSecurityPolicy)
+
+	for k, v := range h.Custom {
+		setDefault(header, k, v)
+	}
+}
+
+func (p *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytes *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytes):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, context.DeadlineExceeded):
+		p.log.WithField("path", r.URL.Path).Warn("app did not respond in time")
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		// The client went away, there is no one to respond to
+		w.WriteHeader(499)
+	default:
+		// The app is usually only restarting, so show the maintenance page rather than a bare error
+		p.log.WithError(err).WithField("path", r.URL.Path).Error("failed to proxy request")
+		p.maintenance.render(w, r, "Temporarily unavailable",
+			"We can't reach the service right now, please try again in a moment.", unavailableRetryAfter)
+	}
+}
+
+// setDefault sets a header unless the app already set it, or it is disabled
+func setDefault(h http.Header, key, value string) {
+	if value == "" || strings.EqualFold(value, "off") || h.Get(key) != "" {
+		return
+	}
+
+	h.Set(key, value)
+}
+
+// forwardedPort is the port the client connected to, when it can be known
+func forwardedPort(r *http.Request) string {
+	if i := strings.LastIndex(r.Host, ":"); i != -1 && !strings.HasSuffix(r.Host, "]") {
+		return r.Host[i+1:]
+	}
+
+	if webserver.Scheme(r) == "https" {
+		return "443"
+	}
+
+	return "80"
+}
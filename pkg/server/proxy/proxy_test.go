@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/creasty/defaults"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// newTestProxy creates a proxy to target, with the config's defaults applied
+func newTestProxy(t *testing.T, target string, cfg *config.Proxy, static ...config.StaticPath) *Proxy {
+	t.Helper()
+
+	if cfg == nil {
+		cfg = &config.Proxy{}
+	}
+	if err := defaults.Set(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(u, cfg, static, nil, (&log.Logger{Handler: discard.Default}).WithField("component", "proxy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embed" {
+			w.Header().Set("X-Frame-Options", "ALLOW-FROM https://partner.example.com")
+		}
+		_, _ = w.Write([]byte("app"))
+	}))
+	defer app.Close()
+
+	// Nothing listens on a closed server's address, so requests to it fail
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.css"), []byte("body{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Proxy{
+		Domains: []config.DomainRule{{Domain: "old.example.com", Redirect: "example.com"}},
+	}
+
+	tests := []struct {
+		name      string
+		target    string
+		path      string
+		host      string
+		tls       bool
+		maint     bool
+		status    int
+		wantFrame string
+		wantHSTS  bool
+	}{
+		{
+			name:      "proxied response",
+			target:    app.URL,
+			path:      "/",
+			status:    http.StatusOK,
+			wantFrame: "SAMEORIGIN",
+		},
+		{
+			name:      "app's own header is kept",
+			target:    app.URL,
+			path:      "/embed",
+			status:    http.StatusOK,
+			wantFrame: "ALLOW-FROM https://partner.example.com",
+		},
+		{
+			name:      "HSTS over https",
+			target:    app.URL,
+			path:      "/",
+			tls:       true,
+			status:    http.StatusOK,
+			wantFrame: "SAMEORIGIN",
+			wantHSTS:  true,
+		},
+		{
+			name:      "static file",
+			target:    app.URL,
+			path:      "/build/app.css",
+			status:    http.StatusOK,
+			wantFrame: "SAMEORIGIN",
+		},
+		{
+			name:      "domain redirect",
+			target:    app.URL,
+			path:      "/login",
+			host:      "old.example.com",
+			tls:       true,
+			status:    http.StatusMovedPermanently,
+			wantFrame: "SAMEORIGIN",
+			wantHSTS:  true,
+		},
+		{
+			name:      "maintenance page",
+			target:    app.URL,
+			path:      "/",
+			maint:     true,
+			status:    http.StatusServiceUnavailable,
+			wantFrame: "SAMEORIGIN",
+		},
+		{
+			name:      "app unreachable",
+			target:    down.URL,
+			path:      "/",
+			status:    http.StatusServiceUnavailable,
+			wantFrame: "SAMEORIGIN",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, tt.target, cfg, config.StaticPath{Path: "/build", Dir: dir})
+			if tt.maint {
+				p.SetMaintenance(true, "")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.host != "" {
+				r.Host = tt.host
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			h := w.Result().Header
+
+			if got := h.Get("X-Frame-Options"); got != tt.wantFrame {
+				t.Errorf("X-Frame-Options = %q, want %q", got, tt.wantFrame)
+			}
+
+			if got := h.Values("X-Content-Type-Options"); len(got) != 1 || got[0] != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want it once", got)
+			}
+
+			if got := h.Get("Strict-Transport-Security") != ""; got != tt.wantHSTS {
+				t.Errorf("HSTS sent = %v, want %v", got, tt.wantHSTS)
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersOff(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", &config.Proxy{
+		Headers: &config.SecurityHeaders{FrameOptions: "off", Custom: map[string]string{"Permissions-Policy": "camera=()"}},
+	})
+	p.SetMaintenance(true, "")
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := w.Result().Header.Get("X-Frame-Options"); got != "" {
+		t.Errorf("X-Frame-Options = %q, want it left out", got)
+	}
+
+	if got := w.Result().Header.Get("Permissions-Policy"); got != "camera=()" {
+		t.Errorf("Permissions-Policy = %q", got)
+	}
+}
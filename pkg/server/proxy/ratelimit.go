@@ -0,0 +1,366 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/time/rate"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// sweepInterval is how often idle clients are dropped from the rate limiters
+const sweepInterval = time.Minute
+
+// RateLimitStats are the counters of a rate limit, since the daemon started
+type RateLimitStats struct {
+	Path    string
+	Methods []string
+
+	// Allowed and Limited count requests, Bans the clients banned and Clients those currently tracked
+	Allowed uint64
+	Limited uint64
+	Bans    uint64
+	Clients int
+}
+
+// Ban is a client banned for exceeding a rate limit
+type Ban struct {
+	// IP is the address banned, or the /64 network for IPv6 clients
+	IP    string
+	Path  string
+	Until time.Time
+}
+
+// rateLimiter is a token bucket per client, for the requests matching a path
+type rateLimiter struct {
+	path     string
+	methods  map[string]bool
+	rate     rate.Limit
+	burst    int
+	banAfter int
+	banFor   time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*rateClient
+	lastSweep time.Time
+
+	allowed atomic.Uint64
+	limited atomic.Uint64
+	bans    atomic.Uint64
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+
+	// strikes are the times the client was limited since firstStrike
+	strikes     int
+	firstStrike time.Time
+}
+
+func newRateLimiters(cfgs []config.RateLimit) ([]*rateLimiter, error) {
+	var limiters []*rateLimiter
+
+	for _, cfg := range cfgs {
+		if cfg.Rate <= 0 || cfg.Burst <= 0 {
+			return nil, fmt.Errorf("rate limit for %s needs a rate and burst above zero", cfg.Path)
+		}
+
+		if _, err := path.Match(cfg.Path, "/"); err != nil {
+			return nil, fmt.Errorf("invalid rate limit path %s: %w", cfg.Path, err)
+		}
+
+		l := &rateLimiter{
+			path:     cfg.Path,
+			rate:     rate.Limit(cfg.Rate),
+			burst:    cfg.Burst,
+			banAfter: cfg.BanAfter,
+			banFor:   cfg.BanFor,
+			clients:  map[string]*rateClient{},
+		}
+
+		if l.banFor <= 0 {
+			l.banFor = 15 * time.Minute
+		}
+
+		if len(cfg.Methods) > 0 {
+			l.methods = map[string]bool{}
+			for _, m := range cfg.Methods {
+				l.methods[strings.ToUpper(m)] = true
+			}
+		}
+
+		limiters = append(limiters, l)
+	}
+
+	return limiters, nil
+}
+
+func (l *rateLimiter) matches(r *http.Request) bool {
+	if l.methods != nil && !l.methods[r.Method] {
+		return false
+	}
+
+	if strings.Contains(l.path, "*") {
+		ok, _ := path.Match(strings.ToLower(l.path), strings.ToLower(r.URL.Path))
+		return ok
+	}
+
+	return matchPrefix(r.URL.Path, l.path)
+}
+
+// reserve takes a token for the client, returning the reservation so it can be given back if another limit rejects
+// the request. If there are none left nothing is taken, and it returns how long until there will be.
+func (l *rateLimiter) reserve(key string, now time.Time) (*rate.Reservation, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[key] = c
+	}
+
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, 1)
+	if retry := res.DelayFrom(now); retry > 0 {
+		// Don't use up the token, the request is rejected rather than delayed
+		res.CancelAt(now)
+		return nil, retry
+	}
+
+	return res, 0
+}
+
+// strike counts a rejected request against the client, and reports whether it should now be banned
+func (l *rateLimiter) strike(key string, now time.Time) bool {
+	l.limited.Add(1)
+
+	if l.banAfter <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[key]
+	if !ok {
+		return false
+	}
+
+	if now.Sub(c.firstStrike) > l.banFor {
+		c.strikes, c.firstStrike = 0, now
+	}
+
+	c.strikes++
+	if c.strikes < l.banAfter {
+		return false
+	}
+
+	c.strikes = 0
+	l.bans.Add(1)
+
+	return true
+}
+
+// sweep drops clients that have been idle long enough for their bucket to fill and their strikes to expire
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+
+	idle := l.banFor
+	if refill := time.Duration(float64(l.burst) / float64(l.rate) * float64(time.Second)); refill > idle {
+		idle = refill
+	}
+
+	for ip, c := range l.clients {
+		if now.Sub(c.lastSeen) > idle {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+func (l *rateLimiter) stats() RateLimitStats {
+	l.mu.Lock()
+	clients := len(l.clients)
+	l.mu.Unlock()
+
+	s := RateLimitStats{
+		Path:    l.path,
+		Allowed: l.allowed.Load(),
+		Limited: l.limited.Load(),
+		Bans:    l.bans.Load(),
+		Clients: clients,
+	}
+
+	for m := range l.methods {
+		s.Methods = append(s.Methods, m)
+	}
+	sort.Strings(s.Methods)
+
+	return s
+}
+
+// banList holds the clients temporarily banned by a rate limit
+type banList struct {
+	mu        sync.Mutex
+	bans      map[string]Ban
+	lastSweep time.Time
+}
+
+func (b *banList) add(key, path string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bans[key] = Ban{IP: key, Path: path, Until: until}
+}
+
+// sweep drops expired bans, as clients that never come back wouldn't otherwise be removed
+func (b *banList) sweep(now time.Time) {
+	b.lastSweep = now
+
+	for key, ban := range b.bans {
+		if !now.Before(ban.Until) {
+			delete(b.bans, key)
+		}
+	}
+}
+
+// banned returns how long the client is still banned for
+func (b *banList) banned(key string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.lastSweep) > sweepInterval {
+		b.sweep(now)
+	}
+
+	ban, ok := b.bans[key]
+	if !ok || !now.Before(ban.Until) {
+		return 0
+	}
+
+	return ban.Until.Sub(now)
+}
+
+func (b *banList) list(now time.Time) []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(now)
+
+	bans := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+
+	return bans
+}
+
+// checkRateLimits rejects requests from banned clients and those over a rate limit. Every matching limit takes a
+// token, so a site wide limit and a stricter one for the login page both apply, but only once all of them allow the
+// request. A request rejected by one limit doesn't use up the others.
+func (p *Proxy) checkRateLimits(w http.ResponseWriter, r *http.Request, clientIP string) bool {
+	now := time.Now()
+	key := rateKey(clientIP)
+
+	if d := p.banned.banned(key, now); d > 0 {
+		tooManyRequests(w, d)
+		return false
+	}
+
+	var (
+		matched []*rateLimiter
+		taken   []*rate.Reservation
+	)
+
+	for _, l := range p.limiters {
+		if !l.matches(r) {
+			continue
+		}
+
+		res, retry := l.reserve(key, now)
+		if retry == 0 {
+			matched = append(matched, l)
+			taken = append(taken, res)
+			continue
+		}
+
+		// Give back the tokens the other limits took, the request isn't going to be made
+		for _, res := range taken {
+			res.CancelAt(now)
+		}
+
+		if l.strike(key, now) {
+			retry = l.banFor
+			p.banned.add(key, l.path, now.Add(retry))
+			p.log.WithFields(log.Fields{
+				"client": key,
+				"path":   l.path,
+				"until":  now.Add(retry).Format(time.RFC3339),
+			}).Warn("client banned for exceeding the rate limit")
+		}
+
+		tooManyRequests(w, retry)
+		return false
+	}
+
+	for _, l := range matched {
+		l.allowed.Add(1)
+	}
+
+	return true
+}
+
+// rateKey is the key a client is limited and banned by. IPv6 clients are usually given a whole /64, so they are
+// limited by it rather than by address, or they could pick a new address for every request.
+func rateKey(clientIP string) string {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return clientIP
+	}
+
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+
+	return netip.PrefixFrom(addr, 64).Masked().String()
+}
+
+// RateLimitStats returns the counters of each rate limit
+func (p *Proxy) RateLimitStats() []RateLimitStats {
+	stats := make([]RateLimitStats, 0, len(p.limiters))
+	for _, l := range p.limiters {
+		stats = append(stats, l.stats())
+	}
+
+	return stats
+}
+
+// Bans returns the clients currently banned, soonest to expire first
+func (p *Proxy) Bans() []Ban {
+	return p.banned.list(time.Now())
+}
+
+func tooManyRequests(w http.ResponseWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
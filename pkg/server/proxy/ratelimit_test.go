@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+func newRateLimitProxy(t *testing.T, cfgs ...config.RateLimit) *Proxy {
+	t.Helper()
+
+	limiters, err := newRateLimiters(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Proxy{
+		limiters: limiters,
+		banned:   &banList{bans: map[string]Ban{}},
+		log:      (&log.Logger{Handler: discard.Default}).WithField("component", "proxy"),
+	}
+}
+
+// request runs a request through the rate limits, and returns the status it would get
+func request(p *Proxy, method, path, clientIP string) int {
+	w := httptest.NewRecorder()
+	if !p.checkRateLimits(w, httptest.NewRequest(method, path, nil), clientIP) {
+		return w.Code
+	}
+
+	return http.StatusOK
+}
+
+func TestRateKey(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":            "192.0.2.1",
+		"::ffff:192.0.2.1":     "192.0.2.1",
+		"2001:db8:1:2::1":      "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff::9": "2001:db8:1:2::/64",
+		"2001:db8:1:3::1":      "2001:db8:1:3::/64",
+		"fe80::1%eth0":         "fe80::/64",
+		"not-an-ip":            "not-an-ip",
+	}
+
+	for ip, want := range tests {
+		if got := rateKey(ip); got != want {
+			t.Errorf("rateKey(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestNewRateLimiters(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.RateLimit
+		wantErr bool
+	}{
+		{name: "valid", cfg: config.RateLimit{Path: "/login", Rate: 1, Burst: 5}},
+		{name: "wildcard", cfg: config.RateLimit{Path: "/portal/*/login", Rate: 1, Burst: 5}},
+		{name: "no rate", cfg: config.RateLimit{Path: "/login", Burst: 5}, wantErr: true},
+		{name: "no burst", cfg: config.RateLimit{Path: "/login", Rate: 1}, wantErr: true},
+		{name: "invalid pattern", cfg: config.RateLimit{Path: "/login[", Rate: 1, Burst: 5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRateLimiters([]config.RateLimit{tt.cfg})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newRateLimiters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMatches(t *testing.T) {
+	tests := []struct {
+		cfg    config.RateLimit
+		method string
+		path   string
+		want   bool
+	}{
+		{config.RateLimit{Path: "/login"}, http.MethodGet, "/login", true},
+		{config.RateLimit{Path: "/login"}, http.MethodGet, "/login/sso", true},
+		{config.RateLimit{Path: "/login"}, http.MethodGet, "/loginx", false},
+		{config.RateLimit{Path: "/login", Methods: []string{"post"}}, http.MethodPost, "/login", true},
+		{config.RateLimit{Path: "/login", Methods: []string{"post"}}, http.MethodGet, "/login", false},
+		{config.RateLimit{Path: "/portal/*/login"}, http.MethodGet, "/portal/acme/login", true},
+		{config.RateLimit{Path: "/portal/*/login"}, http.MethodGet, "/Portal/ACME/Login", true},
+		{config.RateLimit{Path: "/portal/*/login"}, http.MethodGet, "/portal/a/b/login", false},
+	}
+
+	for _, tt := range tests {
+		tt.cfg.Rate, tt.cfg.Burst = 1, 1
+
+		limiters, err := newRateLimiters([]config.RateLimit{tt.cfg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := limiters[0].matches(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s with methods %v matches %s %s = %v, want %v", tt.cfg.Path, tt.cfg.Methods, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	p := newRateLimitProxy(t, config.RateLimit{Path: "/login", Rate: 0.001, Burst: 2})
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := request(p, http.MethodPost, "/login", "192.0.2.1"); got != want {
+			t.Errorf("request %d status = %d, want %d", i+1, got, want)
+		}
+	}
+
+	// Other clients and paths have their own allowance
+	if got := request(p, http.MethodPost, "/login", "192.0.2.2"); got != http.StatusOK {
+		t.Errorf("other client status = %d", got)
+	}
+	if got := request(p, http.MethodPost, "/about", "192.0.2.1"); got != http.StatusOK {
+		t.Errorf("other path status = %d", got)
+	}
+
+	s := p.RateLimitStats()[0]
+	if s.Allowed != 3 || s.Limited != 1 || s.Clients != 2 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	p := newRateLimitProxy(t, config.RateLimit{Path: "/", Rate: 0.5, Burst: 1})
+
+	request(p, http.MethodGet, "/", "192.0.2.1")
+
+	w := httptest.NewRecorder()
+	if p.checkRateLimits(w, httptest.NewRequest(http.MethodGet, "/", nil), "192.0.2.1") {
+		t.Fatal("second request allowed")
+	}
+
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+}
+
+func TestRateLimitIPv6Network(t *testing.T) {
+	p := newRateLimitProxy(t, config.RateLimit{Path: "/login", Rate: 0.001, Burst: 1})
+
+	if got := request(p, http.MethodPost, "/login", "2001:db8:1:2::1"); got != http.StatusOK {
+		t.Fatalf("first request status = %d", got)
+	}
+
+	// A new address in the same /64 shares the allowance
+	if got := request(p, http.MethodPost, "/login", "2001:db8:1:2::dead:beef"); got != http.StatusTooManyRequests {
+		t.Errorf("same /64 status = %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	if got := request(p, http.MethodPost, "/login", "2001:db8:1:3::1"); got != http.StatusOK {
+		t.Errorf("other /64 status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestRateLimitsAllChecked(t *testing.T) {
+	// A generous site wide limit, and a strict one for the login page
+	p := newRateLimitProxy(t,
+		config.RateLimit{Path: "/", Rate: 0.001, Burst: 3},
+		config.RateLimit{Path: "/login", Rate: 0.001, Burst: 1},
+	)
+
+	if got := request(p, http.MethodPost, "/login", "192.0.2.1"); got != http.StatusOK {
+		t.Fatalf("first login status = %d", got)
+	}
+
+	// Rejected logins don't use up the site wide allowance
+	for range 5 {
+		if got := request(p, http.MethodPost, "/login", "192.0.2.1"); got != http.StatusTooManyRequests {
+			t.Fatalf("login status = %d, want %d", got, http.StatusTooManyRequests)
+		}
+	}
+
+	for i := range 2 {
+		if got := request(p, http.MethodGet, "/", "192.0.2.1"); got != http.StatusOK {
+			t.Errorf("page %d status = %d, want the rest of the site wide burst", i+1, got)
+		}
+	}
+
+	if got := request(p, http.MethodGet, "/", "192.0.2.1"); got != http.StatusTooManyRequests {
+		t.Errorf("status after the burst = %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	stats := p.RateLimitStats()
+	if stats[0].Allowed != 3 || stats[1].Allowed != 1 || stats[1].Limited != 5 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestRateLimitBan(t *testing.T) {
+	p := newRateLimitProxy(t, config.RateLimit{Path: "/login", Rate: 0.001, Burst: 1, BanAfter: 2, BanFor: time.Hour})
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		if got := request(p, http.MethodPost, "/login", "2001:db8::1"); got != want {
+			t.Fatalf("request %d status = %d, want %d", i+1, got, want)
+		}
+	}
+
+	// The whole site is blocked for the network, not just the limited path
+	w := httptest.NewRecorder()
+	if p.checkRateLimits(w, httptest.NewRequest(http.MethodGet, "/about", nil), "2001:db8::2") {
+		t.Fatal("banned client allowed")
+	}
+	if got := w.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q, want 3600", got)
+	}
+
+	bans := p.Bans()
+	if len(bans) != 1 || bans[0].IP != "2001:db8::/64" || bans[0].Path != "/login" {
+		t.Errorf("bans = %+v", bans)
+	}
+}
+
+func TestBanListSweep(t *testing.T) {
+	now := time.Now()
+	b := &banList{bans: map[string]Ban{}}
+
+	b.add("192.0.2.1", "/login", now.Add(time.Minute))
+	b.add("192.0.2.2", "/login", now.Add(time.Hour))
+
+	// Looking up one client drops the others whose bans have expired, once the sweep interval has passed
+	later := now.Add(sweepInterval + 2*time.Minute)
+	if d := b.banned("192.0.2.3", later); d != 0 {
+		t.Errorf("banned() = %s for a client that isn't banned", d)
+	}
+
+	if _, ok := b.bans["192.0.2.1"]; ok {
+		t.Error("expired ban was not swept")
+	}
+	if _, ok := b.bans["192.0.2.2"]; !ok {
+		t.Error("current ban was swept")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiters, err := newRateLimiters([]config.RateLimit{{Path: "/", Rate: 1, Burst: 10, BanFor: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := limiters[0]
+
+	now := time.Now()
+	l.reserve("192.0.2.1", now)
+	l.reserve("192.0.2.2", now.Add(2*time.Minute))
+
+	// The first client has been idle for longer than a ban and a refill
+	l.reserve("192.0.2.2", now.Add(3*time.Minute))
+
+	if _, ok := l.clients["192.0.2.1"]; ok {
+		t.Error("idle client was not swept")
+	}
+	if _, ok := l.clients["192.0.2.2"]; !ok {
+		t.Error("active client was swept")
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// staticDir serves files under a path prefix from a directory, instead of the app
+type staticDir struct {
+	path   string
+	dir    http.Dir
+	maxAge time.Duration
+}
+
+func newStaticDirs(cfgs []config.StaticPath) ([]*staticDir, error) {
+	var dirs []*staticDir
+
+	for _, cfg := range cfgs {
+		if cfg.Path == "" || cfg.Dir == "" {
+			return nil, fmt.Errorf("static path needs a path and dir")
+		}
+
+		d := &staticDir{
+			path:   "/" + strings.Trim(cfg.Path, "/"),
+			dir:    http.Dir(cfg.Dir),
+			maxAge: cfg.MaxAge,
+		}
+
+		if d.maxAge <= 0 {
+			d.maxAge = time.Hour
+		}
+
+		dirs = append(dirs, d)
+	}
+
+	sort.SliceStable(dirs, func(i, j int) bool { return len(dirs[i].path) > len(dirs[j].path) })
+
+	return dirs, nil
+}
+
+// open opens the file for a request, if it exists. Directories and missing files are left to the app, which may
+// have routes under the same path.
+func (d *staticDir) open(r *http.Request) (http.File, fs.FileInfo, bool) {
+	f, err := d.dir.Open(strings.TrimPrefix(r.URL.Path, d.path))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil, false
+	}
+
+	return f, info, true
+}
+
+// serveStatic serves GET and HEAD requests for the static paths from disk
+func (p *Proxy) serveStatic(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	for _, d := range p.static {
+		if !strings.HasPrefix(r.URL.Path, d.path+"/") {
+			continue
+		}
+
+		// Never serve dotfiles, such as the .gitignore in the storage directory
+		if strings.Contains(r.URL.Path, "/.") {
+			http.NotFound(w, r)
+			return true
+		}
+
+		f, info, ok := d.open(r)
+		if !ok {
+			return false
+		}
+		defer f.Close()
+
+		h := w.Header()
+		h.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(d.maxAge.Seconds())))
+
+		// ServeContent handles conditional and range requests, and sets the content type from the name
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,397 @@
+package resources
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/go-units"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// ErrDiskCritical is returned by CheckDisk when a storage filesystem is too full for operations that need space
+var ErrDiskCritical = errors.New("disk space is critically low")
+
+// Level is how close a resource is to running out
+type Level int
+
+const (
+	OK Level = iota
+	Warning
+	Critical
+)
+
+func (l Level) String() string {
+	switch l {
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+
+	return "ok"
+}
+
+// Disk is the usage of the filesystem holding one of the storage paths
+type Disk struct {
+	Name  string
+	Path  string
+	Total uint64
+	Used  uint64
+	Free  uint64
+	Level Level
+}
+
+// Percent is the share of the filesystem in use, counting the space reserved for root as unavailable like df does
+func (d Disk) Percent() float64 {
+	if d.Used+d.Free == 0 {
+		return 0
+	}
+
+	return float64(d.Used) / float64(d.Used+d.Free) * 100
+}
+
+// Usage is a snapshot of the host's resources
+type Usage struct {
+	Disks []Disk
+
+	// DatabaseSize is the size of the app's database in bytes, and LogSize the total size of the log files
+	DatabaseSize int64
+	LogSize      int64
+
+	MemoryTotal     uint64
+	MemoryAvailable uint64
+	MemoryLevel     Level
+
+	Load      [3]float64
+	CPUs      int
+	LoadLevel Level
+
+	CheckedAt time.Time
+
+	// Errs are the parts of the snapshot that couldn't be read, they are left empty
+	Errs []error
+}
+
+// MemoryPercent is the share of the host's memory in use
+func (u Usage) MemoryPercent() float64 {
+	if u.MemoryTotal == 0 {
+		return 0
+	}
+
+	return float64(u.MemoryTotal-u.MemoryAvailable) / float64(u.MemoryTotal) * 100
+}
+
+// Level is the worst level of all the resources
+func (u Usage) Level() Level {
+	level := max(u.MemoryLevel, u.LoadLevel)
+	for _, d := range u.Disks {
+		level = max(level, d.Level)
+	}
+
+	return level
+}
+
+// Monitor checks the host's resources on an interval, and keeps the last snapshot
+type Monitor struct {
+	cfg     *config.Resources
+	storage *config.Storage
+	dsn     string
+	log     *log.Entry
+
+	mu    sync.RWMutex
+	usage Usage
+}
+
+// New creates a monitor for the storage paths, and the database at dsn
+func New(cfg *config.Resources, storage *config.Storage, dsn string, log *log.Entry) *Monitor {
+	return &Monitor{
+		cfg:     cfg,
+		storage: storage,
+		dsn:     dsn,
+		log:     log,
+	}
+}
+
+// Run refreshes the snapshot until the context is done, logging when a resource changes level
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		prev := m.Usage()
+		usage := m.Refresh(ctx)
+		m.logChanges(prev, usage)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Usage returns the last snapshot, it is empty until the first refresh
+func (m *Monitor) Usage() Usage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.usage
+}
+
+// Refresh takes a new snapshot and returns it
+func (m *Monitor) Refresh(ctx context.Context) Usage {
+	u := Usage{CheckedAt: time.Now(), CPUs: runtime.NumCPU()}
+
+	var err error
+	if u.Disks, err = disks(m.cfg, m.storage); err != nil {
+		u.Errs = append(u.Errs, err)
+	}
+
+	if u.DatabaseSize, err = databaseSize(ctx, m.dsn); err != nil {
+		u.Errs = append(u.Errs, fmt.Errorf("database size: %w", err))
+	}
+
+	if u.LogSize, err = dirSize(m.storage.Logs); err != nil {
+		u.Errs = append(u.Errs, fmt.Errorf("log size: %w", err))
+	}
+
+	if u.MemoryTotal, u.MemoryAvailable, err = memory(); err != nil {
+		u.Errs = append(u.Errs, fmt.Errorf("memory: %w", err))
+	}
+	u.MemoryLevel = level(u.MemoryPercent(), m.cfg.MemoryWarning, m.cfg.MemoryCritical)
+
+	if u.Load, err = loadAverage(); err != nil {
+		u.Errs = append(u.Errs, fmt.Errorf("load: %w", err))
+	}
+	u.LoadLevel = level(u.Load[1]/float64(u.CPUs), m.cfg.LoadWarning, m.cfg.LoadCritical)
+
+	m.mu.Lock()
+	m.usage = u
+	m.mu.Unlock()
+
+	return u
+}
+
+// CheckDisk returns ErrDiskCritical if any of the storage filesystems are over the critical level. It reads the
+// filesystems each time, so space freed since the last snapshot is seen straight away.
+func CheckDisk(cfg *config.Resources, storage *config.Storage) error {
+	disks, err := disks(cfg, storage)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range disks {
+		if d.Level == Critical {
+			return fmt.Errorf("%w: %s is %.0f%% full, with %s free", ErrDiskCritical, d.Path, d.Percent(), units.BytesSize(float64(d.Free)))
+		}
+	}
+
+	return nil
+}
+
+func disks(cfg *config.Resources, storage *config.Storage) ([]Disk, error) {
+	var disks []Disk
+
+	for _, p := range []struct{ name, path string }{{"data", storage.Data}, {"logs", storage.Logs}} {
+		d, err := statDisk(p.name, p.path)
+		if err != nil {
+			return disks, fmt.Errorf("disk usage of %s: %w", p.path, err)
+		}
+
+		d.Level = level(d.Percent(), cfg.DiskWarning, cfg.DiskCritical)
+		disks = append(disks, d)
+	}
+
+	return disks, nil
+}
+
+func (m *Monitor) logChanges(prev, usage Usage) {
+	for i, d := range usage.Disks {
+		was := OK
+		if i < len(prev.Disks) {
+			was = prev.Disks[i].Level
+		}
+
+		if d.Level != was {
+			logAt(m.log.WithField("path", d.Path).
+				WithField("percent", fmt.Sprintf("%.1f", d.Percent())).
+				WithField("free", units.BytesSize(float64(d.Free))),
+				d.Level, fmt.Sprintf("disk usage of %s is %s", d.Name, d.Level))
+		}
+	}
+
+	if usage.MemoryLevel != prev.MemoryLevel {
+		logAt(m.log.WithField("percent", fmt.Sprintf("%.1f", usage.MemoryPercent())),
+			usage.MemoryLevel, "memory usage is "+usage.MemoryLevel.String())
+	}
+
+	if usage.LoadLevel != prev.LoadLevel {
+		logAt(m.log.WithField("load5", usage.Load[1]).WithField("cpus", usage.CPUs),
+			usage.LoadLevel, "load is "+usage.LoadLevel.String())
+	}
+
+	for _, err := range usage.Errs {
+		m.log.WithError(err).Debug("failed to read resource usage")
+	}
+}
+
+// logAt logs at the log level matching the resource level
+func logAt(entry *log.Entry, l Level, msg string) {
+	switch l {
+	case Critical:
+		entry.Error(msg)
+	case Warning:
+		entry.Warn(msg)
+	default:
+		entry.Info(msg)
+	}
+}
+
+func level(value, warning, critical float64) Level {
+	switch {
+	case critical > 0 && value >= critical:
+		return Critical
+	case warning > 0 && value >= warning:
+		return Warning
+	}
+
+	return OK
+}
+
+func statDisk(name, path string) (Disk, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Disk{}, err
+	}
+
+	bsize := uint64(st.Bsize)
+
+	return Disk{
+		Name:  name,
+		Path:  path,
+		Total: st.Blocks * bsize,
+		Used:  (st.Blocks - st.Bfree) * bsize,
+		Free:  st.Bavail * bsize,
+	}, nil
+}
+
+// databaseSize returns the size of the database the dsn connects to
+func databaseSize(ctx context.Context, dsn string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	db, err := sqlx.ConnectContext(ctx, "postgres", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var size int64
+	return size, db.GetContext(ctx, &size, "SELECT pg_database_size(current_database())")
+}
+
+// dirSize adds up the size of the regular files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// the file was rotated away while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
+
+// memory reads the total and available memory from /proc/meminfo
+func memory() (total, available uint64, err error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			available = kb * 1024
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if total == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+
+	return total, available, nil
+}
+
+// loadAverage reads the one, five and fifteen minute load averages from /proc/loadavg
+func loadAverage() ([3]float64, error) {
+	var load [3]float64
+
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("unexpected /proc/loadavg: %q", b)
+	}
+
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, err
+		}
+	}
+
+	return load, nil
+}
@@ -0,0 +1,73 @@
+package resources
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		name              string
+		value             float64
+		warning, critical float64
+		want              Level
+	}{
+		{name: "below", value: 50, warning: 80, critical: 95, want: OK},
+		{name: "at warning", value: 80, warning: 80, critical: 95, want: Warning},
+		{name: "between", value: 90, warning: 80, critical: 95, want: Warning},
+		{name: "at critical", value: 95, warning: 80, critical: 95, want: Critical},
+		{name: "above critical", value: 100, warning: 80, critical: 95, want: Critical},
+		{name: "no warning", value: 90, critical: 95, want: OK},
+		{name: "no critical", value: 100, warning: 80, want: Warning},
+		{name: "disabled", value: 100, want: OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := level(tt.value, tt.warning, tt.critical); got != tt.want {
+				t.Errorf("level(%v, %v, %v) = %s, want %s", tt.value, tt.warning, tt.critical, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDisk(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := statDisk("data", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Percent() == 0 {
+		t.Skip("the temporary filesystem is empty, so it can't be over any level")
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.Resources
+		path     string
+		wantErr  bool
+		critical bool
+	}{
+		{name: "below critical", cfg: config.Resources{DiskCritical: 100.1}, path: dir},
+		{name: "over warning", cfg: config.Resources{DiskWarning: d.Percent() / 2, DiskCritical: 100.1}, path: dir},
+		{name: "over critical", cfg: config.Resources{DiskCritical: d.Percent() / 2}, path: dir, wantErr: true, critical: true},
+		{name: "no thresholds", path: dir},
+		{name: "missing path", cfg: config.Resources{DiskCritical: 100.1}, path: filepath.Join(dir, "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckDisk(&tt.cfg, &config.Storage{Data: tt.path, Logs: dir})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrDiskCritical) != tt.critical {
+				t.Errorf("err = %v, want ErrDiskCritical %v", err, tt.critical)
+			}
+		})
+	}
+}
@@ -7,17 +7,21 @@ import (
 	"math/rand/v2"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/NYTimes/logrotate"
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/multi"
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
@@ -25,7 +29,12 @@ import (
 	"github.com/node-isp/node-isp/pkg/config"
 	"github.com/node-isp/node-isp/pkg/licence"
 	"github.com/node-isp/node-isp/pkg/logger"
+	"github.com/node-isp/node-isp/pkg/server/alerts"
+	"github.com/node-isp/node-isp/pkg/server/metrics"
+	"github.com/node-isp/node-isp/pkg/server/proxy"
+	"github.com/node-isp/node-isp/pkg/server/resources"
 	"github.com/node-isp/node-isp/pkg/server/service"
+	"github.com/node-isp/node-isp/pkg/server/tracing"
 	"github.com/node-isp/node-isp/pkg/server/webserver"
 	"github.com/node-isp/node-isp/pkg/updater"
 )
@@ -38,6 +47,24 @@ type Server struct {
 	Log    log.Interface
 
 	mgr *service.Manager
+
+	// dsn is the connection string for postgres, reachable from the host
+	dsn string
+
+	// redisPort is the local port the managed redis is bound to, for health checks
+	redisPort int
+
+	// proxy serves the app, it is nil until the services have started
+	proxy atomic.Pointer[proxy.Proxy]
+
+	// stateMu stops the state file being written by two goroutines at once
+	stateMu sync.Mutex
+
+	// cronFailures counts the runs of the app's scheduler in a row that have failed, for alerts
+	cronFailures atomic.Int64
+
+	// stopTracing flushes the spans that haven't been sent yet
+	stopTracing func(context.Context) error
 }
 
 var proxyHost *url.URL
@@ -72,8 +99,8 @@ func Run() error {
 
 func (s *Server) Run() {
 	s.Log.Info("starting Node ISP")
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	// Create a docker client
 	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
@@ -116,101 +143,149 @@ func (s *Server) Run() {
 
 	s.mgr = mgr
 
+	// Show the maintenance page while a container is replaced, such as during an update
+	mgr.BeforeReplace = s.holdMaintenance
+
+	// The services start on a nearly full disk anyway, as they're needed to free space, but updates are refused
+	if err := resources.CheckDisk(s.Config.Resources, s.Config.Storage); err != nil {
+		s.Log.WithError(err).Warn("Updates are refused until disk space is freed")
+	}
+
 	// Load any stored state, allowing us to use the same state between restarts instead of starting from scratch
 	// If an image has been changed, it will not use the default from below this way
 	if err := s.loadState(); err != nil {
 		s.Log.WithError(err).Warn("failed to load state")
 	}
 
-	// Redis
-	redisData := absolutePath(filepath.Join(s.Config.Storage.Data, "redis"))
-	mkdir(redisData)
-
-	var redis *service.Service
+	// Redis, either an external server or the managed container
+	redisHost, redisPort := s.Config.Redis.Host, s.Config.Redis.Port
 
-	// If we have the service already, use the image from the state
-	if _, ok := mgr.Services["redis"]; ok {
-		redis = mgr.Services["redis"]
+	if s.Config.Redis.External() {
+		s.removeManagedService(ctx, "redis")
 	} else {
-		redis = &service.Service{
-			Name:  "redis",
-			Image: "redis:7",
+		localPort := randomFreePort()
+		redisData := absolutePath(filepath.Join(s.Config.Storage.Data, "redis"))
+		mkdir(redisData)
+
+		var redis *service.Service
+
+		// If we have the service already, use the image and local port from the state
+		if _, ok := mgr.Services["redis"]; ok {
+			redis = mgr.Services["redis"]
+			if b := redis.PortBindings["6379/tcp"]; len(b) > 0 {
+				if localPort, err = strconv.Atoi(b[0].HostPort); err != nil {
+					s.Log.WithError(err).Fatal("Failed to get redis port")
+				}
+			}
+		} else {
+			redis = &service.Service{
+				Name:  "redis",
+				Image: "redis:7",
+			}
 		}
-	}
 
-	// We don't care about these from the state, we just really want the image
-	redis.Mounts = []mount.Mount{
-		{
-			Type:   mount.TypeBind,
-			Source: redisData,
-			Target: "/data",
-		},
-	}
-	redis.Env = []string{
-		"REDIS_PORT=6379",
-		"REDIS_PASSWORD=" + s.Config.Redis.Password,
-	}
+		// We don't care about these from the state, we just really want the image
+		redis.Mounts = []mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: redisData,
+				Target: "/data",
+			},
+		}
 
-	// EnsureService waits for the service to be running and fails if it can't start
-	if err := mgr.EnsureService(ctx, redis); err != nil {
-		s.Log.WithError(err).Fatal("Failed to start redis")
-	}
+		// Bind redis to a random local port, so the health checks can reach it
+		redis.PortBindings = map[nat.Port][]nat.PortBinding{
+			"6379/tcp": {{HostIP: "127.0.0.1", HostPort: fmt.Sprintf("%d", localPort)}},
+		}
 
-	// Postgres
-	postgresPort := randomFreePort()
-	postgresData := absolutePath(filepath.Join(s.Config.Storage.Data, "postgres"))
-	mkdir(postgresData)
+		redis.Env = []string{
+			"REDIS_PORT=6379",
+			"REDIS_PASSWORD=" + s.Config.Redis.Password,
+		}
 
-	var postgres *service.Service
+		if err := applyOverrides(redis, s.Config.Services.Override("redis")); err != nil {
+			s.Log.WithError(err).Fatal("Failed to apply redis overrides")
+		}
 
-	// If we have the service already, use the image from the state
-	if _, ok := mgr.Services["postgres"]; ok {
-		postgres = mgr.Services["postgres"]
-		postgresPort, err = strconv.Atoi(postgres.PortBindings["5432/tcp"][0].HostPort)
-		if err != nil {
-			s.Log.WithError(err).Fatal("Failed to get postgres port")
+		// EnsureService waits for the service to be running and fails if it can't start
+		if err := mgr.EnsureService(ctx, redis); err != nil {
+			s.Log.WithError(err).Fatal("Failed to start redis")
 		}
+
+		redisHost, redisPort = redis.GetName(), 6379
+		s.redisPort = localPort
+	}
+
+	// Postgres, either an external server or the managed container. The managed container is bound to a random
+	// local port, so we can query it from the licence client
+	dbHost, dbPort := s.Config.Database.Host, s.Config.Database.Port
+	s.dsn = s.Config.Database.DSN(dbHost, dbPort)
+
+	if s.Config.Database.External() {
+		s.removeManagedService(ctx, "postgres")
 	} else {
-		postgres = &service.Service{
-			Name:  "postgres",
-			Image: "postgres:16",
+		postgresPort := randomFreePort()
+		postgresData := absolutePath(filepath.Join(s.Config.Storage.Data, "postgres"))
+		mkdir(postgresData)
+
+		var postgres *service.Service
+
+		// If we have the service already, use the image from the state
+		if _, ok := mgr.Services["postgres"]; ok {
+			postgres = mgr.Services["postgres"]
+			postgresPort, err = strconv.Atoi(postgres.PortBindings["5432/tcp"][0].HostPort)
+			if err != nil {
+				s.Log.WithError(err).Fatal("Failed to get postgres port")
+			}
+		} else {
+			postgres = &service.Service{
+				Name:  "postgres",
+				Image: "postgres:16",
+			}
 		}
-	}
 
-	postgres.Mounts = []mount.Mount{
-		{
-			Type:   mount.TypeBind,
-			Source: postgresData,
-			Target: "/var/lib/postgresql/data",
-		},
-	}
+		postgres.Mounts = []mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: postgresData,
+				Target: "/var/lib/postgresql/data",
+			},
+		}
 
-	// Bind postgres to a random port, so we can query it from the licence client
-	postgres.PortBindings = map[nat.Port][]nat.PortBinding{
-		"5432/tcp": {{HostIP: "127.0.0.1", HostPort: fmt.Sprintf("%d", postgresPort)}},
-	}
+		// Bind postgres to a random port, so we can query it from the licence client
+		postgres.PortBindings = map[nat.Port][]nat.PortBinding{
+			"5432/tcp": {{HostIP: "127.0.0.1", HostPort: fmt.Sprintf("%d", postgresPort)}},
+		}
 
-	postgres.Env = []string{
-		"POSTGRES_USER=postgres",
-		"POSTGRES_PASSWORD=" + s.Config.Database.Password,
-		"POSTGRES_DB=" + s.Config.Database.Name,
-	}
+		postgres.Env = []string{
+			"POSTGRES_USER=" + s.Config.Database.User,
+			"POSTGRES_PASSWORD=" + s.Config.Database.Password,
+			"POSTGRES_DB=" + s.Config.Database.Name,
+		}
+
+		if err := applyOverrides(postgres, s.Config.Services.Override("postgres")); err != nil {
+			s.Log.WithError(err).Fatal("Failed to apply postgres overrides")
+		}
 
-	if err := mgr.EnsureService(ctx, postgres); err != nil {
-		s.Log.WithError(err).Fatal("Failed to start postgres")
+		if err := mgr.EnsureService(ctx, postgres); err != nil {
+			s.Log.WithError(err).Fatal("Failed to start postgres")
+		}
+
+		dbHost, dbPort = postgres.GetName(), 5432
+		s.dsn = s.Config.Database.DSN("127.0.0.1", postgresPort)
 	}
 
-	// Gotenberg
-	var gotenberg *service.Service
+	s.checkExternalServices(ctx)
 
-	// If we have the service already, use the image from the state
-	if _, ok := mgr.Services["gotenberg"]; ok {
-		gotenberg = mgr.Services["gotenberg"]
-	} else {
-		gotenberg = &service.Service{
-			Name:  "gotenberg",
-			Image: "getlago/lago-gotenberg:7",
-		}
+	// Gotenberg has nothing in the state worth keeping, so it's built from the defaults each time. Otherwise
+	// the overrides would be applied on top of the ones from the last start.
+	gotenberg := &service.Service{
+		Name:  "gotenberg",
+		Image: "getlago/lago-gotenberg:7",
+	}
+
+	if err := applyOverrides(gotenberg, s.Config.Services.Override("gotenberg")); err != nil {
+		s.Log.WithError(err).Fatal("Failed to apply gotenberg overrides")
 	}
 
 	if err := mgr.EnsureService(ctx, gotenberg); err != nil {
@@ -236,8 +311,11 @@ func (s *Server) Run() {
 		}
 
 		// Get the app version, and set it in the updater
-		ver, _ := strings.CutPrefix(appServer.Image, fmt.Sprintf("%s:", bakedAppRepo))
-		updater.CurrentAppVersion = ver
+		image := appServer.Image
+		if appServer.BaseImage != "" {
+			image = appServer.BaseImage
+		}
+		updater.CurrentAppVersion = imageVersion(image)
 	} else {
 		appServer = &service.Service{
 			Name:  "app",
@@ -246,12 +324,12 @@ func (s *Server) Run() {
 		updater.CurrentAppVersion = bakedAppVersion
 	}
 
-	appDomain := s.Config.HTTPServer.Domains[0]
+	appDomain := s.Config.HTTPServer.PrimaryDomain()
 	appUrl := fmt.Sprintf("https://%s", appDomain)
 	proxyHost, err = url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
 
 	appServer.Env = []string{
-		"APP_VERSION=" + bakedAppVersion,
+		"APP_VERSION=" + updater.CurrentAppVersion,
 		"SERVER_NAME=:8080",
 		"APP_ENV=production",
 		"APP_NAME=" + s.Config.App.Name,
@@ -263,14 +341,14 @@ func (s *Server) Run() {
 		"NODEISP_DOMAIN=" + appDomain,
 
 		"DB_CONNECTION=pgsql",
-		"DB_HOST=" + postgres.GetName(),
-		"DB_PORT=5432",
-		"DB_USERNAME=postgres",
+		"DB_HOST=" + dbHost,
+		"DB_PORT=" + strconv.Itoa(dbPort),
+		"DB_USERNAME=" + s.Config.Database.User,
 		"DB_PASSWORD=" + s.Config.Database.Password,
 		"DB_DATABASE=" + s.Config.Database.Name,
 
-		"REDIS_HOST=" + redis.GetName(),
-		"REDIS_PORT=6379",
+		"REDIS_HOST=" + redisHost,
+		"REDIS_PORT=" + strconv.Itoa(redisPort),
 
 		"CACHE_DRIVER=file",
 		"QUEUE_CONNECTION=redis",
@@ -302,6 +380,50 @@ func (s *Server) Run() {
 		},
 	}
 
+	if s.Config.Mail.Configured() {
+		if err := s.Config.Mail.Validate(); err != nil {
+			s.Log.WithError(err).Fatal("Invalid mail configuration")
+		}
+
+		appServer.Env = append(appServer.Env, s.Config.Mail.Env(s.Config.App.Name)...)
+	}
+
+	// External servers need their connection settings, and CA bundles mounted into the app
+	if s.Config.Database.External() {
+		appServer.Env = append(appServer.Env, "DB_SSLMODE="+s.Config.Database.SSL())
+
+		if s.Config.Database.CACert != "" {
+			appServer.Env = append(appServer.Env, "DB_SSLROOTCERT=/etc/ssl/nodeisp/postgres-ca.pem")
+			appServer.Mounts = append(appServer.Mounts, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   absolutePath(s.Config.Database.CACert),
+				Target:   "/etc/ssl/nodeisp/postgres-ca.pem",
+				ReadOnly: true,
+			})
+		}
+	}
+
+	if s.Config.Redis.External() {
+		appServer.Env = append(appServer.Env,
+			"REDIS_USERNAME="+s.Config.Redis.User,
+			"REDIS_PASSWORD="+s.Config.Redis.Password,
+		)
+
+		if s.Config.Redis.TLS {
+			appServer.Env = append(appServer.Env, "REDIS_SCHEME=tls")
+		}
+
+		if s.Config.Redis.CACert != "" {
+			appServer.Env = append(appServer.Env, "REDIS_CA_CERT=/etc/ssl/nodeisp/redis-ca.pem")
+			appServer.Mounts = append(appServer.Mounts, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   absolutePath(s.Config.Redis.CACert),
+				Target:   "/etc/ssl/nodeisp/redis-ca.pem",
+				ReadOnly: true,
+			})
+		}
+	}
+
 	appServer.PortBindings = map[nat.Port][]nat.PortBinding{
 		"8080/tcp": {{HostIP: "127.0.0.1", HostPort: fmt.Sprintf("%d", port)}},
 	}
@@ -312,6 +434,15 @@ func (s *Server) Run() {
 
 	appServer.Entrypoint = []string{"php", "artisan", "octane:start", "--host=0.0.0.0", "--port=8080"}
 
+	if err := applyOverrides(appServer, s.Config.Services.Override("app")); err != nil {
+		s.Log.WithError(err).Fatal("Failed to apply app server overrides")
+	}
+
+	// A pinned image may not be from the baked repository, so take the version from the tag
+	if s.Config.Services.Override("app") != nil && s.Config.Services.Override("app").Image != "" {
+		updater.CurrentAppVersion = imageVersion(appServer.Image)
+	}
+
 	if err := mgr.EnsureService(ctx, appServer); err != nil {
 		s.Log.WithError(err).Fatal("Failed to start app server")
 	}
@@ -325,6 +456,10 @@ func (s *Server) Run() {
 		Entrypoint: []string{"/entrypoint-worker.sh"},
 	}
 
+	if err := applyOverrides(worker, s.Config.Services.Override("horizon")); err != nil {
+		s.Log.WithError(err).Fatal("Failed to apply horizon overrides")
+	}
+
 	if err := mgr.EnsureService(ctx, worker); err != nil {
 		s.Log.WithError(err).Fatal("Failed to start worker")
 	}
@@ -332,52 +467,105 @@ func (s *Server) Run() {
 	// Start a thread to run the crons every minute
 	_ = s.storeState()
 
-	// Run all the crons every minute, being sure not to block the cron thread
+	cronsDone := make(chan struct{})
 	go func() {
-		for {
-			go func() {
-				err := s.storeState()
-				if err != nil {
-					s.Log.WithError(err).Error("Failed to store state")
-				}
-			}()
-			go func() {
-				if err := mgr.RunCommand(ctx, appServer, []string{"php", "artisan", "schedule:run"}); err != nil {
-					s.Log.WithError(err).Error("Failed to run cron")
-				}
-			}()
-			time.Sleep(1 * time.Minute)
-		}
+		defer close(cronsDone)
+		s.runCrons(ctx)
 	}()
 
 	// Start the stats reporter
 	if licenceClient != nil {
-		if err := licenceClient.StartStatsReporter(
-			"127.0.0.1",
-			fmt.Sprintf("%d", postgresPort),
-			"postgres",
-			s.Config.Database.Password,
-			s.Config.Database.Name,
-		); err != nil {
+		if err := licenceClient.StartStatsReporter(s.dsn); err != nil {
 			s.Log.WithError(err).Fatal("Failed to start stats reporter")
 		}
 	}
 
+	// Trace the proxy and the management API, before they start handling requests
+	if s.stopTracing, err = tracing.Start(s.Config.Tracing, s.Log.WithField("component", "tracing")); err != nil {
+		s.Log.WithError(err).Fatal("Invalid tracing config")
+	}
+
 	// Start the HTTP and HTTPS proxy
-	mux := s.setupProxy()
+	accessLog, err := proxy.NewAccessLog(s.Config.HTTPServer.AccessLog, absolutePath(s.Config.Storage.Logs))
+	if err != nil {
+		s.Log.WithError(err).Fatal("Failed to open access log")
+	}
+
+	// Uploaded files are served from disk, rather than through the app
+	static := []config.StaticPath{{Path: "/storage", Dir: appStorage}}
+
+	appProxy, err := proxy.New(proxyHost, s.Config.HTTPServer.Proxy, static, accessLog, s.Log.WithField("component", "proxy"))
+	if err != nil {
+		s.Log.WithError(err).Fatal("Invalid proxy config")
+	}
+
+	if err := loadMaintenance(s.Config.Storage.Data, appProxy); err != nil {
+		s.Log.WithError(err).Error("Failed to load maintenance state")
+	}
+
+	s.proxy.Store(appProxy)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", appProxy)
+
+	// Health checks are answered by NodeISP itself, so they work while the app is down
+	health := &healthHandler{s: s, proxy: appProxy, licence: licenceClient, log: s.Log.WithField("component", "health")}
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+
+	if addr := s.Config.HTTPServer.Health.Listen; addr != "" {
+		go func() {
+			if err := serveHealth(ctx, addr, health, s.Log.WithField("component", "health")); err != nil {
+				s.Log.WithError(err).Error("Failed to serve health checks")
+			}
+		}()
+	}
 	ws := webserver.New(
 		mux,
 		s.Config.Storage.Data,
-		s.Config.HTTPServer.Domains,
-		s.Config.HTTPServer.TLS.Email,
+		s.Config.HTTPServer,
 		s.Log.WithField("component", "webserver"),
 	)
 
 	go ws.Run(ctx)
 
+	// Watch the disks, database size, memory and load
+	monitor := resources.New(s.Config.Resources, s.Config.Storage, s.dsn, s.Log.WithField("component", "resources"))
+	go monitor.Run(ctx)
+
+	// Export metrics for Prometheus
+	if !strings.EqualFold(s.Config.Metrics.Listen, "off") {
+		appProxy.SetRouteGroups(s.Config.Metrics.RouteGroups)
+		metrics.Register(metrics.Sources{Manager: mgr, Licence: licenceClient, WebServer: ws, Resources: monitor}, s.Log.WithField("component", "metrics"))
+
+		go func() {
+			if err := metrics.Serve(ctx, s.Config.Metrics.Listen, s.Log.WithField("component", "metrics")); err != nil {
+				s.Log.WithError(err).Error("Failed to serve metrics")
+			}
+		}()
+	}
+
 	// Start the updater in the background
 	u := &updater.Updater{}
 
+	// Alert when something needs attention
+	alerter, err := alerts.New(s.Config.Alerts, s.Config.Mail, appDomain, alerts.Sources{
+		Manager:      mgr,
+		Licence:      licenceClient,
+		WebServer:    ws,
+		Resources:    monitor,
+		CronFailures: func() int { return int(s.cronFailures.Load()) },
+	}, s.Log.WithField("component", "alerts"))
+	if err != nil {
+		s.Log.WithError(err).Fatal("Invalid alerts config")
+	}
+
+	if s.Config.Alerts.Configured() {
+		go alerter.Run(ctx)
+	}
+
+	metrics.UpdateAvailable.WithLabelValues("app").Set(0)
+
 	updates := make(<-chan updater.Update)
 	go func() {
 		updates, err = u.Start()
@@ -388,8 +576,19 @@ func (s *Server) Run() {
 		for {
 			select {
 			case update := <-updates:
+				metrics.UpdateAvailable.WithLabelValues(update.Component).Set(1)
+
 				if update.Component == "app" {
-					// TODO: Notify the user, and upgrade the app
+					l := s.Log.WithField("version", update.Version.Original())
+					l.Info("Updating the app")
+
+					if err := s.updateApp(ctx, update.Version.Original()); err != nil {
+						l.WithError(err).Error("Not updating the app")
+						continue
+					}
+
+					metrics.UpdateAvailable.WithLabelValues(update.Component).Set(0)
+					l.Info("Updated the app")
 				}
 			}
 		}
@@ -397,34 +596,257 @@ func (s *Server) Run() {
 
 	log.WithField("component", "server").
 		WithField("internalHost", proxyHost).
-		WithField("AdminURL", fmt.Sprintf("https://%s/admin", s.Config.HTTPServer.Domains[0])).
+		WithField("AdminURL", fmt.Sprintf("https://%s/admin", appDomain)).
 		Info("Node ISP is running")
 
 	// TODO: GRPC server for CLI, with version upgrades and stuff
-	// TODO: Metrics server fun time Let the client daemon get stats, and check for updates, and push updated images
 
 	// start GRPC server
 	grpc := &grpcServer{
 		docker: docker,
 		mgr:    mgr,
 		u:      u,
+		cfg:    s.Config,
+		dsn:    s.dsn,
+		proxy:  appProxy,
+		alerts: alerter,
+		ws:     ws,
+
+		resources: monitor,
 	}
 
-	go grpc.Run()
+	if err := grpc.Run(); err != nil {
+		s.Log.WithError(err).Error("Failed to start gRPC server")
+	}
 
 	<-ctx.Done()
-	s.Log.Info("shutting down Node ISP")
+
+	// A second signal kills the daemon straight away, rather than waiting for the shutdown
+	stop()
+
+	s.Log.WithField("timeout", s.Config.Shutdown.Timeout).Info("shutting down Node ISP")
+	s.shutdown(ws, grpc, cronsDone)
+	s.Log.Info("Node ISP stopped")
+}
+
+// updateApp replaces the app and horizon containers with a version of the app image. The maintenance page is
+// shown while they are replaced, and readiness fails, so a load balancer can drain the host.
+func (s *Server) updateApp(ctx context.Context, version string) error {
+	if o := s.Config.Services.Override("app"); o != nil && o.Image != "" {
+		return fmt.Errorf("the app image is pinned to %s in the config", o.Image)
+	}
+
+	// Pulling a new image on a nearly full disk can fill it, and take postgres down with it
+	if err := resources.CheckDisk(s.Config.Resources, s.Config.Storage); err != nil {
+		return err
+	}
+
+	image := fmt.Sprintf("%s:%s", bakedAppRepo, version)
+
+	// Horizon runs the app image too, unless it has its own pinned
+	for _, name := range []string{"app", "horizon"} {
+		current := s.mgr.Service(name)
+		if current == nil {
+			return fmt.Errorf("the %s server is not running", name)
+		}
+
+		if o := s.Config.Services.Override(name); name == "horizon" && o != nil && o.Image != "" {
+			continue
+		}
+
+		// The service is replaced in the manager rather than changed in place, as it is read while this runs
+		updated := &service.Service{
+			Name:         current.Name,
+			Image:        image,
+			BaseImage:    image,
+			Mounts:       current.Mounts,
+			Env:          mergeEnv(current.Env, []string{"APP_VERSION=" + version}),
+			PortBindings: current.PortBindings,
+			ExposedPorts: current.ExposedPorts,
+			Entrypoint:   current.Entrypoint,
+		}
+
+		if err := s.mgr.EnsureService(ctx, updated); err != nil {
+			return fmt.Errorf("failed to replace the %s server: %w", name, err)
+		}
+	}
+
+	updater.CurrentAppVersion = version
+
+	return s.storeState()
+}
+
+// holdMaintenance shows the maintenance page while a service's container is replaced. Containers replaced while
+// starting up, before the proxy exists, have nobody to show it to.
+func (s *Server) holdMaintenance(string) (release func()) {
+	p := s.proxy.Load()
+	if p == nil {
+		return func() {}
+	}
+
+	return p.HoldMaintenance("We're updating the system, and will be back in a few minutes.")
+}
+
+// runCrons runs the app's scheduler every minute until the context is done, being sure not to block on it. The
+// commands themselves keep running after that, and are waited for by shutdown.
+func (s *Server) runCrons(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	jobCtx := context.WithoutCancel(ctx)
+
+	for {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.storeState(); err != nil {
+				s.Log.WithError(err).Error("Failed to store state")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.runSchedule(jobCtx)
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runSchedule runs the app's scheduler once, recording how long it took and whether it failed. The app is looked
+// up each time, as an update replaces it.
+func (s *Server) runSchedule(ctx context.Context) {
+	cmd := []string{"php", "artisan", "schedule:run"}
+
+	appServer := s.mgr.Service("app")
+	if appServer == nil {
+		s.cronFailures.Add(1)
+		s.Log.Error("Failed to run cron, the app server is not running")
+		return
+	}
+
+	start := time.Now()
+	out, code, err := s.mgr.Exec(ctx, appServer, cmd, nil)
+	metrics.ObserveCron(time.Since(start), err != nil || code != 0)
+
+	if err != nil || code != 0 {
+		s.cronFailures.Add(1)
+	} else {
+		s.cronFailures.Store(0)
+	}
+
+	switch {
+	case err != nil:
+		s.Log.WithError(err).Error("Failed to run cron")
+	case code != 0:
+		s.Log.WithField("exit_code", code).Error("Cron failed")
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			s.Log.WithField("command", cmd).Debug(line)
+		}
+	}
 }
 
+// shutdown drains the web and gRPC servers, waits for the running commands and stores the state, giving up on
+// whatever is left once the shutdown timeout has passed
+func (s *Server) shutdown(ws *webserver.WebServer, grpc *grpcServer, cronsDone <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.Shutdown.Timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		if err := ws.Shutdown(ctx); err != nil {
+			s.Log.WithError(err).Warn("Web server did not drain in time")
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		grpc.Stop(ctx)
+	}()
+
+	wg.Wait()
+
+	select {
+	case <-cronsDone:
+	case <-ctx.Done():
+	}
+
+	if err := s.mgr.Wait(ctx); err != nil {
+		s.Log.WithError(err).Warn("Running commands did not finish in time")
+	}
+
+	if err := s.storeState(); err != nil {
+		s.Log.WithError(err).Error("Failed to store state")
+	}
+
+	if err := s.stopTracing(ctx); err != nil {
+		s.Log.WithError(err).Warn("Failed to send the remaining traces")
+	}
+
+	if !s.Config.Shutdown.StopContainers {
+		return
+	}
+
+	// Containers get their own stop timeout from docker, so this isn't limited by the one above
+	if err := s.mgr.StopServices(context.Background()); err != nil {
+		s.Log.WithError(err).Error("Failed to stop containers")
+	}
+}
+
+// removeManagedService removes a managed container that has been replaced by an external server. The data
+// directory is left in place, so switching back to the managed container is possible.
+func (s *Server) removeManagedService(ctx context.Context, name string) {
+	if err := s.mgr.RemoveService(ctx, name); err != nil {
+		s.Log.WithError(err).WithField("service", name).Error("Failed to remove managed container")
+	}
+}
+
+// checkExternalServices logs whether the external postgres and redis servers are reachable
+func (s *Server) checkExternalServices(ctx context.Context) {
+	if s.Config.Database.External() {
+		if err := checkPostgres(ctx, s.dsn); err != nil {
+			s.Log.WithError(err).WithField("host", s.Config.Database.Host).Error("External postgres is unreachable")
+		} else {
+			s.Log.WithField("host", s.Config.Database.Host).Info("using external postgres")
+		}
+	}
+
+	if s.Config.Redis.External() {
+		if err := checkRedis(ctx, s.Config.Redis, s.Config.Redis.Host, s.Config.Redis.Port); err != nil {
+			s.Log.WithError(err).WithField("host", s.Config.Redis.Host).Error("External redis is unreachable")
+		} else {
+			s.Log.WithField("host", s.Config.Redis.Host).Info("using external redis")
+		}
+	}
+}
+
+// storeState writes the manager state to a temporary file and renames it into place, so it is never left half
+// written when the daemon is stopped
 func (s *Server) storeState() error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
 	path := filepath.Join(s.Config.Storage.Data, "state.json")
 
-	f, err := os.Create(path)
+	f, err := os.CreateTemp(filepath.Dir(path), ".state-*.json")
 	if err != nil {
 		s.Log.WithError(err).Error("Failed to create state file")
 		return err
 	}
 
+	defer os.Remove(f.Name())
 	defer f.Close()
 
 	enc := json.NewEncoder(f)
@@ -435,6 +857,18 @@ func (s *Server) storeState() error {
 		return err
 	}
 
+	if err := f.Close(); err != nil {
+		s.Log.WithError(err).Error("Failed to write state file")
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		s.Log.WithError(err).Error("Failed to write state file")
+		return err
+	}
+
+	metrics.StateWritten.SetToCurrentTime()
+
 	return nil
 }
 
@@ -449,21 +883,23 @@ func (s *Server) loadState() error {
 	return json.Unmarshal(f, s.mgr)
 }
 
-func (s *Server) setupProxy() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.Handle("/", &httputil.ReverseProxy{Director: func(req *http.Request) {
-		targetQuery := proxyHost.RawQuery
-		req.URL.Scheme = proxyHost.Scheme
-		req.URL.Host = proxyHost.Host
-		req.URL.Path, req.URL.RawPath = joinURLPath(proxyHost, req.URL)
-		if targetQuery == "" || req.URL.RawQuery == "" {
-			req.URL.RawQuery = targetQuery + req.URL.RawQuery
-		} else {
-			req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
-		}
-	}})
+// imageVersion returns the tag of an image reference, or the digest for an image pinned by digest only. Docker
+// pulls latest for an image with neither.
+func imageVersion(image string) string {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
 
-	return mux
+	if tagged, ok := ref.(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+
+	if digested, ok := ref.(reference.Digested); ok {
+		return digested.Digest().String()
+	}
+
+	return "latest"
 }
 
 func mkdir(path string) {
@@ -500,35 +936,3 @@ func randomFreePort() int {
 
 	return port
 }
-
-func joinURLPath(a, b *url.URL) (path, rawpath string) {
-	if a.RawPath == "" && b.RawPath == "" {
-		return singleJoiningSlash(a.Path, b.Path), ""
-	}
-	// Same as singleJoiningSlash, but uses EscapedPath to determine
-	// whether a slash should be added
-	apath := a.EscapedPath()
-	bpath := b.EscapedPath()
-
-	aslash := strings.HasSuffix(apath, "/")
-	bslash := strings.HasPrefix(bpath, "/")
-
-	switch {
-	case aslash && bslash:
-		return a.Path + b.Path[1:], apath + bpath[1:]
-	case !aslash && !bslash:
-		return a.Path + "/" + b.Path, apath + "/" + bpath
-	}
-	return a.Path + b.Path, apath + bpath
-}
-func singleJoiningSlash(a, b string) string {
-	aslash := strings.HasSuffix(a, "/")
-	bslash := strings.HasPrefix(b, "/")
-	switch {
-	case aslash && bslash:
-		return a + b[1:]
-	case !aslash && !bslash:
-		return a + "/" + b
-	}
-	return a + b
-}
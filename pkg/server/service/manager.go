@@ -3,10 +3,12 @@ package service
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -36,6 +38,16 @@ type Manager struct {
 
 	// Services is a list of Services that the manager manages
 	Services map[string]*Service `json:"services"`
+
+	// started is the order services were added in, so they are stopped in reverse
+	started []string
+
+	// jobs are the commands running in containers, waited on during shutdown
+	jobs sync.WaitGroup
+
+	// BeforeReplace is called before a service's container is replaced, such as for an update, and the function
+	// it returns once the new container has started
+	BeforeReplace func(service string) (done func()) `json:"-"`
 }
 
 // New creates a new service manager
@@ -87,12 +99,74 @@ func (m *Manager) EnsureService(ctx context.Context, s *Service) error {
 	s.log = m.log.WithField("service", s.GetName())
 
 	m.mu.Lock()
+	if _, ok := m.Services[s.Name]; !ok {
+		m.started = append(m.started, s.Name)
+	}
 	m.Services[s.Name] = s
 	m.mu.Unlock()
 
 	return m.ensureRunning(ctx, s.Name)
 }
 
+// Service returns the named service, or nil if the manager doesn't have it
+func (m *Manager) Service(name string) *Service {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.Services[name]
+}
+
+// ServiceList returns the services sorted by name, as they are now. Services changed later are replaced in the
+// manager, rather than changed in place.
+func (m *Manager) ServiceList() []*Service {
+	m.mu.Lock()
+	services := make([]*Service, 0, len(m.Services))
+	for _, svc := range m.Services {
+		services = append(services, svc)
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(services, func(a, b *Service) int { return strings.Compare(a.Name, b.Name) })
+
+	return services
+}
+
+// MarshalJSON encodes the manager for the state file, while no services are being added or removed
+func (m *Manager) MarshalJSON() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// manager has none of the methods, so it encodes with the default encoding
+	type manager Manager
+	return json.Marshal((*manager)(m))
+}
+
+// RemoveService stops and removes all containers for a service, and removes it from the manager
+func (m *Manager) RemoveService(ctx context.Context, name string) error {
+	containers, err := m.d.ContainerList(ctx, container.ListOptions{All: true, Filters: filters.NewArgs(
+		filters.Arg("label", "app=nodeisp"),
+		filters.Arg("label", "service="+name),
+	)})
+	if err != nil {
+		return err
+	}
+
+	for _, ctr := range containers {
+		if err := m.d.ContainerRemove(ctx, ctr.ID, container.RemoveOptions{Force: true}); err != nil {
+			return err
+		}
+
+		m.log.WithField("service", name).WithField("container", ctr.ID).Info("removed container")
+	}
+
+	m.mu.Lock()
+	delete(m.Services, name)
+	m.started = slices.DeleteFunc(m.started, func(s string) bool { return s == name })
+	m.mu.Unlock()
+
+	return nil
+}
+
 // ListContainers lists all containers from the manager
 func (m *Manager) ListContainers(ctx context.Context) ([]types.Container, error) {
 	return m.d.ContainerList(ctx, container.ListOptions{All: true, Filters: filters.NewArgs(
@@ -101,8 +175,8 @@ func (m *Manager) ListContainers(ctx context.Context) ([]types.Container, error)
 }
 
 func (m *Manager) ensureRunning(ctx context.Context, name string) error {
-	svc, ok := m.Services[name]
-	if !ok {
+	svc := m.Service(name)
+	if svc == nil {
 		return errors.New("service not found")
 	}
 
@@ -133,6 +207,11 @@ func (m *Manager) ensureRunning(ctx context.Context, name string) error {
 		svc.log.WithField("container", c.ID).WithField("state", c.State).Info("found existing container")
 	}
 
+	if c == nil && m.BeforeReplace != nil {
+		done := m.BeforeReplace(svc.Name)
+		defer done()
+	}
+
 	// Delete any old containers, if they exist
 	for _, ctr := range containers {
 		// As long as the container is not the one we want to keep, delete it
@@ -199,7 +278,7 @@ func (m *Manager) ensureRunning(ctx context.Context, name string) error {
 		}, &network.NetworkingConfig{}, platformOptions, svc.GetName())
 
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		c = &types.Container{ID: resp.ID, State: "created"}
@@ -258,9 +337,48 @@ func (m *Manager) ensureRunning(ctx context.Context, name string) error {
 	return nil
 }
 
+// Exec runs a command in the service's container and waits for it to finish, returning the output and exit code
+func (m *Manager) Exec(ctx context.Context, server *Service, cmd []string, env []string) (string, int, error) {
+	server.log.WithField("command", cmd).Info("executing command")
+
+	m.jobs.Add(1)
+	defer m.jobs.Done()
+
+	exec, err := m.d.ContainerExecCreate(ctx, server.GetName(), container.ExecOptions{
+		Cmd:          cmd,
+		Env:          env,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, err := m.d.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{Tty: true})
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Close()
+
+	out, err := io.ReadAll(resp.Reader)
+	if err != nil {
+		return "", 0, err
+	}
+
+	inspect, err := m.d.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return string(out), 0, err
+	}
+
+	return string(out), inspect.ExitCode, nil
+}
+
 func (m *Manager) RunCommand(ctx context.Context, server *Service, cmd []string) error {
 	server.log.WithField("command", cmd).Info("running command")
 
+	m.jobs.Add(1)
+
 	exec, err := m.d.ContainerExecCreate(ctx, server.GetName(), container.ExecOptions{
 		Cmd:          cmd,
 		AttachStdout: true,
@@ -270,16 +388,21 @@ func (m *Manager) RunCommand(ctx context.Context, server *Service, cmd []string)
 	})
 
 	if err != nil {
+		m.jobs.Done()
 		return err
 	}
 
 	resp, err := m.d.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
 	if err != nil {
+		m.jobs.Done()
 		return err
 	}
 
-	// Read the output
+	// Read the output, the command is finished once it is closed
 	go func() {
+		defer m.jobs.Done()
+		defer resp.Close()
+
 		buf := new(strings.Builder)
 		_, err := io.Copy(buf, resp.Reader)
 		if err != nil {
@@ -298,3 +421,103 @@ func (m *Manager) RunCommand(ctx context.Context, server *Service, cmd []string)
 
 	return nil
 }
+
+// Wait blocks until the running commands have finished, or the context is done
+func (m *Manager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.jobs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopServices stops the containers of all services, in the reverse of the order they were added, so the app is
+// stopped before the databases it uses. The containers are kept, and started again by the next EnsureService.
+func (m *Manager) StopServices(ctx context.Context) error {
+	m.mu.Lock()
+	names := slices.Clone(m.started)
+	m.mu.Unlock()
+
+	var errs []error
+
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+
+		svc := m.Service(name)
+		if svc == nil {
+			continue
+		}
+
+		svc.log.Info("stopping container")
+
+		if err := m.d.ContainerStop(ctx, svc.GetName(), container.StopOptions{}); err != nil {
+			svc.log.WithError(err).Error("failed to stop container")
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// State is the current state of a service's container
+type State struct {
+	Service   string
+	Container string
+
+	// State is the docker state, such as running or exited, or missing if there is no container
+	State string
+
+	// Health is healthy, unhealthy or starting, and empty for containers without a healthcheck
+	Health string
+
+	Restarts  int
+	StartedAt time.Time
+}
+
+// States inspects the container of each service
+func (m *Manager) States(ctx context.Context) ([]State, error) {
+	ctrs, err := m.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+
+	for _, svc := range m.ServiceList() {
+		st := State{Service: svc.Name, State: "missing"}
+
+		for _, ctr := range ctrs {
+			if ctr.Labels["service"] == svc.Name && ctr.Labels["hash"] == svc.GetHash() {
+				st.Container = ctr.ID
+				break
+			}
+		}
+
+		if st.Container != "" {
+			inspect, err := m.d.ContainerInspect(ctx, st.Container)
+			if err != nil {
+				return nil, err
+			}
+
+			st.Container = strings.TrimPrefix(inspect.Name, "/")
+			st.State = inspect.State.Status
+			st.Restarts = inspect.RestartCount
+			st.StartedAt, _ = time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+
+			if inspect.State.Health != nil {
+				st.Health = inspect.State.Health.Status
+			}
+		}
+
+		states = append(states, st)
+	}
+
+	return states, nil
+}
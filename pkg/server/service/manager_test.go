@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	m := &Manager{}
+
+	if err := m.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() with no jobs = %v", err)
+	}
+
+	m.jobs.Add(1)
+
+	// Shutdown gives up on a job that is still running once its deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := m.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() with a running job = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.Wait(context.Background()) }()
+
+	m.jobs.Done()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() after the job finished = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() didn't return after the job finished")
+	}
+}
+
+func TestServiceList(t *testing.T) {
+	m := &Manager{Services: map[string]*Service{
+		"redis": {Name: "redis"},
+		"app":   {Name: "app"},
+	}}
+
+	var names []string
+	for _, svc := range m.ServiceList() {
+		names = append(names, svc.Name)
+	}
+	if strings.Join(names, ",") != "app,redis" {
+		t.Errorf("ServiceList() = %v, want sorted by name", names)
+	}
+
+	if m.Service("app") == nil || m.Service("postgres") != nil {
+		t.Error("Service() didn't look up by name")
+	}
+
+	// Readers run while services are changed, as gRPC calls and the state file do
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.mu.Lock()
+			m.Services[fmt.Sprint("svc", i)] = &Service{Name: fmt.Sprint("svc", i)}
+			m.mu.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			_ = m.ServiceList()
+			_ = m.Service("app")
+			if _, err := json.Marshal(m); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Manager{}
+	if err := json.Unmarshal(b, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Services) != 52 || loaded.Services["app"].Name != "app" {
+		t.Errorf("state has %d services", len(loaded.Services))
+	}
+}
@@ -33,6 +33,10 @@ type Service struct {
 	// Image is the docker image that the service runs
 	Image string `json:"image"`
 
+	// BaseImage is the image before the config's image override was applied, so removing the override goes
+	// back to it
+	BaseImage string `json:"base_image,omitempty"`
+
 	// Mounts is a list of volumes that the service mounts
 	Mounts []mount.Mount `json:"mounts"`
 
@@ -0,0 +1,300 @@
+// Package servicetest is a fake Docker Engine API, for testing the service manager and what uses it without a
+// docker daemon. It keeps just enough container state for the calls the manager makes.
+package servicetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Container is a container in the fake
+type Container struct {
+	ID     string
+	Name   string
+	Image  string
+	State  string
+	Labels map[string]string
+}
+
+// Docker serves the Docker Engine API from memory
+type Docker struct {
+	// Client is a docker client for the fake
+	Client *client.Client
+
+	// Pull, if set, is called with each image that is pulled, before the pull completes
+	Pull func(image string)
+
+	srv  *httptest.Server
+	done chan struct{}
+
+	mu         sync.Mutex
+	next       int
+	containers []*Container
+	pulls      []string
+}
+
+// version is the API version prefix the client adds to paths
+var version = regexp.MustCompile(`^/v[0-9.]+`)
+
+// NewDocker starts a fake docker daemon, which is stopped when the test ends
+func NewDocker(t *testing.T) *Docker {
+	t.Helper()
+
+	d := &Docker{done: make(chan struct{})}
+	d.srv = httptest.NewServer(http.HandlerFunc(d.serveHTTP))
+
+	// Container waits block until the test ends, so they are released before the server is closed
+	t.Cleanup(func() {
+		close(d.done)
+		d.srv.Close()
+	})
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+d.srv.Listener.Addr().String()), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Client = cli
+
+	return d
+}
+
+// AddContainer adds a container, as if it was left from an earlier run
+func (d *Docker) AddContainer(c Container) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if c.ID == "" {
+		d.next++
+		c.ID = fmt.Sprintf("container%d", d.next)
+	}
+
+	d.containers = append(d.containers, &c)
+}
+
+// Containers returns the containers as they are now
+func (d *Docker) Containers() []Container {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var cs []Container
+	for _, c := range d.containers {
+		cs = append(cs, *c)
+	}
+
+	return cs
+}
+
+// Pulls returns the images that have been pulled
+func (d *Docker) Pulls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.pulls...)
+}
+
+func (d *Docker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := version.ReplaceAllString(r.URL.Path, "")
+
+	switch {
+	case r.Method == http.MethodGet && path == "/networks":
+		writeJSON(w, http.StatusOK, []map[string]string{{"Id": "nodeisp", "Name": "nodeisp"}})
+	case r.Method == http.MethodGet && path == "/containers/json":
+		d.list(w, r)
+	case r.Method == http.MethodPost && path == "/images/create":
+		d.pull(w, r)
+	case r.Method == http.MethodPost && path == "/containers/create":
+		d.create(w, r)
+	case strings.HasPrefix(path, "/containers/"):
+		d.container(w, r, strings.Split(strings.TrimPrefix(path, "/containers/"), "/"))
+	default:
+		http.Error(w, "not implemented by the fake", http.StatusNotImplemented)
+	}
+}
+
+// list lists the containers, keeping those with every label in the filters
+func (d *Docker) list(w http.ResponseWriter, r *http.Request) {
+	var filter struct {
+		Label map[string]bool `json:"label"`
+	}
+	if f := r.URL.Query().Get("filters"); f != "" {
+		if err := json.Unmarshal([]byte(f), &filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var list []map[string]any
+	for _, c := range d.containers {
+		if !hasLabels(c.Labels, filter.Label) {
+			continue
+		}
+
+		list = append(list, map[string]any{
+			"Id":     c.ID,
+			"Names":  []string{"/" + c.Name},
+			"Image":  c.Image,
+			"State":  c.State,
+			"Labels": c.Labels,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func hasLabels(labels map[string]string, want map[string]bool) bool {
+	for l := range want {
+		k, v, _ := strings.Cut(l, "=")
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *Docker) pull(w http.ResponseWriter, r *http.Request) {
+	image := r.URL.Query().Get("fromImage")
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		image += ":" + tag
+	}
+
+	if d.Pull != nil {
+		d.Pull(image)
+	}
+
+	d.mu.Lock()
+	d.pulls = append(d.pulls, image)
+	d.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Downloaded newer image for " + image})
+}
+
+func (d *Docker) create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Image  string
+		Labels map[string]string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	d.next++
+	c := &Container{
+		ID:     fmt.Sprintf("container%d", d.next),
+		Name:   r.URL.Query().Get("name"),
+		Image:  body.Image,
+		State:  "created",
+		Labels: body.Labels,
+	}
+	d.containers = append(d.containers, c)
+	d.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]any{"Id": c.ID, "Warnings": []string{}})
+}
+
+// container handles the calls on one container, which is found by its ID or name
+func (d *Docker) container(w http.ResponseWriter, r *http.Request, parts []string) {
+	d.mu.Lock()
+	var c *Container
+	for _, ctr := range d.containers {
+		if ctr.ID == parts[0] || ctr.Name == parts[0] {
+			c = ctr
+		}
+	}
+	d.mu.Unlock()
+
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such container: " + parts[0]})
+		return
+	}
+
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "json":
+		d.mu.Lock()
+		inspect := map[string]any{
+			"Id":   c.ID,
+			"Name": "/" + c.Name,
+			"State": map[string]any{
+				"Status":    c.State,
+				"Running":   c.State == "running",
+				"StartedAt": time.Now().Format(time.RFC3339Nano),
+			},
+		}
+		d.mu.Unlock()
+
+		writeJSON(w, http.StatusOK, inspect)
+	case r.Method == http.MethodPost && action == "start":
+		d.setState(c, "running")
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && action == "stop":
+		d.setState(c, "exited")
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && action == "":
+		d.mu.Lock()
+		for i, ctr := range d.containers {
+			if ctr == c {
+				d.containers = append(d.containers[:i], d.containers[i+1:]...)
+				break
+			}
+		}
+		d.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && action == "wait":
+		// Docker sends the headers straight away, and the body once the container stops. The containers keep
+		// running until the test ends.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = http.NewResponseController(w).Flush()
+
+		select {
+		case <-d.done:
+		case <-r.Context().Done():
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"StatusCode": 0})
+	case r.Method == http.MethodPost && action == "attach":
+		// The container has no output, so the stream is closed straight after the upgrade
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = conn.Write([]byte("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n"))
+	default:
+		http.Error(w, "not implemented by the fake", http.StatusNotImplemented)
+	}
+}
+
+func (d *Docker) setState(c *Container, state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c.State = state
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Stats is a sample of a service container's resource usage, from docker's stats API
+type Stats struct {
+	Service   string
+	Container string
+	State     string
+
+	CPUPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+
+	NetworkRx  uint64
+	NetworkTx  uint64
+	BlockRead  uint64
+	BlockWrite uint64
+
+	PIDs uint64
+}
+
+// statsRescan is how often the containers are listed again while streaming, to pick up ones that have been
+// started or replaced
+const statsRescan = 10 * time.Second
+
+// StreamStats calls send with a sample for every service on each interval, until the context is done or send
+// returns an error. Services without a running container are sent with just their state.
+func (m *Manager) StreamStats(ctx context.Context, interval time.Duration, send func([]Stats) error) error {
+	// The streams are cancelled before they are waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		latest  = map[string]Stats{}
+		streams = map[string]bool{}
+		states  []State
+	)
+
+	scan := func() error {
+		sts, err := m.States(ctx)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		states = sts
+
+		for _, st := range sts {
+			if st.State != "running" || streams[st.Container] {
+				continue
+			}
+
+			streams[st.Container] = true
+			wg.Add(1)
+
+			go func(st State) {
+				defer wg.Done()
+
+				err := m.streamContainerStats(ctx, st.Container, func(s Stats) {
+					s.Service, s.Container, s.State = st.Service, st.Container, st.State
+
+					mu.Lock()
+					latest[st.Container] = s
+					mu.Unlock()
+				})
+				if err != nil && ctx.Err() == nil {
+					m.log.WithField("container", st.Container).WithError(err).Warn("stopped reading container stats")
+				}
+
+				mu.Lock()
+				delete(streams, st.Container)
+				delete(latest, st.Container)
+				mu.Unlock()
+			}(st)
+		}
+
+		return nil
+	}
+
+	if err := scan(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastScan := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if time.Since(lastScan) >= statsRescan {
+			if err := scan(); err != nil {
+				return err
+			}
+			lastScan = time.Now()
+		}
+
+		mu.Lock()
+		sample := make([]Stats, 0, len(states))
+		for _, st := range states {
+			s, ok := latest[st.Container]
+			if !ok {
+				s = Stats{Service: st.Service, Container: st.Container, State: st.State}
+			}
+
+			sample = append(sample, s)
+		}
+		mu.Unlock()
+
+		slices.SortFunc(sample, func(a, b Stats) int { return strings.Compare(a.Service, b.Service) })
+
+		if err := send(sample); err != nil {
+			return err
+		}
+	}
+}
+
+// streamContainerStats reads docker's stats stream for a container, which sends a sample each second, until the
+// context is done or the container stops
+func (m *Manager) streamContainerStats(ctx context.Context, id string, fn func(Stats)) error {
+	resp, err := m.d.ContainerStats(ctx, id, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+
+	for {
+		var v types.StatsJSON
+		if err := dec.Decode(&v); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		fn(statsFromDocker(&v))
+	}
+}
+
+// statsFromDocker works the figures out the same way as docker stats
+func statsFromDocker(v *types.StatsJSON) Stats {
+	s := Stats{
+		MemoryUsage: v.MemoryStats.Usage,
+		MemoryLimit: v.MemoryStats.Limit,
+		PIDs:        v.PidsStats.Current,
+	}
+
+	cpuDelta := float64(v.CPUStats.CPUUsage.TotalUsage) - float64(v.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(v.CPUStats.SystemUsage) - float64(v.PreCPUStats.SystemUsage)
+
+	cpus := float64(v.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(v.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	// The first sample of a stream has nothing to compare with, and would show the average since the host booted
+	if v.PreCPUStats.SystemUsage > 0 && cpuDelta > 0 && systemDelta > 0 {
+		s.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// The page cache can be reclaimed, so it isn't counted as used. cgroup v1 calls it total_inactive_file, and
+	// v2 inactive_file.
+	cache, ok := v.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache = v.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < s.MemoryUsage {
+		s.MemoryUsage -= cache
+	}
+
+	for _, n := range v.Networks {
+		s.NetworkRx += n.RxBytes
+		s.NetworkTx += n.TxBytes
+	}
+
+	for _, e := range v.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			s.BlockRead += e.Value
+		case "write":
+			s.BlockWrite += e.Value
+		}
+	}
+
+	return s
+}
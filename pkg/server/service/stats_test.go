@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestStatsFromDocker(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		want   Stats
+	}{
+		{
+			// The first sample of a stream has zeroed PreCPUStats
+			name: "first sample",
+			sample: `{
+				"pids_stats": {"current": 12},
+				"cpu_stats": {"cpu_usage": {"total_usage": 81234000000}, "system_cpu_usage": 9876540000000000, "online_cpus": 4},
+				"precpu_stats": {"cpu_usage": {"total_usage": 0}, "throttling_data": {}},
+				"memory_stats": {"usage": 104857600, "stats": {"inactive_file": 4194304}, "limit": 8589934592}
+			}`,
+			want: Stats{MemoryUsage: 100663296, MemoryLimit: 8589934592, PIDs: 12},
+		},
+		{
+			name: "cgroup v2",
+			sample: `{
+				"pids_stats": {"current": 12},
+				"cpu_stats": {"cpu_usage": {"total_usage": 81734000000}, "system_cpu_usage": 9876544000000000, "online_cpus": 4},
+				"precpu_stats": {"cpu_usage": {"total_usage": 81234000000}, "system_cpu_usage": 9876540000000000, "online_cpus": 4},
+				"memory_stats": {"usage": 104857600, "stats": {"active_anon": 62914560, "anon": 67108864, "file": 33554432, "inactive_file": 4194304}, "limit": 8589934592},
+				"networks": {"eth0": {"rx_bytes": 1500, "tx_bytes": 3000}, "eth1": {"rx_bytes": 500, "tx_bytes": 1000}},
+				"blkio_stats": {"io_service_bytes_recursive": [
+					{"major": 8, "minor": 0, "op": "read", "value": 4096},
+					{"major": 8, "minor": 0, "op": "write", "value": 8192},
+					{"major": 8, "minor": 16, "op": "read", "value": 1024}
+				]}
+			}`,
+			want: Stats{
+				CPUPercent:  50,
+				MemoryUsage: 100663296,
+				MemoryLimit: 8589934592,
+				NetworkRx:   2000,
+				NetworkTx:   4000,
+				BlockRead:   5120,
+				BlockWrite:  8192,
+				PIDs:        12,
+			},
+		},
+		{
+			// cgroup v1 has no online_cpus on older engines, and capitalised blkio ops
+			name: "cgroup v1",
+			sample: `{
+				"pids_stats": {"current": 3},
+				"cpu_stats": {"cpu_usage": {"total_usage": 2000000000, "percpu_usage": [1000000000, 1000000000]}, "system_cpu_usage": 20000000000},
+				"precpu_stats": {"cpu_usage": {"total_usage": 1000000000}, "system_cpu_usage": 10000000000},
+				"memory_stats": {"usage": 52428800, "stats": {"cache": 10485760, "total_inactive_file": 2097152, "inactive_file": 1048576}, "limit": 2147483648},
+				"blkio_stats": {"io_service_bytes_recursive": [
+					{"major": 8, "minor": 0, "op": "Read", "value": 4096},
+					{"major": 8, "minor": 0, "op": "Write", "value": 8192},
+					{"major": 8, "minor": 0, "op": "Total", "value": 12288}
+				]}
+			}`,
+			want: Stats{CPUPercent: 20, MemoryUsage: 50331648, MemoryLimit: 2147483648, BlockRead: 4096, BlockWrite: 8192, PIDs: 3},
+		},
+		{
+			name: "no page cache figures",
+			sample: `{
+				"cpu_stats": {"cpu_usage": {"total_usage": 1000}, "system_cpu_usage": 2000, "online_cpus": 1},
+				"precpu_stats": {"cpu_usage": {"total_usage": 1000}, "system_cpu_usage": 1000, "online_cpus": 1},
+				"memory_stats": {"usage": 1048576, "limit": 2097152}
+			}`,
+			want: Stats{MemoryUsage: 1048576, MemoryLimit: 2097152},
+		},
+		{
+			// A stopped container sends zeroed stats
+			name:   "stopped",
+			sample: `{"cpu_stats": {"cpu_usage": {"total_usage": 0}}, "precpu_stats": {"cpu_usage": {"total_usage": 0}}, "memory_stats": {}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v types.StatsJSON
+			if err := json.Unmarshal([]byte(tt.sample), &v); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := statsFromDocker(&v); got != tt.want {
+				t.Errorf("statsFromDocker() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
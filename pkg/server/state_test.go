@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/service"
+)
+
+func newStateServer(dir string) *Server {
+	return &Server{
+		Config: &config.Config{Storage: &config.Storage{Data: dir}},
+		Log:    (&log.Logger{Handler: discard.Default}).WithField("component", "server"),
+		mgr: &service.Manager{
+			Network: "nodeisp",
+			Services: map[string]*service.Service{
+				"app":   {Name: "app", Image: "ghcr.io/node-isp/app:1.2.3", BaseImage: "ghcr.io/node-isp/app:1.2.3"},
+				"redis": {Name: "redis", Image: "redis:7", Env: []string{"A=1"}},
+			},
+		},
+	}
+}
+
+func TestStoreState(t *testing.T) {
+	dir := t.TempDir()
+	s := newStateServer(dir)
+
+	// Concurrent writes each replace the whole file, so it is never seen half written
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.storeState(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "state.json" {
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("data directory = %v, want only state.json", names)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(b) {
+		t.Fatalf("state.json is not valid JSON: %s", b)
+	}
+
+	loaded := &Server{Config: s.Config, mgr: &service.Manager{}}
+	if err := loaded.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.mgr.Network != "nodeisp" || len(loaded.mgr.Services) != 2 {
+		t.Fatalf("loaded state = %+v", loaded.mgr)
+	}
+	if app := loaded.mgr.Services["app"]; app == nil || app.BaseImage != "ghcr.io/node-isp/app:1.2.3" {
+		t.Errorf("app = %+v", app)
+	}
+}
+
+func TestStoreStateMissingDirectory(t *testing.T) {
+	s := newStateServer(filepath.Join(t.TempDir(), "missing"))
+
+	if err := s.storeState(); err == nil {
+		t.Fatal("no error writing to a missing directory")
+	}
+}
@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/version"
+)
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "http://localhost:4318/", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://otlp.example.com//", want: "https://otlp.example.com/v1/traces"},
+		{endpoint: "http://localhost:4318/v1/traces", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://otlp.example.com/otlp/v1/traces", want: "https://otlp.example.com/otlp/v1/traces"},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "/v1/traces", wantErr: true},
+		{endpoint: "http://[::1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := endpointURL(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("endpointURL(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("endpointURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+// receiver is an OTLP/HTTP collector that keeps the spans it is sent
+type receiver struct {
+	mu      sync.Mutex
+	headers http.Header
+	spans   []*tracepb.ResourceSpans
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected export", http.StatusBadRequest)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &collectortrace.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(b, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc.mu.Lock()
+	rc.headers = r.Header.Clone()
+	rc.spans = append(rc.spans, req.ResourceSpans...)
+	rc.mu.Unlock()
+
+	b, _ = proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(b)
+}
+
+func TestStart(t *testing.T) {
+	rc := &receiver{}
+	collector := httptest.NewServer(rc)
+	defer collector.Close()
+
+	// The collector's base URL, which has the traces path added
+	shutdown, err := Start(&config.Tracing{
+		Endpoint:    collector.URL,
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+		SampleRatio: 1,
+		ServiceName: "nodeisp-test",
+	}, (&log.Logger{Handler: discard.Default}).WithField("component", "tracing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An HTTP request, like the proxy serves
+	h := otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "proxy")
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// A gRPC call, like the management API serves
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server span is ended after the response is sent, so stop the server before flushing
+	srv.GracefulStop()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if got := rc.headers.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("X-Api-Key = %q", got)
+	}
+
+	scopes := map[string]bool{}
+	for _, rs := range rc.spans {
+		attrs := map[string]string{}
+		for _, kv := range rs.GetResource().GetAttributes() {
+			attrs[kv.Key] = kv.GetValue().GetStringValue()
+		}
+
+		if attrs["service.name"] != "nodeisp-test" || attrs["service.version"] != version.Version {
+			t.Errorf("resource attributes = %v", attrs)
+		}
+		if attrs["telemetry.sdk.name"] != "opentelemetry" {
+			t.Errorf("resource is missing the SDK defaults: %v", attrs)
+		}
+
+		for _, ss := range rs.ScopeSpans {
+			if len(ss.Spans) > 0 {
+				scopes[ss.GetScope().GetName()] = true
+			}
+		}
+	}
+
+	for _, scope := range []string{
+		"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp",
+		"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc",
+	} {
+		if !scopes[scope] {
+			t.Errorf("no spans from %s, got %v", scope, scopes)
+		}
+	}
+}
+
+func TestStartOff(t *testing.T) {
+	shutdown, err := Start(&config.Tracing{}, (&log.Logger{Handler: discard.Default}).WithField("component", "tracing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
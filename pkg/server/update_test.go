@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/creasty/defaults"
+
+	"github.com/node-isp/node-isp/pkg/config"
+	"github.com/node-isp/node-isp/pkg/server/resources"
+	"github.com/node-isp/node-isp/pkg/server/service"
+	"github.com/node-isp/node-isp/pkg/server/service/servicetest"
+)
+
+// newDockerServer returns a server whose manager runs the app and horizon on a fake docker, with the maintenance
+// hook installed as Run does
+func newDockerServer(t *testing.T) (*Server, *servicetest.Docker) {
+	t.Helper()
+
+	cfg := &config.Config{}
+	if err := defaults.Set(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Storage.Data, cfg.Storage.Logs = t.TempDir(), t.TempDir()
+
+	logger := &log.Logger{Handler: discard.Default}
+	docker := servicetest.NewDocker(t)
+
+	s := &Server{Config: cfg, Log: logger.WithField("component", "server")}
+	s.mgr = service.New(docker.Client, logger.WithField("component", "service"), cfg.Storage.Logs)
+	s.mgr.BeforeReplace = s.holdMaintenance
+
+	app := &service.Service{Name: "app", Image: bakedAppRepo + ":v0.11.8", Env: []string{"APP_VERSION=v0.11.8"}}
+	worker := &service.Service{Name: "horizon", Image: app.Image, Env: app.Env, Entrypoint: []string{"/entrypoint-worker.sh"}}
+
+	for _, svc := range []*service.Service{app, worker} {
+		if err := s.mgr.EnsureService(context.Background(), svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.proxy.Store(newTestProxy(t))
+
+	return s, docker
+}
+
+func TestUpdateApp(t *testing.T) {
+	s, docker := newDockerServer(t)
+
+	// The maintenance page is up while the new image is pulled and the containers replaced
+	var (
+		mu   sync.Mutex
+		held []bool
+	)
+	docker.Pull = func(string) {
+		mu.Lock()
+		defer mu.Unlock()
+		held = append(held, s.proxy.Load().MaintenanceHeld())
+	}
+
+	if err := s.updateApp(context.Background(), "v0.12.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := bakedAppRepo + ":v0.12.0"
+
+	if pulls := docker.Pulls(); len(pulls) != 4 || pulls[2] != want || pulls[3] != want {
+		t.Errorf("pulls = %v", pulls)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(held, []bool{true, true}) {
+		t.Errorf("maintenance held during pulls = %v", held)
+	}
+	if s.proxy.Load().MaintenanceHeld() {
+		t.Error("maintenance is still held after the update")
+	}
+
+	containers := docker.Containers()
+	if len(containers) != 2 {
+		t.Fatalf("containers = %+v, want the old ones replaced", containers)
+	}
+	for _, c := range containers {
+		if c.Image != want || c.State != "running" {
+			t.Errorf("%s container = %+v", c.Labels["service"], c)
+		}
+	}
+
+	for _, name := range []string{"app", "horizon"} {
+		svc := s.mgr.Service(name)
+		if svc.Image != want || svc.BaseImage != want || !slices.Contains(svc.Env, "APP_VERSION=v0.12.0") {
+			t.Errorf("%s = %+v", name, svc)
+		}
+	}
+
+	if s.mgr.Service("horizon").Entrypoint[0] != "/entrypoint-worker.sh" {
+		t.Error("horizon lost its entrypoint")
+	}
+
+	loaded := &Server{Config: s.Config, mgr: &service.Manager{}}
+	if err := loaded.loadState(); err != nil || loaded.mgr.Services["app"].Image != want {
+		t.Errorf("state wasn't stored with the new image: %v", err)
+	}
+}
+
+func TestUpdateAppPinned(t *testing.T) {
+	s, docker := newDockerServer(t)
+	s.Config.Services.App = &config.ServiceOverride{Image: "registry.example.com/app:custom"}
+
+	if err := s.updateApp(context.Background(), "v0.12.0"); err == nil {
+		t.Fatal("pinned app was updated")
+	}
+
+	if pulls := docker.Pulls(); len(pulls) != 2 {
+		t.Errorf("pulls = %v, want none for the update", pulls)
+	}
+}
+
+func TestUpdateAppDiskCritical(t *testing.T) {
+	s, docker := newDockerServer(t)
+	before := docker.Containers()
+
+	// Any space used at all is critical
+	s.Config.Resources.DiskCritical = 1e-9
+	if resources.CheckDisk(s.Config.Resources, s.Config.Storage) == nil {
+		t.Skip("the temporary filesystem is empty, so it can't be critical")
+	}
+
+	if err := s.updateApp(context.Background(), "v0.12.0"); !errors.Is(err, resources.ErrDiskCritical) {
+		t.Fatalf("err = %v, want ErrDiskCritical", err)
+	}
+
+	if pulls := docker.Pulls(); len(pulls) != 2 {
+		t.Errorf("pulls = %v, want none for the update", pulls)
+	}
+	if after := docker.Containers(); !reflect.DeepEqual(after, before) {
+		t.Errorf("containers = %+v, want them left as %+v", after, before)
+	}
+	if s.proxy.Load().MaintenanceHeld() {
+		t.Error("maintenance is held after the refused update")
+	}
+}
@@ -0,0 +1,136 @@
+package webserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/v2/acme"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// manageCertificates obtains and renews certificates for the domains with ACME, returning the TLS config to
+// serve them with and the HTTP handler that solves the HTTP challenge
+func (w *WebServer) manageCertificates() (*tls.Config, http.Handler) {
+	// Renewals started by the cache use the same config, so they go to our issuer and storage, and emit events
+	var magic *certmagic.Config
+	cache := certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(cert certmagic.Certificate) (*certmagic.Config, error) {
+			return magic, nil
+		},
+	})
+
+	magic = certmagic.New(cache, certmagic.Config{
+		Storage: &certmagic.FileStorage{Path: filepath.Join(w.dataDir, "/certs")},
+		OnEvent: w.onCertEvent,
+	})
+
+	w.mu.Lock()
+	w.cache, w.magic = cache, magic
+	w.mu.Unlock()
+
+	// Wildcard certificates can only be issued with the DNS challenge
+	for _, d := range w.domains {
+		if strings.HasPrefix(d, "*.") && w.tls.DNS == nil {
+			w.log.WithField("domain", d).Fatal("Wildcard domains require the DNS challenge, configure http.tls.dns")
+		}
+	}
+
+	issuer, err := acmeIssuer(w.tls)
+	if err != nil {
+		w.log.WithError(err).Fatal("Invalid ACME configuration")
+	}
+
+	myACME := certmagic.NewACMEIssuer(magic, issuer)
+	w.log.WithField("ca", myACME.CA).Info("using ACME CA")
+
+	magic.Issuers = []certmagic.Issuer{
+		myACME,
+	}
+
+	if err := magic.ManageSync(context.TODO(), w.domains); err != nil {
+		w.log.WithError(err).Fatal("Failed to manage certificates")
+	}
+
+	return magic.TLSConfig(), myACME.HTTPChallengeHandler(http.HandlerFunc(httpRedirectHandler))
+}
+
+// acmeIssuer builds the ACME issuer template from the TLS config
+func acmeIssuer(cfg *config.TLS) (certmagic.ACMEIssuer, error) {
+	issuer := certmagic.ACMEIssuer{
+		CA:     certmagic.LetsEncryptProductionCA,
+		Email:  cfg.Email,
+		Agreed: true,
+	}
+
+	switch {
+	case cfg.CA != "":
+		issuer.CA = cfg.CA
+	case cfg.Staging:
+		issuer.CA = certmagic.LetsEncryptStagingCA
+	}
+
+	if cfg.EAB != nil && cfg.EAB.KeyID != "" {
+		issuer.ExternalAccount = &acme.EAB{
+			KeyID:  cfg.EAB.KeyID,
+			MACKey: cfg.EAB.MACKey,
+		}
+	}
+
+	if cfg.RootCA != "" {
+		pem, err := os.ReadFile(cfg.RootCA)
+		if err != nil {
+			return issuer, err
+		}
+
+		issuer.TrustedRoots = x509.NewCertPool()
+		if !issuer.TrustedRoots.AppendCertsFromPEM(pem) {
+			return issuer, fmt.Errorf("no certificates found in %s", cfg.RootCA)
+		}
+	}
+
+	if cfg.DNS != nil {
+		solver, err := dnsSolver(cfg.DNS)
+		if err != nil {
+			return issuer, err
+		}
+
+		issuer.DNS01Solver = solver
+	}
+
+	return issuer, nil
+}
+
+// dnsSolver builds the DNS-01 challenge solver. When it is set, certmagic only uses the DNS challenge.
+func dnsSolver(cfg *config.DNSChallenge) (*certmagic.DNS01Solver, error) {
+	var provider certmagic.DNSProvider
+
+	switch cfg.Provider {
+	case "", "rfc2136":
+		p, err := newRFC2136Provider(cfg.RFC2136)
+		if err != nil {
+			return nil, err
+		}
+		provider = p
+	default:
+		return nil, fmt.Errorf("unsupported dns provider %q", cfg.Provider)
+	}
+
+	return &certmagic.DNS01Solver{
+		DNSManager: certmagic.DNSManager{
+			DNSProvider:        provider,
+			TTL:                cfg.TTL,
+			PropagationDelay:   cfg.PropagationDelay,
+			PropagationTimeout: cfg.PropagationTimeout,
+			Resolvers:          cfg.Resolvers,
+			OverrideDomain:     cfg.OverrideDomain,
+		},
+	}, nil
+}
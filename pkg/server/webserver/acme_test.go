@@ -0,0 +1,242 @@
+package webserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+func TestACMEIssuer(t *testing.T) {
+	root := writeTestCA(t)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		cfg       *config.TLS
+		wantCA    string
+		wantEAB   bool
+		wantRoots bool
+		wantErr   bool
+	}{
+		{
+			name:   "lets encrypt by default",
+			cfg:    &config.TLS{Email: "ops@example.com"},
+			wantCA: certmagic.LetsEncryptProductionCA,
+		},
+		{
+			name:   "staging",
+			cfg:    &config.TLS{Staging: true},
+			wantCA: certmagic.LetsEncryptStagingCA,
+		},
+		{
+			name:   "custom CA wins over staging",
+			cfg:    &config.TLS{Staging: true, CA: "https://acme.example.com/directory"},
+			wantCA: "https://acme.example.com/directory",
+		},
+		{
+			name:    "EAB",
+			cfg:     &config.TLS{CA: "https://acme.zerossl.com/v2/DV90", EAB: &config.EAB{KeyID: "kid", MACKey: "bWFj"}},
+			wantCA:  "https://acme.zerossl.com/v2/DV90",
+			wantEAB: true,
+		},
+		{
+			name:   "EAB without a key id is ignored",
+			cfg:    &config.TLS{EAB: &config.EAB{MACKey: "bWFj"}},
+			wantCA: certmagic.LetsEncryptProductionCA,
+		},
+		{
+			name:      "trusted roots",
+			cfg:       &config.TLS{CA: "https://localhost:14000/dir", RootCA: root},
+			wantCA:    "https://localhost:14000/dir",
+			wantRoots: true,
+		},
+		{
+			name:    "missing root CA file",
+			cfg:     &config.TLS{RootCA: filepath.Join(t.TempDir(), "missing.pem")},
+			wantErr: true,
+		},
+		{
+			name:    "root CA file without certificates",
+			cfg:     &config.TLS{RootCA: empty},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := acmeIssuer(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("acmeIssuer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if issuer.CA != tt.wantCA {
+				t.Errorf("CA = %s, want %s", issuer.CA, tt.wantCA)
+			}
+
+			if (issuer.ExternalAccount != nil) != tt.wantEAB {
+				t.Errorf("EAB = %+v, want set %v", issuer.ExternalAccount, tt.wantEAB)
+			}
+			if tt.wantEAB && (issuer.ExternalAccount.KeyID != tt.cfg.EAB.KeyID || issuer.ExternalAccount.MACKey != tt.cfg.EAB.MACKey) {
+				t.Errorf("EAB = %+v, want %+v", issuer.ExternalAccount, tt.cfg.EAB)
+			}
+
+			if (issuer.TrustedRoots != nil) != tt.wantRoots {
+				t.Errorf("trusted roots set = %v, want %v", issuer.TrustedRoots != nil, tt.wantRoots)
+			}
+		})
+	}
+}
+
+// TestACMEPebble issues a certificate from a local Pebble, through the CA, root and EAB settings. Run Pebble with
+// PEBBLE_VA_ALWAYS_VALID=1 so the challenges don't need to reach this test, and EAB required in its config:
+//
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir
+//	PEBBLE_ROOT_CA=test/certs/pebble.minica.pem
+//	PEBBLE_EAB_KEY_ID=kid-1
+//	PEBBLE_EAB_MAC_KEY=zWNDZM6eQGHWpSRTPal5eIUYFTu7EajVIoguysqZ9wG44nMEtx3MUAsUDkMTQ12W
+func TestACMEPebble(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY is not set, skipping the Pebble test")
+	}
+
+	cfg := &config.TLS{
+		Email:  "ops@nodeisp.test",
+		CA:     directory,
+		RootCA: os.Getenv("PEBBLE_ROOT_CA"),
+	}
+
+	if kid := os.Getenv("PEBBLE_EAB_KEY_ID"); kid != "" {
+		cfg.EAB = &config.EAB{KeyID: kid, MACKey: os.Getenv("PEBBLE_EAB_MAC_KEY")}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	const domain = "pebble.nodeisp.test"
+
+	magic, err := pebbleConfig(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := magic.ObtainCertSync(ctx, domain); err != nil {
+		t.Fatalf("failed to obtain a certificate: %v", err)
+	}
+
+	cert, err := magic.CacheManagedCertificate(ctx, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Contains(cert.Leaf.DNSNames, domain) {
+		t.Errorf("certificate names = %v, want %s", cert.Leaf.DNSNames, domain)
+	}
+
+	if !strings.Contains(cert.Leaf.Issuer.CommonName, "Pebble") {
+		t.Errorf("certificate issuer = %s, want it issued by Pebble", cert.Leaf.Issuer)
+	}
+
+	// Pebble rejects new accounts without the binding when it requires EAB
+	if cfg.EAB != nil {
+		cfg.EAB = nil
+
+		magic, err := pebbleConfig(t, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := magic.ObtainCertSync(ctx, "no-eab."+domain); err == nil {
+			t.Error("obtained a certificate without EAB, is Pebble configured to require it?")
+		}
+	}
+}
+
+// pebbleConfig builds a certmagic config with its own storage, so each one registers a new account
+func pebbleConfig(t *testing.T, cfg *config.TLS) (*certmagic.Config, error) {
+	issuer, err := acmeIssuer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The challenges are not validated, but the solvers still listen, so keep them off the privileged ports
+	issuer.AltHTTPPort = freePort(t)
+	issuer.DisableTLSALPNChallenge = true
+
+	var magic *certmagic.Config
+	cache := certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(certmagic.Certificate) (*certmagic.Config, error) { return magic, nil },
+	})
+	t.Cleanup(cache.Stop)
+
+	magic = certmagic.New(cache, certmagic.Config{
+		Storage: &certmagic.FileStorage{Path: t.TempDir()},
+	})
+	magic.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(magic, issuer)}
+
+	return magic, nil
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// writeTestCA writes a self-signed CA certificate, and returns the path to it
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "NodeISP Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,230 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renewCooldown is how long before a domain's certificate can be renewed on request again. Every renewal counts
+// towards the CA's rate limits, such as Let's Encrypt's five duplicate certificates a week, whether or not it
+// succeeds.
+const renewCooldown = time.Hour
+
+// ErrRenewCooldown is returned when a certificate was renewed on request too recently
+var ErrRenewCooldown = errors.New("the certificate was renewed recently")
+
+// certEventHistory is how many of the recent certificate events are kept for new subscribers
+const certEventHistory = 100
+
+// CertEvent is something certmagic did with a certificate
+type CertEvent struct {
+	Time    time.Time
+	Event   string
+	Domains []string
+	Renewal bool
+	Issuer  string
+	Error   string
+}
+
+// certEvents keeps the recent certificate events, and passes new ones on to the streams subscribed to them
+type certEvents struct {
+	mu     sync.Mutex
+	recent []CertEvent
+	subs   map[chan CertEvent]struct{}
+}
+
+func (c *certEvents) add(e CertEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recent = append(c.recent, e)
+	if len(c.recent) > certEventHistory {
+		c.recent = slices.Delete(c.recent, 0, len(c.recent)-certEventHistory)
+	}
+
+	for ch := range c.subs {
+		// A stream that isn't keeping up misses events, rather than holding up certmagic
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns the recent events, and a channel of the ones after them until unsubscribe is called
+func (c *certEvents) subscribe() (recent []CertEvent, ch chan CertEvent, unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch = make(chan CertEvent, 16)
+	if c.subs == nil {
+		c.subs = map[chan CertEvent]struct{}{}
+	}
+	c.subs[ch] = struct{}{}
+
+	return slices.Clone(c.recent), ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		delete(c.subs, ch)
+	}
+}
+
+// CertEvents calls send with the recent certificate events, oldest first. With follow it then calls send with
+// each new event, until the context is done or send returns an error.
+func (w *WebServer) CertEvents(ctx context.Context, follow bool, send func(CertEvent) error) error {
+	recent, ch, unsubscribe := w.events.subscribe()
+	defer unsubscribe()
+
+	for _, e := range recent {
+		if err := send(e); err != nil {
+			return err
+		}
+	}
+
+	if !follow {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-ch:
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// onCertEvent logs what certmagic is doing with the certificates, passes it on to the event streams, and records
+// failed renewals for Certificates
+func (w *WebServer) onCertEvent(_ context.Context, event string, data map[string]any) error {
+	l := w.log.WithField("event", event)
+
+	e := CertEvent{Time: time.Now(), Event: event}
+	e.Renewal, _ = data["renewal"].(bool)
+	e.Issuer, _ = data["issuer"].(string)
+	if domain, ok := data["identifier"].(string); ok {
+		e.Domains = []string{domain}
+	}
+
+	switch event {
+	case "cert_obtaining":
+		l.WithField("domain", data["identifier"]).WithField("renewal", data["renewal"]).Info("obtaining certificate")
+	case "cert_obtained":
+		l.WithField("domain", data["identifier"]).WithField("renewal", data["renewal"]).WithField("issuer", data["issuer"]).Info("obtained certificate")
+		w.setRenewErr(data["identifier"], nil)
+	case "cert_failed":
+		err, _ := data["error"].(error)
+		if err == nil {
+			err = fmt.Errorf("unknown error")
+		}
+
+		l.WithField("domain", data["identifier"]).WithField("renewal", data["renewal"]).WithError(err).Error("failed to obtain certificate")
+		w.setRenewErr(data["identifier"], err)
+		e.Error = err.Error()
+	case "cert_ocsp_revoked":
+		l.WithField("domains", data["subjects"]).WithField("revoked_at", data["revoked_at"]).Warn("certificate has been revoked, renewing it")
+		e.Domains, _ = data["subjects"].([]string)
+	case "cached_managed_cert":
+		// Loading certificates from storage isn't worth streaming
+		l.WithField("domains", data["sans"]).Debug("loaded certificate")
+		return nil
+	default:
+		return nil
+	}
+
+	w.events.add(e)
+
+	return nil
+}
+
+func (w *WebServer) setRenewErr(identifier any, err error) {
+	domain, ok := identifier.(string)
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err == nil {
+		delete(w.renewErrs, domain)
+		return
+	}
+
+	if w.renewErrs == nil {
+		w.renewErrs = map[string]error{}
+	}
+
+	w.renewErrs[domain] = err
+}
+
+// RenewCertificate renews the certificate for a domain straight away, even if it isn't due yet
+func (w *WebServer) RenewCertificate(ctx context.Context, domain string) error {
+	w.mu.Lock()
+	magic, cache := w.magic, w.cache
+	w.mu.Unlock()
+
+	if magic == nil {
+		return fmt.Errorf("certificates are not managed with ACME")
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !slices.ContainsFunc(w.domains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+		return fmt.Errorf("%s is not one of the configured domains", domain)
+	}
+
+	if err := w.startRenewal(domain, time.Now()); err != nil {
+		return err
+	}
+
+	w.log.WithField("domain", domain).Info("renewing certificate on request")
+
+	if err := magic.RenewCertSync(ctx, domain, true); err != nil {
+		return err
+	}
+
+	// The renewed certificate is only in storage, so load it into the cache in place of the old one
+	var old []string
+	for _, c := range cache.AllMatchingCertificates(domain) {
+		if slices.Contains(c.Names, domain) {
+			old = append(old, c.Hash())
+		}
+	}
+
+	renewed, err := magic.CacheManagedCertificate(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	cache.Remove(slices.DeleteFunc(old, func(h string) bool { return h == renewed.Hash() }))
+
+	return nil
+}
+
+// startRenewal records a renewal on request, unless the domain had one within the cooldown. Concurrent requests
+// for the same domain are refused too.
+func (w *WebServer) startRenewal(domain string, now time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if last, ok := w.renewedAt[domain]; ok && now.Sub(last) < renewCooldown {
+		return fmt.Errorf("%w, try again in %s", ErrRenewCooldown, (renewCooldown - now.Sub(last)).Round(time.Second))
+	}
+
+	if w.renewedAt == nil {
+		w.renewedAt = map[string]time.Time{}
+	}
+
+	w.renewedAt[domain] = now
+
+	return nil
+}
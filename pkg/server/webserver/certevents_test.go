@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+func TestOnCertEvent(t *testing.T) {
+	w := &WebServer{log: (&log.Logger{Handler: discard.Default}).WithField("component", "webserver")}
+	ctx := context.Background()
+
+	_ = w.onCertEvent(ctx, "cached_managed_cert", map[string]any{"sans": []string{"example.com"}})
+	_ = w.onCertEvent(ctx, "cert_obtaining", map[string]any{"identifier": "example.com", "renewal": true})
+	_ = w.onCertEvent(ctx, "cert_failed", map[string]any{"identifier": "example.com", "renewal": true, "error": errors.New("rate limited")})
+	_ = w.onCertEvent(ctx, "cert_ocsp_revoked", map[string]any{"subjects": []string{"example.com", "www.example.com"}})
+	_ = w.onCertEvent(ctx, "cert_obtained", map[string]any{"identifier": "www.example.com", "issuer": "acme-v02.api.letsencrypt.org-directory"})
+
+	var got []CertEvent
+	if err := w.CertEvents(ctx, false, func(e CertEvent) error {
+		got = append(got, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CertEvent{
+		{Event: "cert_obtaining", Domains: []string{"example.com"}, Renewal: true},
+		{Event: "cert_failed", Domains: []string{"example.com"}, Renewal: true, Error: "rate limited"},
+		{Event: "cert_ocsp_revoked", Domains: []string{"example.com", "www.example.com"}},
+		{Event: "cert_obtained", Domains: []string{"www.example.com"}, Issuer: "acme-v02.api.letsencrypt.org-directory"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+
+	for i := range want {
+		want[i].Time = got[i].Time
+		if fmt.Sprint(got[i]) != fmt.Sprint(want[i]) {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if err := w.renewErrs["example.com"]; err == nil || err.Error() != "rate limited" {
+		t.Errorf("renewal error = %v", err)
+	}
+}
+
+func TestCertEventsFollow(t *testing.T) {
+	w := &WebServer{}
+	w.events.add(CertEvent{Event: "cert_obtaining"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan CertEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.CertEvents(ctx, true, func(e CertEvent) error {
+			received <- e
+			return nil
+		})
+	}()
+
+	if e := <-received; e.Event != "cert_obtaining" {
+		t.Fatalf("first event = %s, want the recent one", e.Event)
+	}
+
+	w.events.add(CertEvent{Event: "cert_obtained"})
+
+	select {
+	case e := <-received:
+		if e.Event != "cert_obtained" {
+			t.Fatalf("second event = %s", e.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new event wasn't streamed")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("CertEvents() = %v", err)
+	}
+
+	if len(w.events.subs) != 0 {
+		t.Error("stream is still subscribed after it ended")
+	}
+}
+
+func TestCertEventsHistory(t *testing.T) {
+	var c certEvents
+	for i := range certEventHistory + 50 {
+		c.add(CertEvent{Event: fmt.Sprint(i)})
+	}
+
+	recent, _, unsubscribe := c.subscribe()
+	defer unsubscribe()
+
+	if len(recent) != certEventHistory || recent[0].Event != "50" || recent[len(recent)-1].Event != fmt.Sprint(certEventHistory+49) {
+		t.Errorf("kept %d events, from %s to %s", len(recent), recent[0].Event, recent[len(recent)-1].Event)
+	}
+}
+
+func TestStartRenewal(t *testing.T) {
+	w := &WebServer{}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		domain  string
+		at      time.Time
+		wantErr bool
+	}{
+		{name: "first renewal", domain: "example.com", at: now},
+		{name: "again straight away", domain: "example.com", at: now.Add(time.Second), wantErr: true},
+		{name: "other domain", domain: "www.example.com", at: now.Add(time.Second)},
+		{name: "just inside the cooldown", domain: "example.com", at: now.Add(renewCooldown - time.Second), wantErr: true},
+		{name: "after the cooldown", domain: "example.com", at: now.Add(renewCooldown)},
+		{name: "cooldown restarts", domain: "example.com", at: now.Add(renewCooldown + time.Minute), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := w.startRenewal(tt.domain, tt.at)
+		if tt.wantErr != errors.Is(err, ErrRenewCooldown) {
+			t.Errorf("%s: startRenewal() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package webserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// certStore serves certificates loaded from disk, for when certificates are issued outside NodeISP
+type certStore struct {
+	mu sync.RWMutex
+
+	log           *log.Entry
+	certs         []config.Certificate
+	expiryWarning time.Duration
+
+	// reloadEvery is how often the files are checked for changes, and expiryEvery how often expiry is warned about
+	reloadEvery, expiryEvery time.Duration
+
+	// loaded holds the certificates in the order they are configured, the first one is the default
+	loaded []*loadedCert
+}
+
+type loadedCert struct {
+	cfg     config.Certificate
+	cert    *tls.Certificate
+	names   []string
+	modTime time.Time
+}
+
+func newCertStore(cfg *config.TLS, log *log.Entry) (*certStore, error) {
+	s := &certStore{
+		log:           log,
+		certs:         cfg.Certificates,
+		expiryWarning: cfg.ExpiryWarning,
+		reloadEvery:   30 * time.Second,
+		expiryEvery:   12 * time.Hour,
+		loaded:        make([]*loadedCert, len(cfg.Certificates)),
+	}
+
+	for i, c := range cfg.Certificates {
+		lc, err := loadCert(c)
+		if err != nil {
+			return nil, err
+		}
+
+		s.loaded[i] = lc
+		s.log.WithField("cert", c.Cert).WithField("domains", lc.names).WithField("expires", lc.cert.Leaf.NotAfter).Info("loaded certificate")
+	}
+
+	s.checkExpiry()
+
+	return s, nil
+}
+
+// GetCertificate picks the certificate for the server name of the connection. Exact names are preferred over
+// wildcards, and the first certificate is used when nothing matches.
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	for _, lc := range s.loaded {
+		for _, n := range lc.names {
+			if n == name {
+				return lc.cert, nil
+			}
+		}
+	}
+
+	if i := strings.Index(name, "."); i > 0 {
+		wildcard := "*" + name[i:]
+		for _, lc := range s.loaded {
+			for _, n := range lc.names {
+				if n == wildcard {
+					return lc.cert, nil
+				}
+			}
+		}
+	}
+
+	if len(s.loaded) == 0 {
+		return nil, fmt.Errorf("no certificates loaded")
+	}
+
+	return s.loaded[0].cert, nil
+}
+
+// watch reloads certificates when their files change, and warns about certificates close to expiring
+func (s *certStore) watch(ctx context.Context) {
+	reload := time.NewTicker(s.reloadEvery)
+	defer reload.Stop()
+
+	expiry := time.NewTicker(s.expiryEvery)
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload.C:
+			s.reload()
+		case <-expiry.C:
+			s.checkExpiry()
+		}
+	}
+}
+
+func (s *certStore) reload() {
+	for i, c := range s.certs {
+		s.mu.RLock()
+		current := s.loaded[i]
+		s.mu.RUnlock()
+
+		if !changed(c, current.modTime) {
+			continue
+		}
+
+		// A renewal may write the cert and key separately, so keep serving the old pair until both load
+		lc, err := loadCert(c)
+		if err != nil {
+			s.log.WithError(err).WithField("cert", c.Cert).Warn("failed to reload certificate, keeping the current one")
+			continue
+		}
+
+		s.mu.Lock()
+		s.loaded[i] = lc
+		s.mu.Unlock()
+
+		s.log.WithField("cert", c.Cert).WithField("expires", lc.cert.Leaf.NotAfter).Info("reloaded certificate")
+		s.checkExpiry()
+	}
+}
+
+func (s *certStore) checkExpiry() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, lc := range s.loaded {
+		l := s.log.WithField("cert", lc.cfg.Cert).WithField("domains", lc.names).WithField("expires", lc.cert.Leaf.NotAfter)
+		left := time.Until(lc.cert.Leaf.NotAfter)
+
+		switch {
+		case left <= 0:
+			l.Error("certificate has expired")
+		case left < s.expiryWarning:
+			l.WithField("days", int(left.Hours()/24)).Warn("certificate expires soon")
+		}
+	}
+}
+
+// changed reports whether the certificate or key has been modified since modTime
+func changed(c config.Certificate, modTime time.Time) bool {
+	for _, path := range []string{c.Cert, c.Key} {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func loadCert(c config.Certificate) (*loadedCert, error) {
+	var modTime time.Time
+	for _, path := range []string{c.Cert, c.Key} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	names := c.Domains
+	if len(names) == 0 {
+		names = cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+	}
+
+	lower := make([]string, len(names))
+	for i, n := range names {
+		lower[i] = strings.ToLower(n)
+	}
+
+	return &loadedCert{cfg: c, cert: &cert, names: lower, modTime: modTime}, nil
+}
+
+// Certificate describes a certificate being served
+type Certificate struct {
+	Names     []string
+	Issuer    string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Managed is true for certificates obtained with ACME, rather than loaded from disk
+	Managed bool
+
+	// OCSPStatus is good, revoked or unknown from the stapled OCSP response, or none without one
+	OCSPStatus string
+
+	// RenewalError is why the last attempt to renew the certificate failed, for a managed certificate. A domain
+	// whose first certificate couldn't be obtained is listed with only its name and this set.
+	RenewalError error
+}
+
+func newCertificate(cert *tls.Certificate, names []string, managed bool) Certificate {
+	return Certificate{
+		Names:      names,
+		Issuer:     cert.Leaf.Issuer.CommonName,
+		Serial:     fmt.Sprintf("%X", cert.Leaf.SerialNumber),
+		NotBefore:  cert.Leaf.NotBefore,
+		NotAfter:   cert.Leaf.NotAfter,
+		Managed:    managed,
+		OCSPStatus: ocspStatus(cert.OCSPStaple),
+	}
+}
+
+func ocspStatus(staple []byte) string {
+	if len(staple) == 0 {
+		return "none"
+	}
+
+	resp, err := ocsp.ParseResponse(staple, nil)
+	if err != nil {
+		return "unknown"
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+
+	return "unknown"
+}
+
+// Certificates returns the certificates being served, there are none in plain HTTP mode
+func (w *WebServer) Certificates() []Certificate {
+	w.mu.Lock()
+	cache, store := w.cache, w.store
+	renewErrs := maps.Clone(w.renewErrs)
+	w.mu.Unlock()
+
+	var certs []Certificate
+
+	if store != nil {
+		store.mu.RLock()
+		for _, lc := range store.loaded {
+			certs = append(certs, newCertificate(lc.cert, lc.names, false))
+		}
+		store.mu.RUnlock()
+	}
+
+	if cache != nil {
+		seen := map[string]bool{}
+		for _, d := range w.domains {
+			for _, c := range cache.AllMatchingCertificates(d) {
+				if c.Leaf == nil || seen[c.Hash()] {
+					continue
+				}
+				seen[c.Hash()] = true
+
+				cert := newCertificate(&c.Certificate, c.Names, true)
+				for _, n := range c.Names {
+					if err, ok := renewErrs[n]; ok {
+						cert.RenewalError = err
+						delete(renewErrs, n)
+					}
+				}
+
+				certs = append(certs, cert)
+			}
+		}
+	}
+
+	for domain, err := range renewErrs {
+		certs = append(certs, Certificate{Names: []string{domain}, Managed: true, OCSPStatus: "none", RenewalError: err})
+	}
+
+	slices.SortStableFunc(certs, func(a, b Certificate) int { return strings.Compare(a.Names[0], b.Names[0]) })
+
+	return certs
+}
@@ -0,0 +1,234 @@
+package webserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/apex/log/handlers/memory"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// certPEM returns a self-signed certificate for names, named by its common name, and its key
+func certPEM(t *testing.T, cn string, names []string, notAfter time.Time) (cert, key []byte) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     names,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeCert writes a certificate and key to dir, and returns the config loading them
+func writeCert(t *testing.T, dir, cn string, names []string, notAfter time.Time) config.Certificate {
+	t.Helper()
+
+	c := config.Certificate{Cert: filepath.Join(dir, cn+".crt"), Key: filepath.Join(dir, cn+".key")}
+
+	cert, key := certPEM(t, cn, names, notAfter)
+	writeFile(t, c.Cert, cert)
+	writeFile(t, c.Key, key)
+
+	return c
+}
+
+// writeFile writes a file with a modification time in the future, so it is seen as changed straight away
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	if cert == nil || cert.Leaf == nil {
+		t.Fatal("no certificate")
+	}
+
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertStoreGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	expires := time.Now().Add(90 * 24 * time.Hour)
+
+	override := writeCert(t, dir, "override", []string{"other.example.org"}, expires)
+	override.Domains = []string{"Override.example.org"}
+
+	store, err := newCertStore(&config.TLS{Certificates: []config.Certificate{
+		writeCert(t, dir, "default", []string{"example.com", "www.example.com"}, expires),
+		writeCert(t, dir, "wildcard", []string{"*.example.net"}, expires),
+		writeCert(t, dir, "exact", []string{"shop.example.net"}, expires),
+		override,
+	}}, (&log.Logger{Handler: discard.Default}).WithField("component", "certs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		serverName string
+		want       string
+	}{
+		{name: "exact name", serverName: "www.example.com", want: "default"},
+		{name: "case and trailing dot", serverName: "WWW.Example.com.", want: "default"},
+		{name: "exact name preferred over a wildcard", serverName: "shop.example.net", want: "exact"},
+		{name: "wildcard", serverName: "blog.example.net", want: "wildcard"},
+		{name: "wildcard covers one label", serverName: "a.blog.example.net", want: "default"},
+		{name: "wildcard doesn't cover the apex", serverName: "example.net", want: "default"},
+		{name: "configured domains", serverName: "override.example.org", want: "override"},
+		{name: "configured domains replace the certificate's", serverName: "other.example.org", want: "default"},
+		{name: "unknown name", serverName: "example.org", want: "default"},
+		{name: "no server name", want: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := commonName(t, cert); got != tt.want {
+				t.Errorf("GetCertificate(%q) = %s, want %s", tt.serverName, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (&certStore{}).GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Error("empty store returned a certificate")
+	}
+}
+
+func TestCertStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	c := writeCert(t, dir, "example", []string{"example.com"}, time.Now().Add(30*24*time.Hour))
+
+	store, err := newCertStore(&config.TLS{Certificates: []config.Certificate{c}}, (&log.Logger{Handler: discard.Default}).WithField("component", "certs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.reloadEvery = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.watch(ctx)
+
+	current := func() string {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cert.Leaf.NotAfter.Format(time.RFC3339)
+	}
+
+	before := current()
+	renewed := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	cert, key := certPEM(t, "example", []string{"example.com"}, renewed)
+
+	// The renewal writes the certificate first, which doesn't match the old key, so the old pair is kept
+	writeFile(t, c.Cert, cert)
+	time.Sleep(100 * time.Millisecond)
+
+	if got := current(); got != before {
+		t.Fatalf("certificate changed to one expiring %s before its key was written", got)
+	}
+
+	writeFile(t, c.Key, key)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for current() != renewed.Format(time.RFC3339) {
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate expiring %s wasn't reloaded, still serving the one expiring %s", renewed, current())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertStoreExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		left      time.Duration
+		wantLevel log.Level
+		wantMsg   string
+	}{
+		{name: "not expiring", left: 60 * 24 * time.Hour},
+		{name: "expiring soon", left: 10 * 24 * time.Hour, wantLevel: log.WarnLevel, wantMsg: "certificate expires soon"},
+		{name: "expired", left: -time.Hour, wantLevel: log.ErrorLevel, wantMsg: "certificate has expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := writeCert(t, t.TempDir(), "example", []string{"example.com"}, time.Now().Add(tt.left))
+
+			logs := memory.New()
+			if _, err := newCertStore(&config.TLS{Certificates: []config.Certificate{c}, ExpiryWarning: 30 * 24 * time.Hour}, (&log.Logger{Handler: logs, Level: log.DebugLevel}).WithField("component", "certs")); err != nil {
+				t.Fatal(err)
+			}
+
+			var warnings []*log.Entry
+			for _, e := range logs.Entries {
+				if e.Level >= log.WarnLevel {
+					warnings = append(warnings, e)
+				}
+			}
+
+			if tt.wantMsg == "" {
+				if len(warnings) != 0 {
+					t.Errorf("warned %q", warnings[0].Message)
+				}
+				return
+			}
+
+			if len(warnings) != 1 || warnings[0].Level != tt.wantLevel || warnings[0].Message != tt.wantMsg {
+				t.Fatalf("warnings = %+v, want %s %q", warnings, tt.wantLevel, tt.wantMsg)
+			}
+			if tt.wantLevel == log.WarnLevel && warnings[0].Fields["days"] != 9 {
+				t.Errorf("days = %v, want 9", warnings[0].Fields["days"])
+			}
+		})
+	}
+}
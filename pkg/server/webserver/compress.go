@@ -0,0 +1,232 @@
+package webserver
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// encoder is a pooled compressor for a content encoding
+type encoder struct {
+	name string
+	pool sync.Pool
+}
+
+// compressor is a writer that is reset to each response and returned to the pool after it
+type compressor interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+var encoders = map[string]func() compressor{
+	"gzip": func() compressor {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+	"zstd": func() compressor {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	},
+}
+
+// compression compresses responses with the first of the configured encodings the client accepts
+type compression struct {
+	encoders []*encoder
+	minSize  int
+}
+
+func newCompression(cfg *config.Compression) (*compression, error) {
+	c := &compression{minSize: cfg.MinSize}
+
+	for _, name := range cfg.Algorithms {
+		name = strings.ToLower(name)
+
+		newEncoder, ok := encoders[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported compression algorithm %q", name)
+		}
+
+		c.encoders = append(c.encoders, &encoder{
+			name: name,
+			pool: sync.Pool{New: func() any { return newEncoder() }},
+		})
+	}
+
+	return c, nil
+}
+
+func (c *compression) middleware(next http.Handler) http.Handler {
+	if len(c.encoders) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		enc := c.negotiate(r)
+		if enc == nil || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, enc: enc, minSize: c.minSize}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks the first configured encoding the client accepts. A * accepts any encoding not refused by name.
+func (c *compression) negotiate(r *http.Request) *encoder {
+	accepted := map[string]bool{}
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		ok := true
+
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				ok = false
+			}
+		}
+
+		accepted[strings.ToLower(strings.TrimSpace(name))] = ok
+	}
+
+	for _, enc := range c.encoders {
+		if ok, named := accepted[enc.name]; named && ok || !named && accepted["*"] {
+			return enc
+		}
+	}
+
+	return nil
+}
+
+// compressWriter decides whether to compress once the response headers are written. It unwraps for
+// http.ResponseController, so websocket upgrades and deadlines still work through it.
+type compressWriter struct {
+	http.ResponseWriter
+
+	enc     *encoder
+	minSize int
+
+	w           compressor
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+
+	// Informational responses, such as 103 Early Hints, are followed by the real one
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.wroteHeader = true
+
+	if cw.shouldCompress(status) {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.enc.name)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+
+		// The compressed body is a different representation, so a strong ETag no longer applies
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		cw.w = cw.enc.pool.Get().(compressor)
+		cw.w.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) shouldCompress(status int) bool {
+	h := cw.Header()
+
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+
+	if h.Get("Content-Encoding") != "" || strings.Contains(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	if l, err := strconv.Atoi(h.Get("Content-Length")); err == nil && l < cw.minSize {
+		return false
+	}
+
+	return compressible(h.Get("Content-Type"))
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+
+	return cw.w.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.w != nil {
+		_ = cw.w.Flush()
+	}
+
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if cw.w == nil {
+		return
+	}
+
+	_ = cw.w.Close()
+	cw.w.Reset(nil)
+	cw.enc.pool.Put(cw.w)
+}
+
+// compressible reports whether a content type is worth compressing, images and archives are already compressed
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+
+	switch mt {
+	case "application/json", "application/javascript", "application/xml", "application/xhtml+xml",
+		"application/rss+xml", "application/atom+xml", "application/manifest+json", "application/ld+json",
+		"application/wasm", "image/svg+xml", "image/x-icon", "font/ttf", "font/otf":
+		return true
+	}
+
+	return strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml")
+}
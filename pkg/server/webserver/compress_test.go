@@ -0,0 +1,331 @@
+package webserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+func TestNegotiate(t *testing.T) {
+	c, err := newCompression(&config.Compression{Algorithms: []string{"zstd", "GZIP"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"zstd", "zstd"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"GZip", "gzip"},
+		{"gzip;q=0.5, zstd;q=0.1", "zstd"},
+		{"zstd;q=0, gzip", "gzip"},
+		{"zstd;q=0.0, gzip;q=0", ""},
+		{"identity", ""},
+		{"deflate, br", ""},
+		{"*", "zstd"},
+		{"zstd;q=0, *", "gzip"},
+		{"*;q=0", ""},
+		{" gzip ; q=1 ", "gzip"},
+		{"gzip;q=bogus", "gzip"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Encoding", tt.accept)
+		}
+
+		got := ""
+		if enc := c.negotiate(r); enc != nil {
+			got = enc.name
+		}
+
+		if got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNewCompression(t *testing.T) {
+	if _, err := newCompression(&config.Compression{Algorithms: []string{"gzip", "brotli"}}); err == nil {
+		t.Error("no error for an unsupported algorithm")
+	}
+
+	c, err := newCompression(&config.Compression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty list turns compression off
+	h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, strings.Repeat("<p>NodeISP</p>", 200))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q with compression off", got)
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	body := strings.Repeat("<p>NodeISP</p>", 200)
+
+	tests := []struct {
+		name     string
+		method   string
+		accept   string
+		reqRange string
+		status   int
+		header   map[string]string
+		body     string
+		want     string
+		wantETag string
+	}{
+		{
+			name:   "html",
+			accept: "gzip",
+			header: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			want:   "gzip",
+		},
+		{
+			name:   "zstd preferred",
+			accept: "gzip, zstd",
+			header: map[string]string{"Content-Type": "application/json"},
+			want:   "zstd",
+		},
+		{
+			name:   "sniffed content type",
+			accept: "gzip",
+			want:   "gzip",
+		},
+		{
+			name:     "strong ETag is weakened",
+			accept:   "gzip",
+			header:   map[string]string{"Content-Type": "text/css", "ETag": `"abc"`},
+			want:     "gzip",
+			wantETag: `W/"abc"`,
+		},
+		{
+			name:     "weak ETag is kept",
+			accept:   "gzip",
+			header:   map[string]string{"Content-Type": "text/css", "ETag": `W/"abc"`},
+			want:     "gzip",
+			wantETag: `W/"abc"`,
+		},
+		{
+			name:   "client doesn't accept it",
+			accept: "br",
+			header: map[string]string{"Content-Type": "text/html"},
+		},
+		{
+			name:   "already compressed type",
+			accept: "gzip",
+			header: map[string]string{"Content-Type": "image/png"},
+		},
+		{
+			name:   "already encoded",
+			accept: "gzip",
+			header: map[string]string{"Content-Type": "text/html", "Content-Encoding": "br"},
+			want:   "br",
+		},
+		{
+			name:   "no-transform",
+			accept: "gzip",
+			header: map[string]string{"Content-Type": "text/html", "Cache-Control": "public, no-transform"},
+		},
+		{
+			name:   "smaller than the minimum",
+			accept: "gzip",
+			header: map[string]string{"Content-Type": "text/html", "Content-Length": "10"},
+			body:   "small body",
+		},
+		{
+			name:   "HEAD",
+			method: http.MethodHead,
+			accept: "gzip",
+			header: map[string]string{"Content-Type": "text/html"},
+		},
+		{
+			name:     "range request",
+			accept:   "gzip",
+			reqRange: "bytes=0-99",
+			header:   map[string]string{"Content-Type": "text/html"},
+		},
+		{
+			name:   "not modified",
+			accept: "gzip",
+			status: http.StatusNotModified,
+			header: map[string]string{"Content-Type": "text/html"},
+			body:   "-",
+		},
+		{
+			name:   "no content",
+			accept: "gzip",
+			status: http.StatusNoContent,
+			body:   "-",
+		},
+	}
+
+	c, err := newCompression(&config.Compression{Algorithms: []string{"zstd", "gzip"}, MinSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := body
+			if tt.body != "" {
+				want = tt.body
+			}
+			if tt.body == "-" {
+				want = ""
+			}
+
+			h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				if want != "" && r.Method != http.MethodHead {
+					_, _ = io.WriteString(w, want)
+				}
+			}))
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			if tt.reqRange != "" {
+				r.Header.Set("Range", tt.reqRange)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if got := resp.Header.Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+
+			if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q", got)
+			}
+
+			if tt.wantETag != "" && resp.Header.Get("ETag") != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", resp.Header.Get("ETag"), tt.wantETag)
+			}
+
+			if method == http.MethodHead || tt.want == "br" {
+				return
+			}
+
+			got := decode(t, resp, tt.want)
+			if got != want {
+				t.Errorf("body = %d bytes, want %d", len(got), len(want))
+			}
+
+			if tt.want != "" && resp.Header.Get("Content-Length") != "" {
+				t.Errorf("Content-Length = %s on a compressed response", resp.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func decode(t *testing.T, resp *http.Response, encoding string) string {
+	t.Helper()
+
+	var r io.Reader = resp.Body
+
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestCompressionReusesEncoders(t *testing.T) {
+	c, err := newCompression(&config.Compression{Algorithms: []string{"gzip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, r.URL.Query().Get("v"))
+	}))
+
+	// Each response is complete on its own, so pooled writers are reset between them
+	for i := range 5 {
+		v := strconv.Itoa(i)
+
+		r := httptest.NewRequest(http.MethodGet, "/?v="+v, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if got := decode(t, w.Result(), "gzip"); got != v {
+			t.Errorf("response %d = %q, want %q", i, got, v)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"text/html; charset=utf-8":         true,
+		"application/json":                 true,
+		"application/vnd.api+json":         true,
+		"application/atom+xml":             true,
+		"image/svg+xml":                    true,
+		"image/png":                        false,
+		"application/zip":                  false,
+		"application/octet-stream":         false,
+		"":                                 false,
+		"not a media type; charset=utf-8=": false,
+	} {
+		if got := compressible(ct); got != want {
+			t.Errorf("compressible(%q) = %v, want %v", ct, got, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenQUIC opens a UDP socket on each HTTPS address, for serving HTTP/3
+func (w *WebServer) listenQUIC(addrs []string) ([]net.PacketConn, error) {
+	var conns []net.PacketConn
+
+	for _, addr := range addrs {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, fmt.Errorf("listen on udp %s: %w", addr, err)
+		}
+
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
+
+// http3Server counts the QUIC listeners being served. http3.Server keeps its Alt-Svc header after it is closed, so
+// the count decides whether HTTP/3 is advertised.
+type http3Server struct {
+	*http3.Server
+	serving atomic.Int32
+}
+
+// newHTTP3Server serves the handler over QUIC, with the same certificates as HTTPS. Port is advertised in Alt-Svc
+// instead of the listen ports when it is set.
+func newHTTP3Server(tlsConfig *tls.Config, handler http.Handler, port int) *http3Server {
+	return &http3Server{Server: &http3.Server{
+		Handler:     handler,
+		TLSConfig:   tlsConfig,
+		IdleTimeout: 5 * time.Minute,
+		Port:        port,
+	}}
+}
+
+func (s *http3Server) Serve(conn net.PacketConn) error {
+	s.serving.Add(1)
+	defer s.serving.Add(-1)
+
+	return s.Server.Serve(conn)
+}
+
+// altSvc advertises HTTP/3 on HTTPS responses, so browsers switch to it for later requests. It is only advertised
+// while a QUIC listener is being served, as browsers remember it for a month.
+func altSvc(h3 *http3Server, log *log.Entry, next http.Handler) http.Handler {
+	var once sync.Once
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 && h3.serving.Load() > 0 {
+			// ErrNoAltSvcPort means Serve hasn't set up its listener yet
+			if err := h3.SetQUICHeaders(w.Header()); err != nil && !errors.Is(err, http3.ErrNoAltSvcPort) {
+				once.Do(func() { log.WithError(err).Warn("failed to advertise HTTP/3") })
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+func TestAltSvc(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     int
+		wantPort func(listen int) int
+	}{
+		{name: "listen port", wantPort: func(listen int) int { return listen }},
+		{name: "configured port", port: 8443, wantPort: func(int) int { return 8443 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			h3 := newHTTP3Server(&tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}, http.NotFoundHandler(), tt.port)
+			handler := altSvc(h3, (&log.Logger{Handler: discard.Default}).WithField("component", "webserver"),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			// Nothing is advertised until the QUIC listener is up
+			if got := altSvcHeader(handler, 2); got != "" {
+				t.Fatalf("Alt-Svc = %q before serving HTTP/3", got)
+			}
+
+			served := make(chan error, 1)
+			go func() { served <- h3.Serve(conn) }()
+
+			want := fmt.Sprintf(`h3=":%d"`, tt.wantPort(conn.LocalAddr().(*net.UDPAddr).Port))
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !strings.Contains(altSvcHeader(handler, 2), want) {
+				if time.Now().After(deadline) {
+					t.Fatalf("Alt-Svc = %q, want it to contain %s", altSvcHeader(handler, 2), want)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// HTTP/3 requests are already on it
+			if got := altSvcHeader(handler, 3); got != "" {
+				t.Errorf("Alt-Svc = %q on an HTTP/3 request", got)
+			}
+
+			if err := h3.Close(); err != nil {
+				t.Fatal(err)
+			}
+			<-served
+
+			if got := altSvcHeader(handler, 2); got != "" {
+				t.Errorf("Alt-Svc = %q after HTTP/3 stopped", got)
+			}
+		})
+	}
+}
+
+func altSvcHeader(h http.Handler, protoMajor int) string {
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	r.ProtoMajor = protoMajor
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w.Header().Get("Alt-Svc")
+}
+
+// testCertificate is a self-signed certificate for localhost
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,194 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts the PROXY protocol from trusted proxies, so the real client address is known even when a
+// load balancer forwards the TCP connection or TLS is terminated here
+type proxyListener struct {
+	net.Listener
+	trusted *IPSet
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	// Headers from anyone else are not believed, and are left for the TLS or HTTP server to reject
+	if !l.trusted.ContainsAddr(c.RemoteAddr().String()) {
+		return c, nil
+	}
+
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn reads the PROXY header on first use, rather than in Accept, so a slow peer can't block other connections
+type proxyConn struct {
+	net.Conn
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+
+	// deadline is the read deadline set by the server, restored once the header is read
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// Peer is the address of the proxy itself, rather than the client the header names
+func (c *proxyConn) Peer() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+type peerKey struct{}
+
+// connContext keeps the peer of connections through the PROXY protocol, as their RemoteAddr is the client. Trust in
+// the headers the proxy sets is decided from the peer.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+
+	if pc, ok := c.(*proxyConn); ok {
+		return context.WithValue(ctx, peerKey{}, pc.Peer().String())
+	}
+
+	return ctx
+}
+
+func (c *proxyConn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		_ = c.Conn.SetReadDeadline(c.deadline)
+	}()
+
+	if sig, err := c.r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(sig, proxyV2Signature) {
+		c.remote, c.err = readProxyV2(c.r)
+		return
+	}
+
+	if start, err := c.r.Peek(6); err == nil && string(start) == "PROXY " {
+		c.remote, c.err = readProxyV1(c.r)
+		return
+	}
+
+	// No header, the proxy connected directly (a health check for example)
+}
+
+// readProxyV1 parses a text header, such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The longest valid v1 header is 107 bytes
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > 107 {
+		return nil, fmt.Errorf("invalid PROXY v1 header")
+	}
+
+	fields := strings.Fields(strings.TrimRight(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source address")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 parses a binary header. Only the source address is used, any TLVs are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", hdr[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections are health checks from the proxy itself
+	if hdr[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short PROXY v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short PROXY v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	// AF_UNSPEC or unix sockets, keep the proxy's address
+	return nil, nil
+}
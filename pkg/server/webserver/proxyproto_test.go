@@ -0,0 +1,280 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a v2 header, with the command in the low bits of verCmd and the family in the high bits of fam
+func proxyV2(verCmd, fam byte, body []byte) []byte {
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, verCmd, fam)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+
+	return append(hdr, body...)
+}
+
+func v2Addrs(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	body := append(append([]byte{}, src...), dst...)
+	body = binary.BigEndian.AppendUint16(body, srcPort)
+	return binary.BigEndian.AppendUint16(body, dstPort)
+}
+
+func TestProxyHeader(t *testing.T) {
+	v4 := v2Addrs(net.ParseIP("192.0.2.1").To4(), net.ParseIP("198.51.100.1").To4(), 56324, 443)
+	v6 := v2Addrs(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 443)
+
+	// An ALPN and an authority TLV after the addresses
+	tlvs := append(append([]byte{}, v4...), 0x01, 0x00, 0x02, 'h', '2', 0x02, 0x00, 0x0b)
+	tlvs = append(tlvs, "example.com"...)
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "v1 TCP4",
+			header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			want:   "192.0.2.1:56324",
+		},
+		{
+			name:   "v1 TCP6",
+			header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			want:   "[2001:db8::1]:56324",
+		},
+		{
+			name:   "v1 UNKNOWN keeps the proxy address",
+			header: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:    "v1 invalid source address",
+			header:  []byte("PROXY TCP4 192.0.2.300 198.51.100.1 56324 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 invalid port",
+			header:  []byte("PROXY TCP4 192.0.2.1 198.51.100.1 port 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 missing fields",
+			header:  []byte("PROXY TCP4 192.0.2.1 198.51.100.1\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 unknown protocol",
+			header:  []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 truncated",
+			header:  []byte("PROXY TCP4 192.0.2.1 198.51"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 too long",
+			header:  []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443" + strings.Repeat(" ", 100) + "\r\n"),
+			wantErr: true,
+		},
+		{
+			name:   "v2 TCP4",
+			header: proxyV2(0x21, 0x11, v4),
+			want:   "192.0.2.1:56324",
+		},
+		{
+			name:   "v2 TCP6",
+			header: proxyV2(0x21, 0x21, v6),
+			want:   "[2001:db8::1]:56324",
+		},
+		{
+			name:   "v2 TLVs are skipped",
+			header: proxyV2(0x21, 0x11, tlvs),
+			want:   "192.0.2.1:56324",
+		},
+		{
+			name:   "v2 LOCAL keeps the proxy address",
+			header: proxyV2(0x20, 0x00, nil),
+		},
+		{
+			name:   "v2 LOCAL with addresses keeps the proxy address",
+			header: proxyV2(0x20, 0x11, v4),
+		},
+		{
+			name:   "v2 unix socket keeps the proxy address",
+			header: proxyV2(0x21, 0x31, make([]byte, 216)),
+		},
+		{
+			name:    "v2 unsupported version",
+			header:  proxyV2(0x11, 0x11, v4),
+			wantErr: true,
+		},
+		{
+			name:    "v2 truncated header",
+			header:  proxyV2(0x21, 0x11, nil)[:14],
+			wantErr: true,
+		},
+		{
+			name:    "v2 truncated body",
+			header:  proxyV2(0x21, 0x11, v4)[:20],
+			wantErr: true,
+		},
+		{
+			name:    "v2 short TCP4 address",
+			header:  proxyV2(0x21, 0x11, v4[:8]),
+			wantErr: true,
+		},
+		{
+			name:    "v2 short TCP6 address",
+			header:  proxyV2(0x21, 0x21, v4),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Anything after a valid header is the client's data, the invalid ones are sent alone so a truncated
+			// header can't be completed by it
+			payload := []byte("GET / HTTP/1.1\r\n\r\n")
+
+			data := tt.header
+			if !tt.wantErr {
+				data = append(append([]byte{}, tt.header...), payload...)
+			}
+
+			c, proxy := pipeConn(t, data)
+
+			got, err := io.ReadAll(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, payload) {
+				t.Errorf("read %q, want %q", got, payload)
+			}
+
+			want := tt.want
+			if want == "" {
+				want = proxy.String()
+			}
+
+			if addr := c.RemoteAddr().String(); addr != want {
+				t.Errorf("RemoteAddr() = %s, want %s", addr, want)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderNone(t *testing.T) {
+	// A health check from the proxy, straight to HTTP
+	data := []byte("GET /healthz HTTP/1.1\r\n\r\n")
+	c, proxy := pipeConn(t, data)
+
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %q, want %q", got, data)
+	}
+
+	if c.RemoteAddr() != proxy {
+		t.Errorf("RemoteAddr() = %s, want the proxy's %s", c.RemoteAddr(), proxy)
+	}
+}
+
+// pipeConn returns a proxyConn that reads data, and the address of the proxy it is connected to
+func pipeConn(t *testing.T, data []byte) (*proxyConn, net.Addr) {
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+
+	go func() {
+		_, _ = client.Write(data)
+		client.Close()
+	}()
+
+	return &proxyConn{Conn: server, r: bufio.NewReader(server)}, server.RemoteAddr()
+}
+
+func TestProxyListener(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+
+	tests := []struct {
+		name     string
+		trusted  []string
+		wantAddr string
+		wantData string
+	}{
+		{
+			name:     "trusted proxy",
+			trusted:  []string{"127.0.0.1"},
+			wantAddr: "192.0.2.1",
+			wantData: "hello",
+		},
+		{
+			// The header is passed through untouched, for the server to reject as a bad request
+			name:     "untrusted peer",
+			trusted:  []string{"203.0.113.0/24"},
+			wantAddr: "127.0.0.1",
+			wantData: header + "hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := NewIPSet(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			pl := &proxyListener{Listener: ln, trusted: trusted}
+
+			go func() {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					return
+				}
+				_, _ = c.Write([]byte(header + "hello"))
+				c.Close()
+			}()
+
+			c, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			got, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.wantData {
+				t.Errorf("read %q, want %q", got, tt.wantData)
+			}
+
+			if host := hostOnly(c.RemoteAddr().String()); host != tt.wantAddr {
+				t.Errorf("RemoteAddr() = %s, want %s", host, tt.wantAddr)
+			}
+		})
+	}
+}
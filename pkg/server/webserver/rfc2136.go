@@ -0,0 +1,125 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+// rfc2136Provider is a libdns provider that creates and removes records with RFC 2136 dynamic updates,
+// signed with a TSIG key
+type rfc2136Provider struct {
+	server  string
+	keyName string
+	keyAlg  string
+	key     string
+}
+
+func newRFC2136Provider(cfg *config.RFC2136) (*rfc2136Provider, error) {
+	if cfg == nil || cfg.Server == "" {
+		return nil, fmt.Errorf("rfc2136 server is required")
+	}
+
+	alg := cfg.KeyAlg
+	if alg == "" {
+		alg = "hmac-sha256"
+	}
+
+	return &rfc2136Provider{
+		server:  cfg.Server,
+		keyName: dns.Fqdn(cfg.KeyName),
+		keyAlg:  dns.Fqdn(alg),
+		key:     cfg.Key,
+	}, nil
+}
+
+// AppendRecords implements libdns.RecordAppender
+func (p *rfc2136Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rrs, err := toRRs(zone, recs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	m.Insert(rrs)
+
+	if err := p.exchange(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// DeleteRecords implements libdns.RecordDeleter. Only records matching the value are removed, so concurrent
+// challenges for the same name don't remove each other's records.
+func (p *rfc2136Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rrs, err := toRRs(zone, recs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	m.Remove(rrs)
+
+	if err := p.exchange(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+func (p *rfc2136Provider) exchange(ctx context.Context, m *dns.Msg) error {
+	c := &dns.Client{Net: "tcp", Timeout: 30 * time.Second}
+
+	if p.keyName != "." && p.key != "" {
+		c.TsigSecret = map[string]string{p.keyName: p.key}
+		m.SetTsig(p.keyName, p.keyAlg, 300, time.Now().Unix())
+	}
+
+	r, _, err := c.ExchangeContext(ctx, m, p.server)
+	if err != nil {
+		return err
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("dns update to %s failed: %s", p.server, dns.RcodeToString[r.Rcode])
+	}
+
+	return nil
+}
+
+func toRRs(zone string, recs []libdns.Record) ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(recs))
+
+	for _, rec := range recs {
+		hdr := dns.RR_Header{
+			Name:  dns.Fqdn(libdns.AbsoluteName(rec.Name, zone)),
+			Class: dns.ClassINET,
+			Ttl:   uint32(rec.TTL.Seconds()),
+		}
+
+		// TXT values are quoted in zone file syntax, so build them directly
+		if strings.EqualFold(rec.Type, "TXT") {
+			hdr.Rrtype = dns.TypeTXT
+			rrs = append(rrs, &dns.TXT{Hdr: hdr, Txt: []string{rec.Value}})
+			continue
+		}
+
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", hdr.Name, hdr.Ttl, rec.Type, rec.Value))
+		if err != nil {
+			return nil, err
+		}
+
+		rrs = append(rrs, rr)
+	}
+
+	return rrs, nil
+}
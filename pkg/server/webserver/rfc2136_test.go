@@ -0,0 +1,314 @@
+package webserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/node-isp/node-isp/pkg/config"
+)
+
+func TestToRRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		rec     libdns.Record
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "relative challenge record",
+			zone: "example.com.",
+			rec:  libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token", TTL: 2 * time.Minute},
+			want: "_acme-challenge.example.com.\t120\tIN\tTXT\t\"token\"",
+		},
+		{
+			name: "zone without a trailing dot",
+			zone: "example.com",
+			rec:  libdns.Record{Type: "TXT", Name: "_acme-challenge.www", Value: "token"},
+			want: "_acme-challenge.www.example.com.\t0\tIN\tTXT\t\"token\"",
+		},
+		{
+			name: "TXT value with spaces and quotes is kept as one string",
+			zone: "example.com.",
+			rec:  libdns.Record{Type: "txt", Name: "@", Value: `a "quoted" value; with spaces`},
+			want: "example.com.\t0\tIN\tTXT\t\"a \\\"quoted\\\" value; with spaces\"",
+		},
+		{
+			name: "A record",
+			zone: "example.com.",
+			rec:  libdns.Record{Type: "A", Name: "www", Value: "192.0.2.1", TTL: time.Minute},
+			want: "www.example.com.\t60\tIN\tA\t192.0.2.1",
+		},
+		{
+			name: "CNAME record",
+			zone: "example.com.",
+			rec:  libdns.Record{Type: "CNAME", Name: "_acme-challenge", Value: "challenges.example.net."},
+			want: "_acme-challenge.example.com.\t0\tIN\tCNAME\tchallenges.example.net.",
+		},
+		{
+			name:    "invalid A value",
+			zone:    "example.com.",
+			rec:     libdns.Record{Type: "A", Name: "www", Value: "not-an-ip"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			zone:    "example.com.",
+			rec:     libdns.Record{Type: "NOPE", Name: "www", Value: "x"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rrs, err := toRRs(tt.zone, []libdns.Record{tt.rec})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toRRs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(rrs) != 1 || rrs[0].String() != tt.want {
+				t.Errorf("toRRs() = %v, want %s", rrs, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRFC2136Provider(t *testing.T) {
+	if _, err := newRFC2136Provider(nil); err == nil {
+		t.Error("no error without a config")
+	}
+
+	if _, err := newRFC2136Provider(&config.RFC2136{}); err == nil {
+		t.Error("no error without a server")
+	}
+
+	p, err := newRFC2136Provider(&config.RFC2136{Server: "ns1.example.com:53", KeyName: "acme", Key: "c2VjcmV0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.keyName != "acme." || p.keyAlg != dns.HmacSHA256 {
+		t.Errorf("key name = %s, alg = %s", p.keyName, p.keyAlg)
+	}
+}
+
+const testTSIGKey = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+
+// updateServer is a name server that applies TSIG signed RFC 2136 updates to an in memory zone
+type updateServer struct {
+	mu      sync.Mutex
+	records []dns.RR
+}
+
+func (s *updateServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	tsig := r.IsTsig()
+
+	switch {
+	case tsig == nil || w.TsigStatus() != nil:
+		m.Rcode = dns.RcodeNotAuth
+	case r.Opcode != dns.OpcodeUpdate:
+		m.Rcode = dns.RcodeRefused
+	default:
+		s.apply(r.Ns)
+	}
+
+	if tsig != nil && w.TsigStatus() == nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+
+	_ = w.WriteMsg(m)
+}
+
+func (s *updateServer) apply(rrs []dns.RR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rr := range rrs {
+		switch rr.Header().Class {
+		case dns.ClassINET:
+			s.records = append(s.records, dns.Copy(rr))
+		case dns.ClassNONE:
+			// Remove the record with this value only
+			s.records = slices.DeleteFunc(s.records, func(have dns.RR) bool {
+				want := dns.Copy(rr)
+				want.Header().Class = dns.ClassINET
+				want.Header().Ttl = have.Header().Ttl
+				return dns.IsDuplicate(have, want)
+			})
+		}
+	}
+}
+
+func (s *updateServer) txt(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var values []string
+	for _, rr := range s.records {
+		if txt, ok := rr.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, name) {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+
+	return values
+}
+
+func startUpdateServer(t *testing.T) (*updateServer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zone := &updateServer{}
+	started := make(chan struct{})
+
+	srv := &dns.Server{
+		Listener:          l,
+		Net:               "tcp",
+		Handler:           zone,
+		TsigSecret:        map[string]string{"acme.": testTSIGKey},
+		NotifyStartedFunc: func() { close(started) },
+		// The default accept func only takes queries and notifies
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	<-started
+
+	return zone, l.Addr().String()
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	zone, addr := startUpdateServer(t)
+
+	p, err := newRFC2136Provider(&config.RFC2136{Server: addr, KeyName: "acme", Key: testTSIGKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	const name = "_acme-challenge.example.com."
+
+	first := libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "first", TTL: time.Minute}
+	second := libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "second", TTL: time.Minute}
+
+	if _, err := p.AppendRecords(ctx, "example.com.", []libdns.Record{first, second}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	if got := zone.txt(name); !slices.Equal(got, []string{"first", "second"}) {
+		t.Fatalf("records after append = %v", got)
+	}
+
+	// Deleting one challenge's record leaves the other in place
+	if _, err := p.DeleteRecords(ctx, "example.com.", []libdns.Record{first}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	if got := zone.txt(name); !slices.Equal(got, []string{"second"}) {
+		t.Errorf("records after delete = %v, want only the second", got)
+	}
+}
+
+func TestRFC2136ProviderBadKey(t *testing.T) {
+	zone, addr := startUpdateServer(t)
+
+	p, err := newRFC2136Provider(&config.RFC2136{Server: addr, KeyName: "acme", Key: "d3Jvbmcta2V5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.AppendRecords(context.Background(), "example.com.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "x"}})
+	if err == nil {
+		t.Error("update signed with the wrong key succeeded")
+	}
+
+	if got := zone.txt("_acme-challenge.example.com."); len(got) != 0 {
+		t.Errorf("records = %v, want none", got)
+	}
+}
+
+// TestRFC2136BIND runs the provider against a real name server, such as BIND with a zone that allows updates
+// signed with the key:
+//
+//	RFC2136_SERVER=127.0.0.1:53
+//	RFC2136_ZONE=example.test.
+//	RFC2136_KEY_NAME=acme
+//	RFC2136_KEY=<base64 hmac-sha256 secret>
+func TestRFC2136BIND(t *testing.T) {
+	server := os.Getenv("RFC2136_SERVER")
+	if server == "" {
+		t.Skip("RFC2136_SERVER is not set, skipping the BIND test")
+	}
+
+	zone := os.Getenv("RFC2136_ZONE")
+
+	p, err := newRFC2136Provider(&config.RFC2136{
+		Server:  server,
+		KeyName: os.Getenv("RFC2136_KEY_NAME"),
+		KeyAlg:  os.Getenv("RFC2136_KEY_ALG"),
+		Key:     os.Getenv("RFC2136_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	value := "nodeisp-test-" + time.Now().Format("20060102150405")
+	rec := libdns.Record{Type: "TXT", Name: "_acme-challenge.nodeisp-test", Value: value, TTL: time.Minute}
+	name := dns.Fqdn(libdns.AbsoluteName(rec.Name, zone))
+
+	if _, err := p.AppendRecords(ctx, zone, []libdns.Record{rec}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	if !slices.Contains(queryTXT(t, server, name), value) {
+		t.Errorf("%s doesn't have the TXT record after the update", name)
+	}
+
+	if _, err := p.DeleteRecords(ctx, zone, []libdns.Record{rec}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	if slices.Contains(queryTXT(t, server, name), value) {
+		t.Errorf("%s still has the TXT record after the delete", name)
+	}
+}
+
+func queryTXT(t *testing.T, server, name string) []string {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+
+	r, _, err := (&dns.Client{Net: "tcp"}).Exchange(m, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+
+	return values
+}
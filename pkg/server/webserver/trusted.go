@@ -0,0 +1,159 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPSet is a list of networks, such as the trusted proxies or an access allowlist
+type IPSet struct {
+	nets []*net.IPNet
+}
+
+// NewIPSet parses a list of IPs and CIDRs
+func NewIPSet(cidrs []string) (*IPSet, error) {
+	s := &IPSet{}
+
+	for _, c := range cidrs {
+		// Allow single addresses as well as networks
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", c)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+
+			s.nets = append(s.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+
+		s.nets = append(s.nets, n)
+	}
+
+	return s, nil
+}
+
+func (s *IPSet) Contains(ip net.IP) bool {
+	if s == nil || ip == nil {
+		return false
+	}
+
+	for _, n := range s.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsAddr checks the IP of a host:port address, as found in RemoteAddr
+func (s *IPSet) ContainsAddr(addr string) bool {
+	return s.Contains(net.ParseIP(hostOnly(addr)))
+}
+
+type clientKey struct{}
+
+// client is the original client of a request, once any trusted proxies have been accounted for
+type client struct {
+	ip     string
+	scheme string
+
+	// trusted is set when the request came through a trusted proxy
+	trusted bool
+}
+
+// middleware resolves the client address and scheme of each request. Forwarding headers are only believed when the
+// peer is a trusted proxy, and X-Forwarded-For is walked from the right so a client can't spoof its own address.
+func (s *IPSet) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := &client{ip: hostOnly(r.RemoteAddr), scheme: "http"}
+		if r.TLS != nil {
+			c.scheme = "https"
+		}
+
+		// Through the PROXY protocol the peer is the proxy, and RemoteAddr the client it named
+		peer, proxied := r.Context().Value(peerKey{}).(string)
+		if !proxied {
+			peer = r.RemoteAddr
+		}
+
+		if s.ContainsAddr(peer) {
+			c.trusted = true
+
+			// The client named by a PROXY header can't add to the chain, unless it is a trusted proxy too
+			var forwarded []string
+			if !proxied || s.ContainsAddr(r.RemoteAddr) {
+				forwarded = r.Header.Values("X-Forwarded-For")
+			}
+
+			var hops []string
+			for _, h := range forwarded {
+				for _, hop := range strings.Split(h, ",") {
+					if hop = strings.TrimSpace(hop); hop != "" {
+						hops = append(hops, hop)
+					}
+				}
+			}
+
+			for i := len(hops) - 1; i >= 0; i-- {
+				ip := net.ParseIP(hostOnly(hops[i]))
+				if ip == nil {
+					break
+				}
+
+				c.ip = ip.String()
+				if !s.Contains(ip) {
+					break
+				}
+			}
+
+			switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+			case "http", "https":
+				c.scheme = proto
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, c)))
+	})
+}
+
+// ClientIP returns the address of the client that made the request, after trusted proxies are accounted for
+func ClientIP(r *http.Request) string {
+	if c, ok := r.Context().Value(clientKey{}).(*client); ok {
+		return c.ip
+	}
+
+	return hostOnly(r.RemoteAddr)
+}
+
+// Scheme returns the scheme the client used to make the request, http or https
+func Scheme(r *http.Request) string {
+	if c, ok := r.Context().Value(clientKey{}).(*client); ok {
+		return c.scheme
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// TrustedProxy reports whether the request came through a trusted proxy, so headers it sets can be believed
+func TrustedProxy(r *http.Request) bool {
+	c, ok := r.Context().Value(clientKey{}).(*client)
+	return ok && c.trusted
+}
@@ -0,0 +1,363 @@
+package webserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewIPSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		in      []string
+		out     []string
+		wantErr bool
+	}{
+		{
+			name:  "single addresses",
+			cidrs: []string{"192.0.2.1", "2001:db8::1"},
+			in:    []string{"192.0.2.1", "::ffff:192.0.2.1", "2001:db8::1"},
+			out:   []string{"192.0.2.2", "2001:db8::2"},
+		},
+		{
+			name:  "networks",
+			cidrs: []string{"10.0.0.0/8", "2001:db8::/32"},
+			in:    []string{"10.1.2.3", "2001:db8:ffff::1"},
+			out:   []string{"11.0.0.1", "2001:db9::1"},
+		},
+		{
+			name: "empty",
+			out:  []string{"127.0.0.1", "::1"},
+		},
+		{
+			name:    "invalid address",
+			cidrs:   []string{"192.0.2.300"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid network",
+			cidrs:   []string{"10.0.0.0/33"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewIPSet(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewIPSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			for _, ip := range tt.in {
+				if !s.Contains(net.ParseIP(ip)) {
+					t.Errorf("%s is not in the set", ip)
+				}
+			}
+
+			for _, ip := range tt.out {
+				if s.Contains(net.ParseIP(ip)) {
+					t.Errorf("%s is in the set", ip)
+				}
+			}
+		})
+	}
+}
+
+func TestIPSetContainsAddr(t *testing.T) {
+	s, err := NewIPSet([]string{"192.0.2.0/24", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, want := range map[string]bool{
+		"192.0.2.1:443":      true,
+		"192.0.2.1":          true,
+		"[2001:db8::1]:443":  true,
+		"198.51.100.1:443":   false,
+		"not-an-address:443": false,
+		"":                   false,
+	} {
+		if got := s.ContainsAddr(addr); got != want {
+			t.Errorf("ContainsAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+
+	var nilSet *IPSet
+	if nilSet.ContainsAddr("192.0.2.1:443") {
+		t.Error("a nil set contains an address")
+	}
+}
+
+func TestTrustedMiddleware(t *testing.T) {
+	trusted, err := NewIPSet([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		remote      string
+		tls         bool
+		xff         []string
+		proto       string
+		wantIP      string
+		wantScheme  string
+		wantTrusted bool
+	}{
+		{
+			name:       "direct client",
+			remote:     "203.0.113.7:5000",
+			wantIP:     "203.0.113.7",
+			wantScheme: "http",
+		},
+		{
+			name:       "direct TLS client",
+			remote:     "203.0.113.7:5000",
+			tls:        true,
+			wantIP:     "203.0.113.7",
+			wantScheme: "https",
+		},
+		{
+			name:       "untrusted peer can't set its address or scheme",
+			remote:     "203.0.113.7:5000",
+			xff:        []string{"198.51.100.1"},
+			proto:      "https",
+			wantIP:     "203.0.113.7",
+			wantScheme: "http",
+		},
+		{
+			name:        "trusted proxy",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"203.0.113.7"},
+			proto:       "https",
+			wantIP:      "203.0.113.7",
+			wantScheme:  "https",
+			wantTrusted: true,
+		},
+		{
+			name:        "spoofed left-most entry is ignored",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"198.51.100.1, 203.0.113.7"},
+			wantIP:      "203.0.113.7",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "spoofed entry in its own header is ignored",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"198.51.100.1", "203.0.113.7"},
+			wantIP:      "203.0.113.7",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "chain of trusted proxies",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"198.51.100.1, 203.0.113.7, 10.0.0.3, 10.0.0.2"},
+			wantIP:      "203.0.113.7",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "spoofed trusted address on the left is never reached",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"10.9.9.9, 203.0.113.7"},
+			wantIP:      "203.0.113.7",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "entries with ports",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"[2001:db8::1]:443"},
+			wantIP:      "2001:db8::1",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "garbage stops the walk",
+			remote:      "10.0.0.1:5000",
+			xff:         []string{"203.0.113.7, not-an-ip, 10.0.0.2"},
+			wantIP:      "10.0.0.2",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "trusted proxy without a header",
+			remote:      "10.0.0.1:5000",
+			wantIP:      "10.0.0.1",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "unknown scheme is ignored",
+			remote:      "10.0.0.1:5000",
+			tls:         true,
+			proto:       "gopher",
+			wantIP:      "10.0.0.1",
+			wantScheme:  "https",
+			wantTrusted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for _, h := range tt.xff {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			var ip, scheme string
+			var wasTrusted bool
+
+			trusted.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, scheme, wasTrusted = ClientIP(r), Scheme(r), TrustedProxy(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+
+			if ip != tt.wantIP {
+				t.Errorf("ClientIP() = %s, want %s", ip, tt.wantIP)
+			}
+			if scheme != tt.wantScheme {
+				t.Errorf("Scheme() = %s, want %s", scheme, tt.wantScheme)
+			}
+			if wasTrusted != tt.wantTrusted {
+				t.Errorf("TrustedProxy() = %v, want %v", wasTrusted, tt.wantTrusted)
+			}
+		})
+	}
+}
+
+func TestTrustedProxyProtocol(t *testing.T) {
+	// The load balancer connects from localhost, and names the client in a PROXY header
+	trusted, err := NewIPSet([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		header      string
+		tls         bool
+		xff         string
+		proto       string
+		wantIP      string
+		wantScheme  string
+		wantTrusted bool
+	}{
+		{
+			name:        "TLS terminated by the proxy",
+			header:      "PROXY TCP4 203.0.113.7 127.0.0.1 5000 80\r\n",
+			proto:       "https",
+			wantIP:      "203.0.113.7",
+			wantScheme:  "https",
+			wantTrusted: true,
+		},
+		{
+			name:        "TLS terminated here",
+			header:      "PROXY TCP4 203.0.113.7 127.0.0.1 5000 443\r\n",
+			tls:         true,
+			wantIP:      "203.0.113.7",
+			wantScheme:  "https",
+			wantTrusted: true,
+		},
+		{
+			name:        "client can't add to the chain",
+			header:      "PROXY TCP4 203.0.113.7 127.0.0.1 5000 80\r\n",
+			xff:         "198.51.100.1",
+			proto:       "https",
+			wantIP:      "203.0.113.7",
+			wantScheme:  "https",
+			wantTrusted: true,
+		},
+		{
+			name:        "named a trusted proxy",
+			header:      "PROXY TCP4 10.0.0.2 127.0.0.1 5000 80\r\n",
+			xff:         "203.0.113.7",
+			wantIP:      "203.0.113.7",
+			wantScheme:  "http",
+			wantTrusted: true,
+		},
+		{
+			name:        "no header",
+			proto:       "https",
+			wantIP:      "127.0.0.1",
+			wantScheme:  "https",
+			wantTrusted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip, scheme string
+			var wasTrusted bool
+
+			srv := httptest.NewUnstartedServer(trusted.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, scheme, wasTrusted = ClientIP(r), Scheme(r), TrustedProxy(r)
+			})))
+			srv.Listener = &proxyListener{Listener: srv.Listener, trusted: trusted}
+			srv.Config.ConnContext = connContext
+
+			if tt.tls {
+				srv.StartTLS()
+			} else {
+				srv.Start()
+			}
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(tt.header)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.tls {
+				conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if err := req.Write(conn); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if ip != tt.wantIP {
+				t.Errorf("ClientIP() = %s, want %s", ip, tt.wantIP)
+			}
+			if scheme != tt.wantScheme {
+				t.Errorf("Scheme() = %s, want %s", scheme, tt.wantScheme)
+			}
+			if wasTrusted != tt.wantTrusted {
+				t.Errorf("TrustedProxy() = %v, want %v", wasTrusted, tt.wantTrusted)
+			}
+		})
+	}
+}
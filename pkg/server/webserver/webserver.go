@@ -3,15 +3,18 @@ package webserver
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/caddyserver/certmagic"
+
+	"github.com/node-isp/node-isp/pkg/config"
 )
 
 const (
@@ -24,21 +27,6 @@ const (
 	TLSALPNChallengePort = 443
 )
 
-// Port variables must remain their defaults unless you
-// forward packets from the defaults to whatever these
-// are set to; otherwise ACME challenges will fail.
-var (
-	// HTTPPort is the port on which to serve HTTP
-	// and, as such, the HTTP challenge (unless
-	// Default.AltHTTPPort is set).
-	HTTPPort = 80
-
-	// HTTPSPort is the port on which to serve HTTPS
-	// and, as such, the TLS-ALPN challenge
-	// (unless Default.AltTLSALPNPort is set).
-	HTTPSPort = 443
-)
-
 // Variables for conveniently serving HTTPS.
 var (
 	lnMu   sync.Mutex
@@ -48,15 +36,15 @@ var (
 func New(
 	mux *http.ServeMux,
 	dataDir string,
-	domains []string,
-	email string,
+	cfg *config.HTTPServer,
 	log *log.Entry,
 ) *WebServer {
 	return &WebServer{
 		mux:     mux,
 		dataDir: dataDir,
-		domains: domains,
-		email:   email,
+		domains: cfg.Domains,
+		tls:     cfg.TLS,
+		cfg:     cfg,
 		log:     log,
 	}
 }
@@ -64,7 +52,8 @@ func New(
 type WebServer struct {
 	dataDir string
 	domains []string
-	email   string
+	tls     *config.TLS
+	cfg     *config.HTTPServer
 
 	mux *http.ServeMux
 
@@ -72,31 +61,73 @@ type WebServer struct {
 
 	cache *certmagic.Cache
 	magic *certmagic.Config
+
+	// store serves the certificates from disk, when ACME is not used
+	store *certStore
+
+	// renewErrs are the reasons the last attempt to obtain or renew a certificate failed, by domain
+	renewErrs map[string]error
+
+	// renewedAt is when each domain's certificate was last renewed on request, for the cooldown
+	renewedAt map[string]time.Time
+
+	// events are the recent certificate events, for the event streams
+	events certEvents
+
+	// trusted are the proxies allowed to set the client address, with headers or the PROXY protocol
+	trusted *IPSet
+
+	// mu guards the servers, which are drained by Shutdown, and the certificates
+	mu      sync.Mutex
+	closed  bool
+	servers []*http.Server
+	h3      *http3Server
 }
 
+// Run serves HTTP and HTTPS on the configured listeners, and blocks until one of them fails or the context is
+// done. The ACME challenges are only reachable on the standard ports 80 and 443, so other listen ports need those
+// forwarded to them. Requests in flight keep running when the context is done, until Shutdown drains them.
 func (w *WebServer) Run(ctx context.Context) error {
-	w.cache = certmagic.NewCache(certmagic.CacheOptions{
-		GetConfigForCert: func(cert certmagic.Certificate) (*certmagic.Config, error) {
-			return certmagic.New(w.cache, certmagic.Config{}), nil
-		},
-	})
-
-	w.magic = certmagic.New(w.cache, certmagic.Config{
-		Storage: &certmagic.FileStorage{Path: filepath.Join(w.dataDir, "/certs")},
-	})
+	var err error
+	if w.trusted, err = NewIPSet(w.cfg.TrustedProxies); err != nil {
+		w.log.WithError(err).Fatal("Invalid trusted proxies")
+	}
 
-	myACME := certmagic.NewACMEIssuer(w.magic, certmagic.ACMEIssuer{
-		CA:     certmagic.LetsEncryptProductionCA,
-		Email:  w.email,
-		Agreed: true,
-	})
+	compress, err := newCompression(w.cfg.Compression)
+	if err != nil {
+		w.log.WithError(err).Fatal("Invalid compression config")
+	}
 
-	w.magic.Issuers = []certmagic.Issuer{
-		myACME,
+	if w.cfg.HTTP3Port < 0 || w.cfg.HTTP3Port > 65535 {
+		w.log.WithField("port", w.cfg.HTTP3Port).Fatal("Invalid http3_port")
 	}
 
-	if err := w.magic.ManageSync(context.TODO(), w.domains); err != nil {
-		w.log.WithError(err).Fatal("Failed to manage certificates")
+	handler := w.trusted.middleware(compress.middleware(w.mux))
+
+	var tlsConfig *tls.Config
+	httpHandler := http.Handler(http.HandlerFunc(httpRedirectHandler))
+
+	switch {
+	case w.cfg.PlainHTTP:
+		// TLS is terminated by a load balancer in front of us, so serve the app on the HTTP listeners
+		w.log.Info("serving plain HTTP, TLS must be terminated by a proxy in front of NodeISP")
+		httpHandler = handler
+	case len(w.tls.Certificates) > 0:
+		// Bring your own certificates, ACME is not used at all
+		store, err := newCertStore(w.tls, w.log)
+		if err != nil {
+			w.log.WithError(err).Fatal("Failed to load certificates")
+		}
+
+		go store.watch(ctx)
+
+		w.mu.Lock()
+		w.store = store
+		w.mu.Unlock()
+
+		tlsConfig = &tls.Config{GetCertificate: store.GetCertificate}
+	default:
+		tlsConfig, httpHandler = w.manageCertificates()
 	}
 
 	httpWg.Add(1)
@@ -104,21 +135,42 @@ func (w *WebServer) Run(ctx context.Context) error {
 
 	lnMu.Lock()
 
-	httpLn, err := net.Listen("tcp", fmt.Sprintf(":%d", HTTPPort))
+	httpLns, err := w.listen(w.cfg.Listen.HTTP)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to listen on HTTP port")
 		lnMu.Unlock()
 		return err
 	}
 
-	tlsConfig := w.magic.TLSConfig()
-	tlsConfig.NextProtos = append([]string{"h2", "http/1.1"}, tlsConfig.NextProtos...)
-
-	httpsLn, err := tls.Listen("tcp", fmt.Sprintf(":%d", HTTPSPort), tlsConfig)
-	if err != nil {
-		log.WithError(err).Fatal("Failed to listen on HTTPS port")
-		lnMu.Unlock()
-		return err
+	var httpsLns []net.Listener
+	var quicConns []net.PacketConn
+
+	if tlsConfig != nil {
+		tlsConfig.NextProtos = append([]string{"h2", "http/1.1"}, tlsConfig.NextProtos...)
+
+		// Client certificates are checked against each access policy's CAs by the proxy, not during the handshake
+		if w.cfg.Proxy.ClientCerts() {
+			tlsConfig.ClientAuth = tls.RequestClientCert
+		}
+
+		lns, err := w.listen(w.cfg.Listen.HTTPS)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to listen on HTTPS port")
+			lnMu.Unlock()
+			return err
+		}
+
+		for _, ln := range lns {
+			httpsLns = append(httpsLns, tls.NewListener(ln, tlsConfig))
+		}
+
+		if w.cfg.HTTP3 {
+			if quicConns, err = w.listenQUIC(w.cfg.Listen.HTTPS); err != nil {
+				log.WithError(err).Fatal("Failed to listen on HTTP/3 port")
+				lnMu.Unlock()
+				return err
+			}
+		}
 	}
 
 	go func() {
@@ -129,29 +181,161 @@ func (w *WebServer) Run(ctx context.Context) error {
 
 	lnMu.Unlock()
 
+	// Requests get a context that outlives ctx, so they can finish while the servers are drained
+	baseCtx := context.WithoutCancel(ctx)
+
 	httpServer := &http.Server{
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       5 * time.Second,
 		WriteTimeout:      5 * time.Second,
 		IdleTimeout:       5 * time.Second,
-		BaseContext:       func(listener net.Listener) context.Context { return ctx },
+		BaseContext:       func(listener net.Listener) context.Context { return baseCtx },
+		ConnContext:       connContext,
 	}
 
-	httpServer.Handler = myACME.HTTPChallengeHandler(http.HandlerFunc(httpRedirectHandler))
+	httpServer.Handler = httpHandler
 
 	httpsServer := &http.Server{
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      2 * time.Minute,
 		IdleTimeout:       5 * time.Minute,
-		Handler:           w.mux,
-		BaseContext:       func(listener net.Listener) context.Context { return ctx },
+		Handler:           handler,
+		BaseContext:       func(listener net.Listener) context.Context { return baseCtx },
+		ConnContext:       connContext,
+	}
+
+	// In plain HTTP mode the app is served on the HTTP listeners, so it needs the longer timeouts
+	if w.cfg.PlainHTTP {
+		httpServer = httpsServer
+	}
+
+	errs := make(chan error, len(httpLns)+len(httpsLns)+len(quicConns))
+
+	var h3 *http3Server
+	if len(quicConns) > 0 {
+		h3 = newHTTP3Server(tlsConfig, handler, w.cfg.HTTP3Port)
+	}
+
+	if !w.track(h3, httpServer, httpsServer) {
+		// Shutdown was called before we got this far, so there is nothing to serve
+		closeListeners(httpLns, httpsLns, quicConns)
+		return http.ErrServerClosed
+	}
+
+	if h3 != nil {
+		for _, conn := range quicConns {
+			w.log.WithField("address", conn.LocalAddr()).Info("serving HTTP/3")
+			go func(conn net.PacketConn) { errs <- h3.Serve(conn) }(conn)
+		}
+
+		httpsServer.Handler = altSvc(h3, w.log, handler)
+	}
+
+	for _, ln := range httpLns {
+		w.log.WithField("address", ln.Addr()).Info("serving HTTP")
+		go func(ln net.Listener) { errs <- httpServer.Serve(ln) }(ln)
+	}
+
+	for _, ln := range httpsLns {
+		w.log.WithField("address", ln.Addr()).Info("serving HTTPS")
+		go func(ln net.Listener) { errs <- httpsServer.Serve(ln) }(ln)
 	}
 
-	// w.log.Infof("Serving HTTP->HTTPS on %s and %s", hln.Addr(), hsln.Addr())
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// track records the servers for Shutdown, and reports false if it has already been called
+func (w *WebServer) track(h3 *http3Server, servers ...*http.Server) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return false
+	}
+
+	for _, srv := range servers {
+		if !slices.Contains(w.servers, srv) {
+			w.servers = append(w.servers, srv)
+		}
+	}
+
+	w.h3 = h3
+
+	return true
+}
+
+// Shutdown stops accepting connections, and waits for the requests in flight to finish. Once the context is done,
+// the remaining connections are closed.
+func (w *WebServer) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	w.closed = true
+	servers, h3 := w.servers, w.h3
+	w.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers)+1)
+
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+
+			if errs[i] = srv.Shutdown(ctx); errs[i] != nil {
+				_ = srv.Close()
+			}
+		}(i, srv)
+	}
+
+	if h3 != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[len(servers)] = h3.Shutdown(ctx)
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func closeListeners(httpLns, httpsLns []net.Listener, quicConns []net.PacketConn) {
+	for _, ln := range append(httpLns, httpsLns...) {
+		ln.Close()
+	}
+
+	for _, conn := range quicConns {
+		conn.Close()
+	}
+}
+
+// listen opens a TCP listener for each address, accepting the PROXY protocol on them if it is enabled
+func (w *WebServer) listen(addrs []string) ([]net.Listener, error) {
+	var lns []net.Listener
+
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", addr, err)
+		}
+
+		if w.cfg.ProxyProtocol {
+			ln = &proxyListener{Listener: ln, trusted: w.trusted}
+		}
+
+		lns = append(lns, ln)
+	}
 
-	go httpServer.Serve(httpLn)
-	return httpsServer.Serve(httpsLn)
+	return lns, nil
 }
 
 func httpRedirectHandler(w http.ResponseWriter, r *http.Request) {
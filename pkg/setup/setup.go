@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/apex/log"
@@ -133,6 +134,11 @@ func Run() error {
 	os.MkdirAll(storageDir, 0755)
 	os.MkdirAll(logDir, 0755)
 
+	mail, err := mailSetup(templates, lic.Domain)
+	if err != nil {
+		return err
+	}
+
 	// Generate the server configuration, and show the user the configuration
 	// before saving it to disk
 
@@ -149,6 +155,7 @@ func Run() error {
 	redispass := base64.StdEncoding.EncodeToString(token)
 
 	cfg := &config.Config{
+		Version: config.CurrentVersion,
 		Licence: &config.Licence{
 			ID:  licenceId,
 			Key: licenceCode,
@@ -177,6 +184,7 @@ func Run() error {
 		Services: &config.Services{
 			GoogleMapsApiKey: "Get your own key :)",
 		},
+		Mail: mail,
 	}
 
 	previewConfig := promptui.Prompt{
@@ -231,7 +239,7 @@ func Run() error {
 		result, _ = confirmPrompt.Run()
 
 		if strings.ToLower(result) == "y" {
-			setupService()
+			InstallService()
 
 			fmt.Println("NodeISP setup complete 🚀🚀🚀")
 			fmt.Println("NodeISP is now running as a service. You can access the admin interface at https://" + lic.Domain + "/admin")
@@ -247,10 +255,113 @@ func Run() error {
 	return nil
 }
 
+// mailSetup asks for the SMTP server the app sends mail through. It can be skipped, and added to the config later.
+func mailSetup(templates *promptui.PromptTemplates, domain string) (*config.Mail, error) {
+	mailPrompt := promptui.Prompt{
+		Label:     "Configure outgoing mail (SMTP) now? ",
+		IsConfirm: true,
+	}
+
+	if result, _ := mailPrompt.Run(); strings.ToLower(result) != "y" {
+		fmt.Println("Skipping mail setup, add a `mail` section to the config to send invoices and password resets")
+		return nil, nil
+	}
+
+	validatePort := func(input string) error {
+		if _, err := strconv.Atoi(input); err != nil {
+			return fmt.Errorf("port must be a number")
+		}
+
+		return nil
+	}
+
+	hostPrompt := promptui.Prompt{
+		Label:     "Enter the SMTP host > ",
+		Templates: templates,
+	}
+
+	tlsSelect := promptui.Select{
+		Label: "SMTP encryption",
+		Items: []string{"starttls", "tls", "none"},
+	}
+
+	usernamePrompt := promptui.Prompt{
+		Label:     "Enter the SMTP username > ",
+		Templates: templates,
+	}
+
+	passwordPrompt := promptui.Prompt{
+		Label:     "Enter the SMTP password > ",
+		Mask:      '*',
+		Templates: templates,
+	}
+
+	fromPrompt := promptui.Prompt{
+		Label:     "Enter the address to send mail from > ",
+		Default:   "noreply@" + domain,
+		Templates: templates,
+	}
+
+	host, err := hostPrompt.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	_, tls, err := tlsSelect.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	defaultPort := "587"
+	if tls == "tls" {
+		defaultPort = "465"
+	}
+
+	portPrompt := promptui.Prompt{
+		Label:     "Enter the SMTP port > ",
+		Default:   defaultPort,
+		Templates: templates,
+		Validate:  validatePort,
+	}
+
+	portString, err := portPrompt.Run()
+	if err != nil {
+		return nil, err
+	}
+	port, _ := strconv.Atoi(portString)
+
+	username, err := usernamePrompt.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := passwordPrompt.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := fromPrompt.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Once NodeISP is running, check the settings with `nodeisp mail test <address>`")
+
+	return &config.Mail{
+		Host:        host,
+		Port:        port,
+		TLS:         tls,
+		Username:    username,
+		Password:    password,
+		FromAddress: from,
+	}, nil
+}
+
 //go:embed nodeisp.service
 var nodeispdService []byte
 
-func setupService() {
+// InstallService writes the systemd unit for the daemon, and enables and starts it
+func InstallService() {
 	// Write the service file
 	err := os.WriteFile("/etc/systemd/system/nodeisp.service", nodeispdService, 0644)
 	if err != nil {